@@ -2,6 +2,8 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
 	"service/internal/application"
 )
 
@@ -15,9 +17,28 @@ func main() {
 	var confPath string
 
 	flag.StringVar(&confPath, "config", "", "yaml config file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-config file] [migrate up|down [steps]|status | shards status|rebalance [batch]|recover]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	app := application.Application{}
+
+	if args := flag.Args(); len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			app.Migrate(confPath, args[1:])
+		case "shards":
+			app.Shards(confPath, args[1:])
+		default:
+			flag.Usage()
+			os.Exit(2)
+		}
+
+		return
+	}
+
 	app.Build(confPath)
 
 	app.Run()
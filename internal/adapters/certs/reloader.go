@@ -0,0 +1,191 @@
+// Package certs serves TLS certificates that are reloaded when their files change.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"service/internal/entities"
+	"sync/atomic"
+	"time"
+)
+
+// reloadDelay lets writers finish replacing cert and key before they are read.
+const reloadDelay = 100 * time.Millisecond
+
+type Options struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile enables client certificate verification.
+	ClientCAFile string
+	// RequireClientCert rejects connections without certificate, otherwise
+	// certificate is verified only when client sends it.
+	RequireClientCert bool
+}
+
+type Reloader struct {
+	log     *zap.SugaredLogger
+	opts    Options
+	config  atomic.Pointer[tls.Config]
+	watcher *fsnotify.Watcher
+}
+
+func NewReloader(log *zap.SugaredLogger, opts Options) (*Reloader, error) {
+	if log == nil {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty logger")
+	}
+
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty certificate or key file")
+	}
+
+	r := &Reloader{
+		log:  log,
+		opts: opts,
+	}
+
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	// directories are watched, as files are usually replaced, not written in place
+	for _, dir := range r.dirs() {
+		if err = watcher.Add(dir); err != nil {
+			_ = watcher.Close()
+			return nil, err
+		}
+	}
+
+	r.watcher = watcher
+
+	go r.watch()
+
+	return r, nil
+}
+
+// TLSConfig returns config that always uses the latest loaded files.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.config.Load(), nil
+		},
+	}
+}
+
+func (r *Reloader) Close() error {
+	return r.watcher.Close()
+}
+
+func (r *Reloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+	if err != nil {
+		return errors.WithMessage(entities.ErrInvalidParam, err.Error())
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if r.opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.opts.ClientCAFile)
+		if err != nil {
+			return errors.WithMessage(entities.ErrInvalidParam, err.Error())
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.WithMessagef(entities.ErrInvalidParam, "no certificates in %s", r.opts.ClientCAFile)
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if r.opts.RequireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	r.config.Store(config)
+
+	return nil
+}
+
+func (r *Reloader) watch() {
+	var reload <-chan time.Time
+
+	for {
+		select {
+		case event, ok := <-r.watcher.Events:
+			if !ok {
+				return
+			}
+
+			if r.watched(event.Name) {
+				reload = time.After(reloadDelay)
+			}
+		case err, ok := <-r.watcher.Errors:
+			if !ok {
+				return
+			}
+
+			r.log.Error(err)
+		case <-reload:
+			if err := r.load(); err != nil {
+				r.log.Errorf("certificates not reloaded, keep previous: %s", err)
+				continue
+			}
+
+			r.log.Info("certificates reloaded")
+		}
+	}
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.opts.CertFile, r.opts.KeyFile}
+	if r.opts.ClientCAFile != "" {
+		files = append(files, r.opts.ClientCAFile)
+	}
+
+	return files
+}
+
+func (r *Reloader) dirs() []string {
+	seen := make(map[string]struct{})
+	dirs := make([]string, 0)
+
+	for _, file := range r.files() {
+		dir := filepath.Dir(file)
+		if _, ok := seen[dir]; !ok {
+			seen[dir] = struct{}{}
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
+
+// watched also matches Kubernetes secret updates, which swap a ..data symlink
+// next to the files.
+func (r *Reloader) watched(name string) bool {
+	if filepath.Base(name) == "..data" {
+		return true
+	}
+
+	for _, file := range r.files() {
+		if filepath.Clean(name) == filepath.Clean(file) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,107 @@
+package jwt
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"math/big"
+	"service/internal/entities"
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+}
+
+// keySet holds verification keys by kid. A token without kid is accepted
+// only when there is exactly one key.
+type keySet struct {
+	keys map[string]interface{}
+}
+
+func singleKey(key interface{}) *keySet {
+	return &keySet{keys: map[string]interface{}{"": key}}
+}
+
+func (s *keySet) get(kid string) (interface{}, error) {
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, nil
+		}
+	}
+
+	return nil, errors.Errorf("unknown key id %q", kid)
+}
+
+// parseJWKS takes keys usable with algorithm: RSA keys for RS256, symmetric
+// (oct) keys for HS256. Keys for other algorithms or uses are skipped.
+func parseJWKS(data []byte, algorithm string) (*keySet, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, err.Error())
+	}
+
+	keys := &keySet{keys: make(map[string]interface{})}
+
+	for _, k := range set.Keys {
+		if (k.Alg != "" && k.Alg != algorithm) || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		var (
+			key interface{}
+			err error
+		)
+
+		switch {
+		case k.Kty == "RSA" && algorithm == AlgorithmRS256:
+			key, err = rsaKey(k)
+		case k.Kty == "oct" && algorithm == AlgorithmHS256:
+			key, err = base64.RawURLEncoding.DecodeString(k.K)
+		default:
+			continue
+		}
+
+		if err != nil {
+			return nil, errors.WithMessagef(entities.ErrInvalidParam, "jwks key %q: %s", k.Kid, err)
+		}
+
+		keys.keys[k.Kid] = key
+	}
+
+	if len(keys.keys) == 0 {
+		return nil, errors.WithMessagef(entities.ErrInvalidParam, "jwks has no %s keys", algorithm)
+	}
+
+	return keys, nil
+}
+
+func rsaKey(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
@@ -0,0 +1,106 @@
+// Package jwt verifies end-user bearer tokens.
+package jwt
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+	"os"
+	"service/internal/entities"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+)
+
+// Options configure accepted tokens. Key comes from Secret (HS256),
+// PublicKeyFile (RS256, PEM) or JWKSFile (either).
+type Options struct {
+	Algorithm     string
+	Secret        string
+	PublicKeyFile string
+	JWKSFile      string
+	Issuer        string
+	Audience      string
+}
+
+type Verifier struct {
+	keys   *keySet
+	parser *jwt.Parser
+}
+
+func NewVerifier(opts Options) (*Verifier, error) {
+	if opts.Algorithm != AlgorithmHS256 && opts.Algorithm != AlgorithmRS256 {
+		return nil, errors.WithMessagef(entities.ErrInvalidParam, "unsupported jwt algorithm %q", opts.Algorithm)
+	}
+
+	keys, err := loadKeys(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	parserOpts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{opts.Algorithm}),
+		jwt.WithExpirationRequired(),
+	}
+
+	if opts.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(opts.Issuer))
+	}
+
+	if opts.Audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(opts.Audience))
+	}
+
+	return &Verifier{
+		keys:   keys,
+		parser: jwt.NewParser(parserOpts...),
+	}, nil
+}
+
+// Verify checks token signature and claims and returns its subject.
+func (v *Verifier) Verify(token string) (string, error) {
+	claims := &jwt.RegisteredClaims{}
+
+	_, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.keys.get(kid)
+	})
+	if err != nil {
+		return "", errors.WithMessage(entities.ErrUnauthorized, err.Error())
+	}
+
+	if claims.Subject == "" {
+		return "", errors.WithMessage(entities.ErrUnauthorized, "token has no subject")
+	}
+
+	return claims.Subject, nil
+}
+
+func loadKeys(opts Options) (*keySet, error) {
+	switch {
+	case opts.JWKSFile != "":
+		data, err := os.ReadFile(opts.JWKSFile)
+		if err != nil {
+			return nil, errors.WithMessage(entities.ErrInvalidParam, err.Error())
+		}
+
+		return parseJWKS(data, opts.Algorithm)
+	case opts.Algorithm == AlgorithmHS256 && opts.Secret != "":
+		return singleKey([]byte(opts.Secret)), nil
+	case opts.Algorithm == AlgorithmRS256 && opts.PublicKeyFile != "":
+		data, err := os.ReadFile(opts.PublicKeyFile)
+		if err != nil {
+			return nil, errors.WithMessage(entities.ErrInvalidParam, err.Error())
+		}
+
+		key, err := jwt.ParseRSAPublicKeyFromPEM(data)
+		if err != nil {
+			return nil, errors.WithMessage(entities.ErrInvalidParam, err.Error())
+		}
+
+		return singleKey(key), nil
+	}
+
+	return nil, errors.WithMessagef(entities.ErrInvalidParam, "no key configured for %s", opts.Algorithm)
+}
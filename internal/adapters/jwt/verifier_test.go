@@ -0,0 +1,124 @@
+package jwt_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	jwtadapter "service/internal/adapters/jwt"
+	"service/internal/entities"
+	"testing"
+	"time"
+)
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.Claims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+func claims(subject string, ttl time.Duration) jwt.Claims {
+	return jwt.RegisteredClaims{
+		Subject:   subject,
+		Issuer:    "app",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+	}
+}
+
+func TestVerifier_HS256(t *testing.T) {
+	v, err := jwtadapter.NewVerifier(jwtadapter.Options{
+		Algorithm: jwtadapter.AlgorithmHS256,
+		Secret:    "secret",
+		Issuer:    "app",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subject, err := v.Verify(sign(t, jwt.SigningMethodHS256, []byte("secret"), "", claims("user", time.Minute)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if subject != "user" {
+		t.Fatalf("expected user, got %s", subject)
+	}
+
+	for name, token := range map[string]string{
+		"expired":    sign(t, jwt.SigningMethodHS256, []byte("secret"), "", claims("user", -time.Minute)),
+		"wrong key":  sign(t, jwt.SigningMethodHS256, []byte("other"), "", claims("user", time.Minute)),
+		"no subject": sign(t, jwt.SigningMethodHS256, []byte("secret"), "", claims("", time.Minute)),
+		"wrong alg":  sign(t, jwt.SigningMethodHS512, []byte("secret"), "", claims("user", time.Minute)),
+		"no expiry":  sign(t, jwt.SigningMethodHS256, []byte("secret"), "", jwt.RegisteredClaims{Subject: "user"}),
+		"garbage":    "not a token",
+	} {
+		if _, err = v.Verify(token); !errors.Is(err, entities.ErrUnauthorized) {
+			t.Fatalf("%s: expected ErrUnauthorized, got %v", name, err)
+		}
+	}
+}
+
+func TestVerifier_RS256JWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks, err := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err = os.WriteFile(file, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := jwtadapter.NewVerifier(jwtadapter.Options{Algorithm: jwtadapter.AlgorithmRS256, JWKSFile: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subject, err := v.Verify(sign(t, jwt.SigningMethodRS256, key, "k1", claims("user", time.Minute)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if subject != "user" {
+		t.Fatalf("expected user, got %s", subject)
+	}
+
+	if _, err = v.Verify(sign(t, jwt.SigningMethodRS256, key, "k2", claims("user", time.Minute))); err == nil {
+		t.Fatal("expected unknown kid to be rejected")
+	}
+
+	// HS256 token signed with public key bytes must not pass as RS256
+	forged := sign(t, jwt.SigningMethodHS256, key.N.Bytes(), "k1", claims("user", time.Minute))
+	if _, err = v.Verify(forged); !errors.Is(err, entities.ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"service/internal/cases"
+	"service/internal/entities"
+	httpport "service/internal/ports/http"
+	"strconv"
+	"time"
+)
+
+const namespace = "balance"
+
+var (
+	_ cases.Metrics    = (*Prometheus)(nil)
+	_ httpport.Metrics = (*Prometheus)(nil)
+)
+
+type Prometheus struct {
+	registry *prometheus.Registry
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+	rateLimiter  *prometheus.CounterVec
+	operations   *prometheus.CounterVec
+	failures     *prometheus.CounterVec
+	volume       *prometheus.CounterVec
+}
+
+func NewPrometheus() *Prometheus {
+	p := &Prometheus{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route pattern and status.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route pattern.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		rateLimiter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limiter_errors_total",
+			Help:      "Rate limiter errors by route and whether the request was allowed or rejected.",
+		}, []string{"route", "result"}),
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "operations_total",
+			Help:      "Successful use case calls.",
+		}, []string{"operation"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "operation_failures_total",
+			Help:      "Failed use case calls by error code.",
+		}, []string{"operation", "code"}),
+		volume: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "operations_volume_total",
+			Help:      "Money moved by successful credits, reserves and commits.",
+		}, []string{"operation"}),
+	}
+
+	p.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		p.httpRequests,
+		p.httpDuration,
+		p.rateLimiter,
+		p.operations,
+		p.failures,
+		p.volume,
+	)
+
+	return p
+}
+
+func (p *Prometheus) Register(collector prometheus.Collector) error {
+	return p.registry.Register(collector)
+}
+
+// Registerer registers collectors which need labels of their own.
+func (p *Prometheus) Registerer() prometheus.Registerer {
+	return p.registry
+}
+
+func (p *Prometheus) Handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+}
+
+func (p *Prometheus) ObserveRequest(method, route string, status int, duration time.Duration) {
+	p.httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	p.httpDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+func (p *Prometheus) RateLimiterFailed(route string, allowed bool) {
+	result := "rejected"
+	if allowed {
+		result = "allowed"
+	}
+
+	p.rateLimiter.WithLabelValues(route, result).Inc()
+}
+
+func (p *Prometheus) OperationSucceeded(operation string, value entities.Currency) {
+	p.operations.WithLabelValues(operation).Inc()
+
+	if value > 0 {
+		p.volume.WithLabelValues(operation).Add(float64(value))
+	}
+}
+
+func (p *Prometheus) OperationFailed(operation string, err error) {
+	p.failures.WithLabelValues(operation, entities.ErrorCode(err)).Inc()
+}
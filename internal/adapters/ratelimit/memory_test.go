@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemory_Take(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(0, 0)
+
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if wait, _ := m.Take(ctx, "key", 2, 3); wait != 0 {
+			t.Fatalf("expected burst of 3, request %d waits %s", i, wait)
+		}
+	}
+
+	if wait, _ := m.Take(ctx, "key", 2, 3); wait != 500*time.Millisecond {
+		t.Fatalf("expected to wait 500ms, got %s", wait)
+	}
+
+	if wait, _ := m.Take(ctx, "other", 2, 3); wait != 0 {
+		t.Fatalf("expected separate bucket, waits %s", wait)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+
+	if wait, _ := m.Take(ctx, "key", 2, 3); wait != 0 {
+		t.Fatalf("expected refilled token, waits %s", wait)
+	}
+
+	now = now.Add(time.Hour)
+	m.Take(ctx, "third", 2, 3)
+
+	if _, ok := m.buckets["key"]; ok {
+		t.Fatal("expected idle bucket to be swept")
+	}
+}
+
+func TestMemory_SweepKeepsBucketsNotFull(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(0, 0)
+
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+
+	// a bucket of burst 100 at 1/s takes 100s to refill
+	for i := 0; i < 100; i++ {
+		if wait, _ := m.Take(ctx, "key", 1, 100); wait != 0 {
+			t.Fatalf("request %d waits %s", i, wait)
+		}
+	}
+
+	now = now.Add(time.Minute)
+	m.Take(ctx, "other", 1, 100)
+
+	b, ok := m.buckets["key"]
+	if !ok {
+		t.Fatal("expected bucket refilled to 60 of 100 to be kept")
+	}
+
+	if b.tokens != 0 {
+		t.Fatalf("sweep changed bucket tokens to %v", b.tokens)
+	}
+
+	now = now.Add(time.Minute)
+	m.Take(ctx, "other", 1, 100)
+
+	if _, ok = m.buckets["key"]; ok {
+		t.Fatal("expected full bucket to be swept")
+	}
+}
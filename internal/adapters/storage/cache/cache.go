@@ -0,0 +1,360 @@
+// Package cache decorates storage with a cache of user balances, which is
+// invalidated on every mutation of a user, including mutations made by other
+// replicas when the storage reports them.
+package cache
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"service/internal/cases"
+	"service/internal/consistency"
+	"service/internal/entities"
+	"service/internal/logging"
+	"sync"
+	"time"
+)
+
+var (
+	_ cases.Storage          = (*Storage)(nil)
+	_ cases.OperationArchive = (*Storage)(nil)
+)
+
+const (
+	resultHit    = "hit"
+	resultMiss   = "miss"
+	resultBypass = "bypass"
+
+	sourceLocal  = "local"
+	sourceNotify = "notify"
+	sourceLost   = "lost"
+
+	pruneInterval = 10 * time.Second
+)
+
+// Cache keeps balances by user id. Errors are logged and the storage is read
+// through, so an unavailable external cache only costs latency.
+type Cache interface {
+	Get(ctx context.Context, userID string) (*entities.Balance, bool, error)
+	Set(ctx context.Context, balance *entities.Balance) error
+	Delete(ctx context.Context, userID string) error
+	Purge(ctx context.Context) error
+}
+
+// Changes is implemented by storages which report users changed by other
+// processes. An empty user id means changes may have been missed.
+type Changes interface {
+	WatchChanges(ctx context.Context) (<-chan string, error)
+}
+
+type Options struct {
+	// ReadLag is how long reads of the storage may return values older than
+	// a mutation, e.g. replica max lag. Balances read within ReadLag after an
+	// invalidation of the user are not cached.
+	ReadLag time.Duration
+}
+
+// Storage caches GetBalance of the next storage and passes everything else
+// through. Reads carrying a consistency token or made by mutations bypass the cache.
+type Storage struct {
+	log     *zap.SugaredLogger
+	next    cases.Storage
+	cache   Cache
+	readLag time.Duration
+	cancel  context.CancelFunc
+
+	mu          sync.Mutex
+	invalidated map[string]time.Time
+	purged      time.Time
+	reads       map[uint64]time.Time
+	lastRead    uint64
+	now         func() time.Time
+
+	requests      *prometheus.CounterVec
+	invalidations *prometheus.CounterVec
+}
+
+// NewStorage watches changes of next when it implements Changes until Close.
+func NewStorage(log *zap.SugaredLogger, next cases.Storage, cache Cache, opts Options) (*Storage, error) {
+	if log == nil {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty logger")
+	}
+
+	if next == nil {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty storage")
+	}
+
+	if cache == nil {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty cache")
+	}
+
+	if opts.ReadLag < 0 {
+		return nil, errors.WithMessagef(entities.ErrInvalidParam, "negative read lag %s", opts.ReadLag)
+	}
+
+	st := &Storage{
+		log:         log,
+		next:        next,
+		cache:       cache,
+		readLag:     opts.ReadLag,
+		invalidated: make(map[string]time.Time),
+		reads:       make(map[uint64]time.Time),
+		now:         time.Now,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "balance",
+			Subsystem: "cache",
+			Name:      "requests_total",
+			Help:      "Balance reads by result: hit, miss or bypass for reads with consistency token.",
+		}, []string{"result"}),
+		invalidations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "balance",
+			Subsystem: "cache",
+			Name:      "invalidations_total",
+			Help:      "Invalidations by source: local mutation, notify of another process or lost notifications.",
+		}, []string{"source"}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	st.cancel = cancel
+
+	var changes <-chan string
+
+	if watcher, ok := next.(Changes); ok {
+		var err error
+		if changes, err = watcher.WatchChanges(ctx); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	go st.watch(ctx, changes)
+
+	return st, nil
+}
+
+// watch invalidates users changed by other processes and prunes
+// invalidations no read can be affected by. A nil changes only prunes.
+func (s *Storage) watch(ctx context.Context, changes <-chan string) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.prune()
+		case userID, ok := <-changes:
+			if !ok {
+				return
+			}
+
+			if userID == "" {
+				s.purge(ctx)
+				continue
+			}
+
+			s.invalidate(ctx, userID, sourceNotify)
+		}
+	}
+}
+
+func (s *Storage) GetBalance(ctx context.Context, userID string) (*entities.Balance, error) {
+	if consistency.Token(ctx) != "" || consistency.Primary(ctx) {
+		s.requests.WithLabelValues(resultBypass).Inc()
+		return s.next.GetBalance(ctx, userID)
+	}
+
+	balance, ok, err := s.cache.Get(ctx, userID)
+	if err != nil {
+		s.logger(ctx).Error(err)
+	}
+	if ok {
+		s.requests.WithLabelValues(resultHit).Inc()
+		return balance, nil
+	}
+
+	s.requests.WithLabelValues(resultMiss).Inc()
+
+	read, started := s.startRead()
+	defer s.endRead(read)
+
+	balance, err = s.next.GetBalance(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.fresh(userID, started) {
+		return balance, nil
+	}
+
+	if err = s.cache.Set(ctx, balance); err != nil {
+		s.logger(ctx).Error(err)
+		return balance, nil
+	}
+
+	// an invalidation between the check and Set may have missed the balance
+	if !s.fresh(userID, started) {
+		if err = s.cache.Delete(ctx, userID); err != nil {
+			s.logger(ctx).Error(err)
+		}
+	}
+
+	return balance, nil
+}
+
+func (s *Storage) CreateOrUpdateBalance(ctx context.Context, operation *entities.Operation) error {
+	defer s.invalidate(ctx, operation.UserID(), sourceLocal)
+
+	return s.next.CreateOrUpdateBalance(ctx, operation)
+}
+
+func (s *Storage) CreateOperation(ctx context.Context, operation *entities.Operation) error {
+	defer s.invalidate(ctx, operation.UserID(), sourceLocal)
+
+	return s.next.CreateOperation(ctx, operation)
+}
+
+func (s *Storage) UpdateOperationReserve(ctx context.Context, operation *entities.Operation) error {
+	defer s.invalidate(ctx, operation.UserID(), sourceLocal)
+
+	return s.next.UpdateOperationReserve(ctx, operation)
+}
+
+func (s *Storage) GetOperation(
+	ctx context.Context,
+	userID string,
+	orderID string,
+	serviceID string,
+) (*entities.Operation, error) {
+	return s.next.GetOperation(ctx, userID, orderID, serviceID)
+}
+
+func (s *Storage) ListOperations(
+	ctx context.Context,
+	userID string,
+	limit, offset int,
+	sortBy string, desc bool,
+) ([]*entities.Operation, error) {
+	return s.next.ListOperations(ctx, userID, limit, offset, sortBy, desc)
+}
+
+func (s *Storage) ListOperationsInRange(
+	ctx context.Context,
+	userID string,
+	from, to time.Time,
+	limit, offset int,
+	sortBy string, desc bool,
+) ([]*entities.Operation, error) {
+	archive, ok := s.next.(cases.OperationArchive)
+	if !ok {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "storage does not support operations range")
+	}
+
+	return archive.ListOperationsInRange(ctx, userID, from, to, limit, offset, sortBy, desc)
+}
+
+// Close stops watching changes, the next storage is closed by its owner.
+func (s *Storage) Close() {
+	s.cancel()
+}
+
+type collector struct {
+	storage *Storage
+}
+
+// Collector exports cache hits, misses and invalidations.
+func (s *Storage) Collector() prometheus.Collector {
+	return &collector{storage: s}
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	c.storage.requests.Describe(ch)
+	c.storage.invalidations.Describe(ch)
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	c.storage.requests.Collect(ch)
+	c.storage.invalidations.Collect(ch)
+}
+
+// invalidate is recorded before the delete, so a read racing with it is not cached.
+func (s *Storage) invalidate(ctx context.Context, userID string, source string) {
+	s.mu.Lock()
+	s.invalidated[userID] = s.now()
+	s.mu.Unlock()
+
+	s.invalidations.WithLabelValues(source).Inc()
+
+	if err := s.cache.Delete(ctx, userID); err != nil {
+		s.logger(ctx).Error(err)
+	}
+}
+
+func (s *Storage) purge(ctx context.Context) {
+	s.mu.Lock()
+	s.purged = s.now()
+	s.invalidated = make(map[string]time.Time)
+	s.mu.Unlock()
+
+	s.invalidations.WithLabelValues(sourceLost).Inc()
+
+	if err := s.cache.Purge(ctx); err != nil {
+		s.logger(ctx).Error(err)
+	}
+}
+
+// fresh reports whether a balance read started at started may be cached: it
+// started more than read lag after the last invalidation of the user.
+func (s *Storage) fresh(userID string, started time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	since := started.Add(-s.readLag)
+
+	return s.purged.Before(since) && s.invalidated[userID].Before(since)
+}
+
+func (s *Storage) startRead() (uint64, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastRead++
+	started := s.now()
+	s.reads[s.lastRead] = started
+
+	return s.lastRead, started
+}
+
+func (s *Storage) endRead(read uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.reads, read)
+}
+
+// prune drops invalidations older than read lag before the oldest read in flight.
+func (s *Storage) prune() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldest := s.now()
+	for _, started := range s.reads {
+		if started.Before(oldest) {
+			oldest = started
+		}
+	}
+
+	cutoff := oldest.Add(-s.readLag)
+
+	for userID, at := range s.invalidated {
+		if at.Before(cutoff) {
+			delete(s.invalidated, userID)
+		}
+	}
+}
+
+func (s *Storage) logger(ctx context.Context) *zap.SugaredLogger {
+	return logging.FromContext(ctx, s.log)
+}
@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"service/internal/adapters/storage/memory"
+	"service/internal/adapters/storage/storagetest"
+	"service/internal/cases"
+	"service/internal/consistency"
+	"service/internal/entities"
+	"testing"
+	"time"
+)
+
+// watchedStorage reports changes the test sends, as other replicas would.
+type watchedStorage struct {
+	*memory.Storage
+	changes chan string
+}
+
+func (s *watchedStorage) WatchChanges(context.Context) (<-chan string, error) {
+	return s.changes, nil
+}
+
+func newStorage(t *testing.T) (*Storage, *watchedStorage) {
+	t.Helper()
+
+	next := &watchedStorage{Storage: memory.NewStorage(), changes: make(chan string)}
+
+	lru, err := NewLRU(100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := NewStorage(zap.NewNop().Sugar(), next, lru, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(st.Close)
+
+	return st, next
+}
+
+func credit(t *testing.T, st cases.Storage, userID, orderID string, value entities.Currency) {
+	t.Helper()
+
+	err := st.CreateOrUpdateBalance(context.Background(),
+		entities.NewOperation(userID, "cache", orderID, entities.Credit, value, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func balance(t *testing.T, ctx context.Context, st cases.Storage, userID string) entities.Currency {
+	t.Helper()
+
+	b, err := st.GetBalance(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b.Value()
+}
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) cases.Storage {
+		st, _ := newStorage(t)
+		return st
+	})
+}
+
+func TestStorage_Invalidation(t *testing.T) {
+	ctx := context.Background()
+	st, next := newStorage(t)
+
+	credit(t, st, "user", "1", 100)
+
+	if got := balance(t, ctx, st, "user"); got != 100 {
+		t.Fatalf("balance = %d, want 100", got)
+	}
+
+	_ = balance(t, ctx, st, "user")
+
+	hits, misses := testutil.ToFloat64(st.requests.WithLabelValues(resultHit)),
+		testutil.ToFloat64(st.requests.WithLabelValues(resultMiss))
+	if hits != 1 || misses != 1 {
+		t.Errorf("hits = %v, misses = %v, want 1 and 1", hits, misses)
+	}
+
+	credit(t, st, "user", "2", 50)
+
+	if got := balance(t, ctx, st, "user"); got != 150 {
+		t.Errorf("balance after local credit = %d, want 150", got)
+	}
+
+	// a credit of another replica is seen only after its notification
+	credit(t, next.Storage, "user", "3", 50)
+
+	if got := balance(t, ctx, st, "user"); got != 150 {
+		t.Errorf("cached balance = %d, want 150", got)
+	}
+
+	if got := balance(t, consistency.WithToken(ctx, "0/1"), st, "user"); got != 200 {
+		t.Errorf("balance with consistency token = %d, want 200", got)
+	}
+
+	if got := balance(t, consistency.WithPrimary(ctx), st, "user"); got != 200 {
+		t.Errorf("balance read by mutation = %d, want 200", got)
+	}
+
+	next.changes <- "user"
+	// the second send returns after the first one is handled
+	next.changes <- "other"
+
+	if got := balance(t, ctx, st, "user"); got != 200 {
+		t.Errorf("balance after notification = %d, want 200", got)
+	}
+
+	credit(t, next.Storage, "user", "4", 50)
+	next.changes <- ""
+	next.changes <- "other"
+
+	if got := balance(t, ctx, st, "user"); got != 250 {
+		t.Errorf("balance after lost notifications = %d, want 250", got)
+	}
+}
+
+func TestStorage_ReadLag(t *testing.T) {
+	ctx := context.Background()
+	st, _ := newStorage(t)
+
+	now := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+	st.now = func() time.Time { return now }
+	st.readLag = 5 * time.Second
+
+	credit(t, st, "user", "1", 100)
+
+	// reads within read lag after the credit may be stale and are not cached
+	_ = balance(t, ctx, st, "user")
+	_ = balance(t, ctx, st, "user")
+
+	now = now.Add(6 * time.Second)
+
+	_ = balance(t, ctx, st, "user")
+	_ = balance(t, ctx, st, "user")
+
+	hits, misses := testutil.ToFloat64(st.requests.WithLabelValues(resultHit)),
+		testutil.ToFloat64(st.requests.WithLabelValues(resultMiss))
+	if hits != 1 || misses != 3 {
+		t.Errorf("hits = %v, misses = %v, want 1 and 3", hits, misses)
+	}
+
+	st.prune()
+
+	if len(st.invalidated) != 0 {
+		t.Errorf("invalidations not pruned: %v", st.invalidated)
+	}
+}
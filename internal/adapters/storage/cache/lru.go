@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"github.com/pkg/errors"
+	"service/internal/entities"
+	"sync"
+	"time"
+)
+
+var _ Cache = (*LRU)(nil)
+
+type lruEntry struct {
+	balance *entities.Balance
+	expires time.Time
+}
+
+// LRU is an in-process cache of at most size balances, each kept for ttl
+// at most, zero ttl keeps balances until evicted or invalidated.
+type LRU struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+	now     func() time.Time
+}
+
+func NewLRU(size int, ttl time.Duration) (*LRU, error) {
+	if size <= 0 {
+		return nil, errors.WithMessagef(entities.ErrInvalidParam, "cache size must be positive, got %d", size)
+	}
+
+	if ttl < 0 {
+		return nil, errors.WithMessagef(entities.ErrInvalidParam, "negative cache ttl %s", ttl)
+	}
+
+	return &LRU{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+		now:     time.Now,
+	}, nil
+}
+
+func (c *LRU) Get(_ context.Context, userID string) (*entities.Balance, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[userID]
+	if !ok {
+		return nil, false, nil
+	}
+
+	entry := el.Value.(*lruEntry)
+	if c.ttl > 0 && !c.now().Before(entry.expires) {
+		c.remove(el)
+		return nil, false, nil
+	}
+
+	c.order.MoveToFront(el)
+
+	return entry.balance, true, nil
+}
+
+func (c *LRU) Set(_ context.Context, balance *entities.Balance) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &lruEntry{balance: balance, expires: c.now().Add(c.ttl)}
+
+	if el, ok := c.entries[balance.UserID()]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+
+		return nil
+	}
+
+	c.entries[balance.UserID()] = c.order.PushFront(entry)
+
+	if c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+
+	return nil
+}
+
+func (c *LRU) Delete(_ context.Context, userID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[userID]; ok {
+		c.remove(el)
+	}
+
+	return nil
+}
+
+func (c *LRU) Purge(_ context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.entries = make(map[string]*list.Element, c.size)
+
+	return nil
+}
+
+// Len is the number of cached balances, expired ones included.
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+func (c *LRU) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*lruEntry).balance.UserID())
+}
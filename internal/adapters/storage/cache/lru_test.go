@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"context"
+	"service/internal/entities"
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+
+	lru, err := NewLRU(2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+	lru.now = func() time.Time { return now }
+
+	for _, userID := range []string{"a", "b"} {
+		_ = lru.Set(ctx, entities.NewBalance(userID, 100))
+	}
+
+	// a becomes recently used, so c evicts b
+	if _, ok, _ := lru.Get(ctx, "a"); !ok {
+		t.Fatal("a is not cached")
+	}
+
+	_ = lru.Set(ctx, entities.NewBalance("c", 100))
+
+	if _, ok, _ := lru.Get(ctx, "b"); ok {
+		t.Error("b is not evicted")
+	}
+
+	if lru.Len() != 2 {
+		t.Errorf("len = %d, want 2", lru.Len())
+	}
+
+	_ = lru.Delete(ctx, "c")
+	if _, ok, _ := lru.Get(ctx, "c"); ok {
+		t.Error("c is not deleted")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok, _ := lru.Get(ctx, "a"); ok {
+		t.Error("a is not expired")
+	}
+
+	if _, err = NewLRU(0, 0); err == nil {
+		t.Error("zero size accepted")
+	}
+}
@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"service/internal/entities"
+	"sort"
+)
+
+type apiKey struct {
+	key  *entities.APIKey
+	hash string
+}
+
+func (s *Storage) CreateAPIKey(_ context.Context, key *entities.APIKey, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, stored := range s.keys {
+		if stored.hash == hash {
+			return errors.WithMessage(entities.ErrInternal, "api key hash already exists")
+		}
+	}
+
+	s.keys[key.ID()] = &apiKey{key: key, hash: hash}
+
+	return nil
+}
+
+func (s *Storage) GetAPIKeyByHash(_ context.Context, hash string) (*entities.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, stored := range s.keys {
+		if stored.hash == hash {
+			return stored.key, nil
+		}
+	}
+
+	return nil, errors.WithMessage(entities.ErrNotFound, "api key not found")
+}
+
+func (s *Storage) ListAPIKeys(context.Context) ([]*entities.APIKey, error) {
+	s.mu.RLock()
+	keys := make([]*entities.APIKey, 0, len(s.keys))
+	for _, stored := range s.keys {
+		keys = append(keys, stored.key)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ServiceID() != keys[j].ServiceID() {
+			return keys[i].ServiceID() < keys[j].ServiceID()
+		}
+
+		return keys[i].CreatedAt().Before(keys[j].CreatedAt())
+	})
+
+	return keys, nil
+}
+
+func (s *Storage) DeleteAPIKey(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[id]; !ok {
+		return errors.WithMessage(entities.ErrNotFound, "api key not found")
+	}
+
+	delete(s.keys, id)
+
+	return nil
+}
@@ -0,0 +1,232 @@
+// Package memory is an in-process storage with semantics of postgres storage,
+// for tests and demos. Data is lost on restart.
+package memory
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"service/internal/adapters/storage/subscribers"
+	"service/internal/cases"
+	"service/internal/entities"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	_ cases.Storage    = (*Storage)(nil)
+	_ cases.Notifier   = (*Storage)(nil)
+	_ cases.KeyStorage = (*Storage)(nil)
+)
+
+type operationKey struct {
+	userID    string
+	serviceID string
+	orderID   string
+}
+
+type operation struct {
+	*entities.Operation
+	reserve entities.Currency
+	// seq keeps insertion order among operations with equal sort field
+	seq int
+}
+
+type Storage struct {
+	mu          sync.RWMutex
+	balances    map[string]entities.Currency
+	operations  map[operationKey]*operation
+	userOps     map[string][]*operation
+	seq         int
+	keys        map[string]*apiKey
+	subscribers *subscribers.Subscribers
+	now         func() time.Time
+}
+
+func NewStorage() *Storage {
+	return &Storage{
+		balances:    make(map[string]entities.Currency),
+		operations:  make(map[operationKey]*operation),
+		userOps:     make(map[string][]*operation),
+		keys:        make(map[string]*apiKey),
+		subscribers: subscribers.New(),
+		now:         time.Now,
+	}
+}
+
+func (s *Storage) CreateOrUpdateBalance(_ context.Context, op *entities.Operation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	balance := s.balances[op.UserID()] + op.Value()
+	if balance < 0 {
+		return errors.WithMessage(entities.ErrInternal, "balance must not be negative")
+	}
+
+	if err := s.createOperation(op, 0); err != nil {
+		return err
+	}
+
+	s.balances[op.UserID()] = balance
+	s.subscribers.Publish(s.stamp(op))
+
+	return nil
+}
+
+func (s *Storage) GetBalance(_ context.Context, userID string) (*entities.Balance, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.balances[userID]
+	if !ok {
+		return nil, errors.WithMessage(entities.ErrNotFound, "balance not found")
+	}
+
+	return entities.NewBalance(userID, value), nil
+}
+
+func (s *Storage) CreateOperation(_ context.Context, op *entities.Operation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.balances[op.UserID()]
+	if !ok {
+		return errors.WithMessage(entities.ErrNotFound, "balance not found")
+	}
+
+	if value-op.Value() < 0 {
+		return entities.ErrReserveInvalidValue
+	}
+
+	if err := s.createOperation(op, op.Value()); err != nil {
+		return err
+	}
+
+	s.balances[op.UserID()] = value - op.Value()
+	s.subscribers.Publish(s.stamp(op))
+
+	return nil
+}
+
+func (s *Storage) GetOperation(_ context.Context, userID, orderID, serviceID string) (*entities.Operation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	op, ok := s.operations[operationKey{userID: userID, serviceID: serviceID, orderID: orderID}]
+	if !ok {
+		return nil, errors.WithMessage(entities.ErrNotFound, "operation not found")
+	}
+
+	return op.Operation, nil
+}
+
+func (s *Storage) UpdateOperationReserve(_ context.Context, op *entities.Operation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.operations[operationKey{userID: op.UserID(), serviceID: op.ServiceID(), orderID: op.OrderID()}]
+	if !ok {
+		return errors.WithMessage(entities.ErrNotFound, "operation not found")
+	}
+
+	if stored.reserve-op.Value() < 0 || op.Value() < 0 {
+		return entities.ErrCommitInvalidValue
+	}
+
+	stored.reserve -= op.Value()
+	s.subscribers.Publish(s.stamp(op))
+
+	return nil
+}
+
+func (s *Storage) ListOperations(
+	_ context.Context,
+	userID string,
+	limit, offset int,
+	sortBy string, desc bool,
+) ([]*entities.Operation, error) {
+	s.mu.RLock()
+	ops := make([]*operation, len(s.userOps[userID]))
+	copy(ops, s.userOps[userID])
+	s.mu.RUnlock()
+
+	less := func(a, b *operation) bool {
+		if sortBy == entities.Value && a.Value() != b.Value() {
+			return a.Value() < b.Value()
+		}
+
+		if sortBy != entities.Value && !a.CreatedAt().Equal(b.CreatedAt()) {
+			return a.CreatedAt().Before(b.CreatedAt())
+		}
+
+		return a.seq < b.seq
+	}
+
+	sort.Slice(ops, func(i, j int) bool {
+		if desc {
+			return less(ops[j], ops[i])
+		}
+
+		return less(ops[i], ops[j])
+	})
+
+	if offset > len(ops) {
+		offset = len(ops)
+	}
+	ops = ops[offset:]
+
+	if limit != 0 && limit < len(ops) {
+		ops = ops[:limit]
+	}
+
+	operations := make([]*entities.Operation, 0, len(ops))
+	for _, op := range ops {
+		operations = append(operations, op.Operation)
+	}
+
+	return operations, nil
+}
+
+// Ready always succeeds, storage is available while process runs.
+func (s *Storage) Ready(context.Context) error {
+	return nil
+}
+
+func (s *Storage) Close() {}
+
+// createOperation stores operation with creation time set, caller holds the lock.
+func (s *Storage) createOperation(op *entities.Operation, reserve entities.Currency) error {
+	if op.Value() < 0 {
+		return entities.ErrReserveInvalidValue
+	}
+
+	key := operationKey{userID: op.UserID(), serviceID: op.ServiceID(), orderID: op.OrderID()}
+	if _, ok := s.operations[key]; ok {
+		return entities.ErrReserveAlreadyExists
+	}
+
+	s.seq++
+
+	stored := &operation{
+		Operation: s.stamp(op),
+		reserve:   reserve,
+		seq:       s.seq,
+	}
+
+	s.operations[key] = stored
+	s.userOps[op.UserID()] = append(s.userOps[op.UserID()], stored)
+
+	return nil
+}
+
+// stamp copies operation with current time, as postgres storage sets created_at.
+func (s *Storage) stamp(op *entities.Operation) *entities.Operation {
+	return entities.NewOperation(
+		op.UserID(),
+		op.ServiceID(),
+		op.OrderID(),
+		op.OperationType(),
+		op.Value(),
+		s.now().UTC(),
+	)
+}
@@ -0,0 +1,13 @@
+package memory
+
+import (
+	"service/internal/adapters/storage/storagetest"
+	"service/internal/cases"
+	"testing"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) cases.Storage {
+		return NewStorage()
+	})
+}
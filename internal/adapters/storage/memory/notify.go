@@ -0,0 +1,10 @@
+package memory
+
+import (
+	"context"
+	"service/internal/entities"
+)
+
+func (s *Storage) Subscribe(ctx context.Context, userID string) (<-chan *entities.Operation, error) {
+	return s.subscribers.Subscribe(ctx, userID)
+}
@@ -0,0 +1,128 @@
+package postgres
+
+import (
+	"context"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pkg/errors"
+	"service/internal/cases"
+	"service/internal/entities"
+	"time"
+)
+
+var _ cases.KeyStorage = (*Storage)(nil)
+
+func (s *Storage) CreateAPIKey(ctx context.Context, key *entities.APIKey, hash string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO avito.api_keys (id, service_id, key_hash, permissions, created_at)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	permissions := make([]string, 0, len(key.Permissions()))
+	for _, p := range key.Permissions() {
+		permissions = append(permissions, string(p))
+	}
+
+	_, err := s.db.Exec(ctx, query, key.ID(), key.ServiceID(), hash, permissions, key.CreatedAt())
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return errors.WithMessage(entities.ErrInternal, err.Error())
+	}
+
+	return nil
+}
+
+func (s *Storage) GetAPIKeyByHash(ctx context.Context, hash string) (*entities.APIKey, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT id, service_id, permissions, created_at
+		FROM avito.api_keys
+		WHERE key_hash = $1`
+
+	key, err := scanAPIKey(s.db.QueryRow(ctx, query, hash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errors.WithMessage(entities.ErrNotFound, "api key not found")
+	}
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return nil, errors.WithMessage(entities.ErrInternal, err.Error())
+	}
+
+	return key, nil
+}
+
+func (s *Storage) ListAPIKeys(ctx context.Context) ([]*entities.APIKey, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT id, service_id, permissions, created_at
+		FROM avito.api_keys
+		ORDER BY service_id, created_at`
+
+	rows, err := s.db.Query(ctx, query)
+	if err != nil {
+		err = errors.WithMessage(entities.ErrInternal, err.Error())
+		s.logger(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]*entities.APIKey, 0)
+
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			err = errors.WithMessage(entities.ErrInternal, err.Error())
+			s.logger(ctx).Error(err)
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (s *Storage) DeleteAPIKey(ctx context.Context, id string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.Exec(ctx, `DELETE FROM avito.api_keys WHERE id = $1`, id)
+	var pge *pgconn.PgError
+	if errors.As(err, &pge) && pge.Code == pgerrcode.InvalidTextRepresentation {
+		return errors.WithMessage(entities.ErrNotFound, "api key not found")
+	}
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return errors.WithMessage(entities.ErrInternal, err.Error())
+	}
+
+	if res.RowsAffected() == 0 {
+		return errors.WithMessage(entities.ErrNotFound, "api key not found")
+	}
+
+	return nil
+}
+
+func scanAPIKey(row pgx.Row) (*entities.APIKey, error) {
+	var (
+		id          string
+		serviceID   string
+		permissions []string
+		createdAt   time.Time
+	)
+
+	if err := row.Scan(&id, &serviceID, &permissions, &createdAt); err != nil {
+		return nil, err
+	}
+
+	perms := make([]entities.Permission, 0, len(permissions))
+	for _, p := range permissions {
+		perms = append(perms, entities.Permission(p))
+	}
+
+	return entities.NewAPIKey(id, serviceID, perms, createdAt), nil
+}
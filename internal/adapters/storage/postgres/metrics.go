@@ -0,0 +1,77 @@
+package postgres
+
+import "github.com/prometheus/client_golang/prometheus"
+
+type poolCollector struct {
+	storage *Storage
+
+	acquiredConns        *prometheus.Desc
+	idleConns            *prometheus.Desc
+	totalConns           *prometheus.Desc
+	maxConns             *prometheus.Desc
+	acquireCount         *prometheus.Desc
+	acquireDuration      *prometheus.Desc
+	emptyAcquireCount    *prometheus.Desc
+	canceledAcquireCount *prometheus.Desc
+}
+
+// Collector exports pgxpool stats, transaction conflicts and read routing.
+func (s *Storage) Collector() prometheus.Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("balance", "pgxpool", name), help, nil, nil)
+	}
+
+	return &poolCollector{
+		storage:              s,
+		acquiredConns:        desc("acquired_conns", "Currently acquired connections."),
+		idleConns:            desc("idle_conns", "Currently idle connections."),
+		totalConns:           desc("total_conns", "Total connections in pool."),
+		maxConns:             desc("max_conns", "Max pool size."),
+		acquireCount:         desc("acquire_total", "Successful acquires from pool."),
+		acquireDuration:      desc("acquire_duration_seconds_total", "Total time spent waiting for connection."),
+		emptyAcquireCount:    desc("empty_acquire_total", "Acquires that had to wait for a connection."),
+		canceledAcquireCount: desc("canceled_acquire_total", "Acquires canceled by context."),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquiredConns
+	ch <- c.idleConns
+	ch <- c.totalConns
+	ch <- c.maxConns
+	ch <- c.acquireCount
+	ch <- c.acquireDuration
+	ch <- c.emptyAcquireCount
+	ch <- c.canceledAcquireCount
+
+	c.storage.txConflicts.Describe(ch)
+	c.storage.reads.Describe(ch)
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.storage.db.Stat()
+
+	ch <- prometheus.MustNewConstMetric(c.acquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.maxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquireCount, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(
+		c.acquireDuration,
+		prometheus.CounterValue,
+		stat.AcquireDuration().Seconds(),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.emptyAcquireCount,
+		prometheus.CounterValue,
+		float64(stat.EmptyAcquireCount()),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.canceledAcquireCount,
+		prometheus.CounterValue,
+		float64(stat.CanceledAcquireCount()),
+	)
+
+	c.storage.txConflicts.Collect(ch)
+	c.storage.reads.Collect(ch)
+}
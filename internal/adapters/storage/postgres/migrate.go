@@ -0,0 +1,303 @@
+package postgres
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io/fs"
+	"path"
+	"service/internal/entities"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationLockID is the key of advisory lock held while migrations run, so
+// replicas starting together do not apply the same migration twice.
+const migrationLockID = 7_311_042_018
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is a schema version, files are migrations/<version>_<name>.(up|down).sql.
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+// MigrationStatus is a known migration and when it was applied, AppliedAt is nil for pending ones.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrator applies embedded migrations, recording them in avito.schema_migrations.
+type Migrator struct {
+	log        *zap.SugaredLogger
+	conn       *pgx.Conn
+	migrations []Migration
+}
+
+func NewMigrator(ctx context.Context, log *zap.SugaredLogger, dsn string) (*Migrator, error) {
+	if log == nil {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty logger")
+	}
+
+	if dsn == "" {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty dsn")
+	}
+
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		log:        log,
+		conn:       conn,
+		migrations: migrations,
+	}, nil
+}
+
+// Up applies all pending migrations and returns their versions.
+func (m *Migrator) Up(ctx context.Context) ([]int, error) {
+	applied := make([]int, 0)
+
+	err := m.locked(ctx, func(done map[int]time.Time) error {
+		for _, migration := range m.migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+
+			if err := m.apply(ctx, migration, migration.up, true); err != nil {
+				return err
+			}
+
+			applied = append(applied, migration.Version)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down reverts up to steps latest applied migrations and returns their versions.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]int, error) {
+	if steps < 1 {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "steps must be positive")
+	}
+
+	reverted := make([]int, 0)
+
+	err := m.locked(ctx, func(done map[int]time.Time) error {
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := m.migrations[i]
+
+			if _, ok := done[migration.Version]; !ok {
+				continue
+			}
+
+			if err := m.apply(ctx, migration, migration.down, false); err != nil {
+				return err
+			}
+
+			reverted = append(reverted, migration.Version)
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+// Status lists known migrations in version order.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+
+	err := m.locked(ctx, func(done map[int]time.Time) error {
+		for _, migration := range m.migrations {
+			status := MigrationStatus{Migration: migration}
+
+			if appliedAt, ok := done[migration.Version]; ok {
+				status.AppliedAt = &appliedAt
+			}
+
+			statuses = append(statuses, status)
+		}
+
+		return nil
+	})
+
+	return statuses, err
+}
+
+func (m *Migrator) Close(ctx context.Context) error {
+	return m.conn.Close(ctx)
+}
+
+// nolint:errcheck // unlock error is irrelevant as lock is released with session
+func (m *Migrator) locked(ctx context.Context, fn func(done map[int]time.Time) error) error {
+	if _, err := m.conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, int64(migrationLockID)); err != nil {
+		return errors.WithMessage(err, "acquire migration lock")
+	}
+	defer m.conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, int64(migrationLockID))
+
+	_, err := m.conn.Exec(ctx, `CREATE SCHEMA IF NOT EXISTS avito;
+		CREATE TABLE IF NOT EXISTS avito.schema_migrations
+		(
+			version    BIGINT PRIMARY KEY,
+			name       VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+		)`)
+	if err != nil {
+		return errors.WithMessage(err, "create schema_migrations")
+	}
+
+	rows, err := m.conn.Query(ctx, `SELECT version, applied_at FROM avito.schema_migrations`)
+	if err != nil {
+		return err
+	}
+
+	done := make(map[int]time.Time)
+
+	for rows.Next() {
+		var (
+			version   int
+			appliedAt time.Time
+		)
+
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			rows.Close()
+			return err
+		}
+
+		done[version] = appliedAt
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	return fn(done)
+}
+
+// nolint:errcheck // safety in library
+func (m *Migrator) apply(ctx context.Context, migration Migration, sql string, up bool) error {
+	direction := "down"
+	if up {
+		direction = "up"
+	}
+
+	tx, err := m.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = tx.Exec(ctx, sql); err != nil {
+		return errors.WithMessagef(err, "migration %d_%s %s", migration.Version, migration.Name, direction)
+	}
+
+	if up {
+		_, err = tx.Exec(ctx, `INSERT INTO avito.schema_migrations (version, name) VALUES ($1, $2)`,
+			migration.Version, migration.Name)
+	} else {
+		_, err = tx.Exec(ctx, `DELETE FROM avito.schema_migrations WHERE version = $1`, migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	m.log.Infof("migration %d_%s %s", migration.Version, migration.Name, direction)
+
+	return nil
+}
+
+func loadMigrations(files fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(files, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+
+	for _, name := range names {
+		base := path.Base(name)
+
+		var up bool
+
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			up = true
+			base = strings.TrimSuffix(base, ".up.sql")
+		case strings.HasSuffix(base, ".down.sql"):
+			base = strings.TrimSuffix(base, ".down.sql")
+		default:
+			return nil, fmt.Errorf("migration %s: expected .up.sql or .down.sql", name)
+		}
+
+		prefix, title, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: expected <version>_<name>", name)
+		}
+
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s: invalid version %q", name, prefix)
+		}
+
+		data, err := fs.ReadFile(files, name)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: title}
+			byVersion[version] = migration
+		}
+
+		if migration.Name != title {
+			return nil, fmt.Errorf("migration %d has names %q and %q", version, migration.Name, title)
+		}
+
+		if up {
+			migration.up = string(data)
+		} else {
+			migration.down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+
+	for _, migration := range byVersion {
+		if migration.up == "" || migration.down == "" {
+			return nil, fmt.Errorf("migration %d_%s: both up and down files are required", migration.Version, migration.Name)
+		}
+
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
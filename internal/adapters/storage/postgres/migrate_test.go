@@ -0,0 +1,45 @@
+package postgres
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations_Embedded(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Errorf("migration %d_%s: versions must be consecutive from 1", migration.Version, migration.Name)
+		}
+	}
+}
+
+func TestLoadMigrations_Invalid(t *testing.T) {
+	file := &fstest.MapFile{Data: []byte("SELECT 1;")}
+
+	for name, files := range map[string]fstest.MapFS{
+		"missing down": {
+			"migrations/0001_init.up.sql": file,
+		},
+		"invalid version": {
+			"migrations/init.up.sql":   file,
+			"migrations/init.down.sql": file,
+		},
+		"different names": {
+			"migrations/0001_init.up.sql":     file,
+			"migrations/0001_schema.down.sql": file,
+		},
+		"unknown direction": {
+			"migrations/0001_init.sql": file,
+		},
+	} {
+		if _, err := loadMigrations(files); err == nil || !strings.Contains(err.Error(), "migration") {
+			t.Errorf("%s: expected error, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS avito.operations;
+DROP TABLE IF EXISTS avito.balances;
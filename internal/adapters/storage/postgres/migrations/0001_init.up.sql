@@ -1,8 +1,6 @@
-BEGIN;
+CREATE SCHEMA IF NOT EXISTS avito;
 
-CREATE SCHEMA avito;
-
-CREATE TABLE avito.balances
+CREATE TABLE IF NOT EXISTS avito.balances
 (
     user_id    VARCHAR(255) PRIMARY KEY,
     value      integer   NOT NULL DEFAULT 0,
@@ -11,7 +9,7 @@ CREATE TABLE avito.balances
     CONSTRAINT avito_balances_value_positive CHECK (balances.value >= 0)
 );
 
-CREATE TABLE avito.operations
+CREATE TABLE IF NOT EXISTS avito.operations
 (
     user_id        VARCHAR(255) NOT NULL,
     service_id     VARCHAR(255),
@@ -25,5 +23,3 @@ CREATE TABLE avito.operations
     CONSTRAINT avito_operations_reserve_positive CHECK (operations.reserve >= 0),
     PRIMARY KEY (user_id, service_id, order_id)
 );
-
-COMMIT;
\ No newline at end of file
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS avito.api_keys
+(
+    id          UUID PRIMARY KEY,
+    service_id  VARCHAR(255) NOT NULL,
+    key_hash    CHAR(64)     NOT NULL UNIQUE,
+    permissions TEXT[]       NOT NULL,
+    created_at  TIMESTAMP    NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS avito.rate_limits
+(
+    key        VARCHAR(512) PRIMARY KEY,
+    tokens     DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ      NOT NULL
+);
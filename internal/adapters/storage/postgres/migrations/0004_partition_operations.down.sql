@@ -0,0 +1,30 @@
+-- archived operations are moved back to the unpartitioned table
+CREATE TABLE avito.operations_unpartitioned
+(
+    user_id        VARCHAR(255) NOT NULL,
+    service_id     VARCHAR(255),
+    order_id       VARCHAR(255) NOT NULL,
+    operation_type integer      NOT NULL,
+    value          integer      NOT NULL,
+    reserve        integer      NOT NULL DEFAULT 0,
+    created_at     TIMESTAMP    NOT NULL DEFAULT NOW(),
+    updated_at     TIMESTAMP    NOT NULL DEFAULT NOW(),
+    CONSTRAINT avito_operations_value_positive CHECK (value >= 0),
+    CONSTRAINT avito_operations_reserve_positive CHECK (reserve >= 0),
+    CONSTRAINT operations_unpartitioned_pkey PRIMARY KEY (user_id, service_id, order_id)
+);
+
+INSERT INTO avito.operations_unpartitioned
+SELECT user_id, service_id, order_id, operation_type, value, reserve, created_at, updated_at
+FROM avito.operations
+UNION ALL
+SELECT user_id, service_id, order_id, operation_type, value, reserve, created_at, updated_at
+FROM avito_archive.operations;
+
+DROP FUNCTION avito.create_operations_partition(TIMESTAMP);
+DROP TABLE avito.operations;
+DROP TABLE avito.operation_keys;
+DROP SCHEMA avito_archive CASCADE;
+
+ALTER TABLE avito.operations_unpartitioned RENAME TO operations;
+ALTER INDEX avito.operations_unpartitioned_pkey RENAME TO operations_pkey;
@@ -0,0 +1,70 @@
+-- operations are partitioned by month of created_at, partitions of closed
+-- months are moved to avito_archive by the storage maintenance job
+ALTER TABLE avito.operations RENAME TO operations_unpartitioned;
+ALTER INDEX avito.operations_pkey RENAME TO operations_unpartitioned_pkey;
+
+CREATE TABLE avito.operations
+(
+    user_id        VARCHAR(255) NOT NULL,
+    service_id     VARCHAR(255) NOT NULL,
+    order_id       VARCHAR(255) NOT NULL,
+    operation_type integer      NOT NULL,
+    value          integer      NOT NULL,
+    reserve        integer      NOT NULL DEFAULT 0,
+    created_at     TIMESTAMP    NOT NULL DEFAULT NOW(),
+    updated_at     TIMESTAMP    NOT NULL DEFAULT NOW(),
+    CONSTRAINT avito_operations_value_positive CHECK (value >= 0),
+    CONSTRAINT avito_operations_reserve_positive CHECK (reserve >= 0),
+    PRIMARY KEY (user_id, service_id, order_id, created_at)
+) PARTITION BY RANGE (created_at);
+
+CREATE INDEX operations_user_id_created_at_idx ON avito.operations (user_id, created_at);
+
+-- unique key of operations across partitions, kept for archived operations too
+CREATE TABLE avito.operation_keys
+(
+    user_id    VARCHAR(255) NOT NULL,
+    service_id VARCHAR(255) NOT NULL,
+    order_id   VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP    NOT NULL,
+    PRIMARY KEY (user_id, service_id, order_id)
+);
+
+CREATE SCHEMA IF NOT EXISTS avito_archive;
+
+CREATE TABLE avito_archive.operations
+(
+    LIKE avito.operations INCLUDING DEFAULTS INCLUDING CONSTRAINTS,
+    PRIMARY KEY (user_id, service_id, order_id, created_at)
+) PARTITION BY RANGE (created_at);
+
+CREATE INDEX operations_user_id_created_at_idx ON avito_archive.operations (user_id, created_at);
+
+-- create_operations_partition creates partition of the month containing ts
+CREATE FUNCTION avito.create_operations_partition(ts TIMESTAMP) RETURNS void AS
+$$
+DECLARE
+    since TIMESTAMP := date_trunc('month', ts);
+BEGIN
+    EXECUTE format(
+            'CREATE TABLE IF NOT EXISTS avito.%I PARTITION OF avito.operations FOR VALUES FROM (%L) TO (%L)',
+            'operations_' || to_char(since, 'YYYY_MM'), since, since + INTERVAL '1 month');
+END
+$$ LANGUAGE plpgsql;
+
+SELECT avito.create_operations_partition(month)
+FROM (SELECT DISTINCT date_trunc('month', created_at) AS month
+      FROM avito.operations_unpartitioned
+      UNION
+      SELECT date_trunc('month', NOW()::timestamp) + make_interval(months => m)
+      FROM generate_series(0, 2) m) months;
+
+INSERT INTO avito.operations
+SELECT user_id, service_id, order_id, operation_type, value, reserve, created_at, updated_at
+FROM avito.operations_unpartitioned;
+
+INSERT INTO avito.operation_keys
+SELECT user_id, service_id, order_id, created_at
+FROM avito.operations_unpartitioned;
+
+DROP TABLE avito.operations_unpartitioned;
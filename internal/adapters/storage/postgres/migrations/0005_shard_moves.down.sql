@@ -0,0 +1,5 @@
+DROP FUNCTION IF EXISTS avito.create_archive_partition(TIMESTAMP);
+DROP TRIGGER IF EXISTS balances_reject_moved_user ON avito.balances;
+DROP FUNCTION IF EXISTS avito.reject_moved_user();
+DROP TABLE IF EXISTS avito.shard_moves;
+DROP TABLE IF EXISTS avito.moved_users;
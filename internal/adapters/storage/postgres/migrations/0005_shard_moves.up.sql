@@ -0,0 +1,47 @@
+-- users moved to another shard, their balance must not be recreated here
+-- by instances still routing to this shard
+CREATE TABLE avito.moved_users
+(
+    user_id  VARCHAR(255) PRIMARY KEY,
+    moved_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- moves committed on the target shard, decides outcome of the prepared
+-- transaction on the source shard
+CREATE TABLE avito.shard_moves
+(
+    gid      VARCHAR(200) PRIMARY KEY,
+    user_id  VARCHAR(255) NOT NULL,
+    moved_at TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+-- AFTER trigger checks with a fresh snapshot, so an insert which waited for
+-- the move to commit sees the moved user
+CREATE FUNCTION avito.reject_moved_user() RETURNS trigger AS
+$$
+BEGIN
+    IF EXISTS (SELECT 1 FROM avito.moved_users WHERE user_id = NEW.user_id) THEN
+        RAISE EXCEPTION 'user % moved to another shard', NEW.user_id USING ERRCODE = 'BM001';
+    END IF;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER balances_reject_moved_user
+    AFTER INSERT
+    ON avito.balances
+    FOR EACH ROW
+EXECUTE FUNCTION avito.reject_moved_user();
+
+-- create_archive_partition creates archive partition of the month containing
+-- ts for operations moved from shards which archived it
+CREATE FUNCTION avito.create_archive_partition(ts TIMESTAMP) RETURNS void AS
+$$
+DECLARE
+    since TIMESTAMP := date_trunc('month', ts);
+BEGIN
+    EXECUTE format(
+            'CREATE TABLE IF NOT EXISTS avito_archive.%I PARTITION OF avito_archive.operations FOR VALUES FROM (%L) TO (%L)',
+            'operations_' || to_char(since, 'YYYY_MM'), since, since + INTERVAL '1 month');
+END
+$$ LANGUAGE plpgsql;
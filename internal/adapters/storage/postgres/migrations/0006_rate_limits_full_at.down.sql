@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS avito.rate_limits_full_at_idx;
+ALTER TABLE avito.rate_limits
+    DROP COLUMN IF EXISTS full_at;
@@ -0,0 +1,6 @@
+-- when the bucket refills up to burst, full buckets are deleted as a bucket
+-- created again starts full; rows of buckets not taken from since stay
+ALTER TABLE avito.rate_limits
+    ADD COLUMN full_at TIMESTAMPTZ;
+
+CREATE INDEX rate_limits_full_at_idx ON avito.rate_limits (full_at);
@@ -0,0 +1,276 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"regexp"
+	"service/internal/entities"
+	"time"
+)
+
+const (
+	// MoveGIDPrefix starts ids of prepared transactions of user moves.
+	MoveGIDPrefix = "balance-move-"
+
+	sqlStateUserMoved = "BM001"
+	operationMove     = "move"
+)
+
+// ErrUserMoved is returned by writes of a user moved to another shard.
+var ErrUserMoved = errors.New("user moved to another shard")
+
+// gids are put into statements as literals, PREPARE TRANSACTION takes no parameters
+var gidPattern = regexp.MustCompile(`^` + MoveGIDPrefix + `[0-9a-z-]+$`)
+
+// UserData is balance and operations of a user taken out of a shard.
+type UserData struct {
+	UserID     string
+	Balance    entities.Currency
+	operations []movedOperation
+}
+
+// Operations is the number of moved operations.
+func (d *UserData) Operations() int {
+	return len(d.operations)
+}
+
+type movedOperation struct {
+	serviceID     string
+	orderID       string
+	operationType int
+	value         int
+	reserve       int
+	createdAt     time.Time
+	updatedAt     time.Time
+	archived      bool
+}
+
+// PrepareMoveOut is the first phase of moving user to another shard: it
+// deletes user's balance and operations, marks the user moved and prepares
+// the transaction as gid. The prepared transaction keeps user's rows locked
+// until CommitPrepared or RollbackPrepared.
+// nolint:errcheck // rollback of failed transaction
+func (s *Storage) PrepareMoveOut(ctx context.Context, userID, gid string) (*UserData, error) {
+	if err := validateGID(gid); err != nil {
+		return nil, err
+	}
+
+	conn, err := s.db.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	// BEGIN is sent explicitly as pgx.Tx can not end with PREPARE TRANSACTION
+	if _, err = conn.Exec(ctx, `BEGIN`); err != nil {
+		return nil, err
+	}
+
+	data, err := takeUser(ctx, conn, userID)
+	if err == nil {
+		_, err = conn.Exec(ctx, fmt.Sprintf(`PREPARE TRANSACTION '%s'`, gid))
+	}
+	if err != nil {
+		conn.Exec(context.Background(), `ROLLBACK`)
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func takeUser(ctx context.Context, db db, userID string) (*UserData, error) {
+	data := &UserData{UserID: userID}
+
+	err := db.QueryRow(ctx, `SELECT value FROM avito.balances WHERE user_id = $1 FOR UPDATE`, userID).
+		Scan(&data.Balance)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errors.WithMessage(entities.ErrNotFound, "balance not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, table := range []string{"avito.operations", "avito_archive.operations"} {
+		rows, err := db.Query(ctx, fmt.Sprintf(`SELECT service_id, order_id, operation_type, value, reserve,
+				created_at, updated_at, %[2]t
+			FROM %[1]s WHERE user_id = $1 FOR UPDATE`, table, table != "avito.operations"), userID)
+		if err != nil {
+			return nil, err
+		}
+
+		operations, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (movedOperation, error) {
+			var op movedOperation
+			err := row.Scan(&op.serviceID, &op.orderID, &op.operationType, &op.value, &op.reserve,
+				&op.createdAt, &op.updatedAt, &op.archived)
+			return op, err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		data.operations = append(data.operations, operations...)
+	}
+
+	for _, query := range []string{
+		`DELETE FROM avito.operations WHERE user_id = $1`,
+		`DELETE FROM avito_archive.operations WHERE user_id = $1`,
+		`DELETE FROM avito.operation_keys WHERE user_id = $1`,
+		`DELETE FROM avito.balances WHERE user_id = $1`,
+		`INSERT INTO avito.moved_users (user_id) VALUES ($1)
+			ON CONFLICT (user_id) DO UPDATE SET moved_at = NOW()`,
+	} {
+		if _, err = db.Exec(ctx, query, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// MoveIn is the second phase of moving user: it adds user's data taken out of
+// another shard and records gid, so the outcome of the prepared transaction
+// is known from MoveCommitted even if the mover fails right after.
+func (s *Storage) MoveIn(ctx context.Context, data *UserData, gid string) error {
+	return s.tx(ctx, operationMove, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `DELETE FROM avito.moved_users WHERE user_id = $1`, data.UserID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `INSERT INTO avito.balances (user_id, value, created_at, updated_at)
+			VALUES ($1, $2, NOW(), NOW())
+			ON CONFLICT (user_id) DO UPDATE SET value = balances.value + EXCLUDED.value, updated_at = NOW()`,
+			data.UserID, data.Balance)
+		if err != nil {
+			return err
+		}
+
+		if err = copyOperations(ctx, tx, data); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `INSERT INTO avito.shard_moves (gid, user_id) VALUES ($1, $2)`, gid, data.UserID)
+
+		return err
+	})
+}
+
+func copyOperations(ctx context.Context, tx pgx.Tx, data *UserData) error {
+	type month struct {
+		since    time.Time
+		archived bool
+	}
+
+	months := map[month]struct{}{}
+	keys := make([][]any, 0, len(data.operations))
+	tables := map[bool][][]any{}
+
+	for _, op := range data.operations {
+		since := time.Date(op.createdAt.Year(), op.createdAt.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+		m := month{since: since, archived: op.archived}
+		if _, ok := months[m]; !ok {
+			partition := `SELECT avito.create_operations_partition($1)`
+			if op.archived {
+				partition = `SELECT avito.create_archive_partition($1)`
+			}
+
+			if _, err := tx.Exec(ctx, partition, m.since); err != nil {
+				return err
+			}
+
+			months[m] = struct{}{}
+		}
+
+		keys = append(keys, []any{data.UserID, op.serviceID, op.orderID, op.createdAt})
+		tables[op.archived] = append(tables[op.archived], []any{
+			data.UserID, op.serviceID, op.orderID, op.operationType, op.value, op.reserve, op.createdAt, op.updatedAt,
+		})
+	}
+
+	_, err := tx.CopyFrom(ctx, pgx.Identifier{"avito", "operation_keys"},
+		[]string{"user_id", "service_id", "order_id", "created_at"}, pgx.CopyFromRows(keys))
+	if err != nil {
+		return err
+	}
+
+	for archived, rows := range tables {
+		table := pgx.Identifier{"avito", "operations"}
+		if archived {
+			table = pgx.Identifier{"avito_archive", "operations"}
+		}
+
+		_, err = tx.CopyFrom(ctx, table, []string{
+			"user_id", "service_id", "order_id", "operation_type", "value", "reserve", "created_at", "updated_at",
+		}, pgx.CopyFromRows(rows))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MoveCommitted reports whether MoveIn of gid committed on this shard.
+func (s *Storage) MoveCommitted(ctx context.Context, gid string) (bool, error) {
+	var committed bool
+
+	err := s.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM avito.shard_moves WHERE gid = $1)`, gid).Scan(&committed)
+
+	return committed, err
+}
+
+// CommitPrepared commits transaction prepared by PrepareMoveOut.
+func (s *Storage) CommitPrepared(ctx context.Context, gid string) error {
+	if err := validateGID(gid); err != nil {
+		return err
+	}
+
+	_, err := s.db.Exec(ctx, fmt.Sprintf(`COMMIT PREPARED '%s'`, gid))
+
+	return err
+}
+
+// RollbackPrepared rolls back transaction prepared by PrepareMoveOut.
+func (s *Storage) RollbackPrepared(ctx context.Context, gid string) error {
+	if err := validateGID(gid); err != nil {
+		return err
+	}
+
+	_, err := s.db.Exec(ctx, fmt.Sprintf(`ROLLBACK PREPARED '%s'`, gid))
+
+	return err
+}
+
+func validateGID(gid string) error {
+	if !gidPattern.MatchString(gid) {
+		return errors.WithMessagef(entities.ErrInvalidParam, "invalid move gid %q", gid)
+	}
+
+	return nil
+}
+
+// PreparedMoves lists gids of moves prepared on this shard at least age ago and not finished.
+func (s *Storage) PreparedMoves(ctx context.Context, age time.Duration) ([]string, error) {
+	rows, err := s.db.Query(ctx, `SELECT gid FROM pg_prepared_xacts
+		WHERE database = current_database() AND gid LIKE $1 || '%' AND prepared <= NOW() - $2::interval`,
+		MoveGIDPrefix, age)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// UserIDs lists ids of users with balance on this shard after the given id.
+func (s *Storage) UserIDs(ctx context.Context, after string, limit int) ([]string, error) {
+	rows, err := s.db.Query(ctx, `SELECT user_id FROM avito.balances
+		WHERE user_id > $1 ORDER BY user_id LIMIT $2`, after, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
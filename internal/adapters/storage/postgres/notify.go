@@ -0,0 +1,115 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"service/internal/entities"
+	"time"
+)
+
+const (
+	eventsChannel         = "balance_events"
+	listenReconnectPeriod = time.Second
+)
+
+type notification struct {
+	UserID        string    `json:"user_id"`
+	ServiceID     string    `json:"service_id"`
+	OrderID       string    `json:"order_id"`
+	OperationType int       `json:"operation_type"`
+	Value         int       `json:"value"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+func (s *Storage) Subscribe(ctx context.Context, userID string) (<-chan *entities.Operation, error) {
+	return s.subscribers.Subscribe(ctx, userID)
+}
+
+// WatchChanges streams ids of users changed by any process sharing the
+// database until ctx is done. An empty id means notifications may have been
+// missed, on reconnect of the listener or when the watcher falls behind.
+func (s *Storage) WatchChanges(ctx context.Context) (<-chan string, error) {
+	return s.subscribers.Watch(ctx), nil
+}
+
+func (s *Storage) notify(ctx context.Context, db db, operation *entities.Operation) error {
+	payload, err := json.Marshal(&notification{
+		UserID:        operation.UserID(),
+		ServiceID:     operation.ServiceID(),
+		OrderID:       operation.OrderID(),
+		OperationType: int(operation.OperationType()),
+		Value:         int(operation.Value()),
+		CreatedAt:     time.Now().UTC(),
+	})
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return errors.WithMessage(entities.ErrInternal, err.Error())
+	}
+
+	_, err = db.Exec(ctx, `SELECT pg_notify($1, $2)`, eventsChannel, string(payload))
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return errors.WithMessage(entities.ErrInternal, err.Error())
+	}
+
+	return nil
+}
+
+func (s *Storage) listen(ctx context.Context) {
+	for {
+		err := s.waitNotifications(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		s.log.Error(err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(listenReconnectPeriod):
+		}
+	}
+}
+
+// nolint:errcheck // safety in library
+func (s *Storage) waitNotifications(ctx context.Context) error {
+	poolConn, err := s.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	conn := poolConn.Hijack()
+	defer conn.Close(context.Background())
+
+	_, err = conn.Exec(ctx, "LISTEN "+eventsChannel)
+	if err != nil {
+		return err
+	}
+
+	// changes made while not listening are unknown
+	s.subscribers.Lost()
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		msg := &notification{}
+		if err = json.Unmarshal([]byte(n.Payload), msg); err != nil {
+			s.log.Error(err)
+			continue
+		}
+
+		s.subscribers.Publish(entities.NewOperation(
+			msg.UserID,
+			msg.ServiceID,
+			msg.OrderID,
+			entities.OperationType(msg.OperationType),
+			entities.Currency(msg.Value),
+			msg.CreatedAt,
+		))
+	}
+}
@@ -0,0 +1,48 @@
+package postgres
+
+import "time"
+
+// RetryOptions bound retries of transactions failed with serialization
+// failure or deadlock. Delay before n-th retry is random in [0, min(MaxDelay, BaseDelay*2^(n-1))].
+type RetryOptions struct {
+	// MaxAttempts counts the first attempt, 1 disables retries.
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+type Options struct {
+	// Isolation maps operation to isolation level, operations not listed
+	// run in read committed.
+	Isolation map[string]string
+	Retry     RetryOptions
+	// Replicas are DSNs of read replicas, reads go to the primary when empty.
+	Replicas []string
+	// ReplicaMaxLag excludes lagging replicas from reads.
+	ReplicaMaxLag time.Duration
+	Pool          PoolOptions
+	// QueryTimeout is the default deadline of every storage call, zero
+	// leaves calls bounded by caller's context only.
+	QueryTimeout time.Duration
+	Partitions   PartitionOptions
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Isolation: map[string]string{},
+		Retry: RetryOptions{
+			MaxAttempts: defaultMaxAttempts,
+			BaseDelay:   defaultBaseDelay,
+			MaxDelay:    defaultMaxDelay,
+		},
+		ReplicaMaxLag: defaultReplicaMaxLag,
+		Pool: PoolOptions{
+			ConnectTimeout: defaultConnectTimeout,
+		},
+		QueryTimeout: defaultQueryTimeout,
+		Partitions: PartitionOptions{
+			Premake:     defaultPartitionsPremake,
+			CheckPeriod: defaultPartitionsCheckPeriod,
+		},
+	}
+}
@@ -0,0 +1,253 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"service/internal/entities"
+	"time"
+)
+
+const (
+	partitionsLockID     = 7_311_042_019
+	partitionsLockWait   = "5s"
+	operationPartitions  = "partitions"
+	partitionNameLayout  = "operations_2006_01"
+	partitionBoundLayout = "2006-01-02 15:04:05"
+
+	defaultPartitionsPremake     = 2
+	defaultPartitionsCheckPeriod = time.Hour
+)
+
+// PartitionOptions control maintenance of monthly partitions of operations.
+type PartitionOptions struct {
+	// Premake is the number of months ahead of the current one to create partitions for.
+	Premake int
+	// RetentionMonths keeps partitions of that many closed months in
+	// avito.operations, older ones are moved to avito_archive. Zero disables archival.
+	RetentionMonths int
+	// CheckPeriod of the maintenance job. Operations of months without
+	// partition can not be created, so the job can not be disabled.
+	CheckPeriod time.Duration
+}
+
+func (o PartitionOptions) validate() error {
+	if o.Premake < 0 || o.RetentionMonths < 0 {
+		return errors.WithMessage(entities.ErrInvalidParam, "negative partition options")
+	}
+
+	if o.CheckPeriod <= 0 {
+		return errors.WithMessage(entities.ErrInvalidParam, "partitions check period must be positive")
+	}
+
+	return nil
+}
+
+// maintainPartitions creates upcoming partitions and archives old ones every
+// check period until ctx is done.
+func (s *Storage) maintainPartitions(ctx context.Context) {
+	for {
+		if err := s.MaintainPartitions(ctx); err != nil && ctx.Err() == nil {
+			s.log.Errorf("maintain partitions: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.partitions.CheckPeriod):
+		}
+	}
+}
+
+// MaintainPartitions creates partitions of the current and premake months and
+// moves partitions older than retention to the archive schema. Concurrent runs
+// by other instances are skipped. Every step runs in a transaction of its own
+// and scans of partitions take no locks blocking operations, statement timeout
+// does not apply to them, while waiting for locks held by queries is bounded
+// to not block queries queued behind.
+// nolint:errcheck // unlock is released with the session anyway
+func (s *Storage) MaintainPartitions(ctx context.Context) error {
+	conn, err := s.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	var locked bool
+	if err = conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, partitionsLockID).Scan(&locked); err != nil {
+		return err
+	}
+
+	if !locked {
+		return nil
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, partitionsLockID)
+
+	err = s.maintenanceTx(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `SELECT avito.create_operations_partition(
+				date_trunc('month', NOW()::timestamp) + make_interval(months => m))
+			FROM generate_series(0, $1::int) m`, s.partitions.Premake)
+		return err
+	})
+	if err != nil {
+		return errors.WithMessage(err, "create partitions")
+	}
+
+	if s.partitions.RetentionMonths == 0 {
+		return nil
+	}
+
+	return s.archivePartitions(ctx)
+}
+
+// maintenanceTx runs fn without statement timeout and with bounded lock waits.
+func (s *Storage) maintenanceTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	return s.tx(ctx, operationPartitions, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `SELECT set_config('statement_timeout', '0', true), set_config('lock_timeout', $1, true)`,
+			partitionsLockWait)
+		if err != nil {
+			return err
+		}
+
+		return fn(tx)
+	})
+}
+
+// archivePartitions moves partitions of months ended retention months
+// before the current one from avito.operations to avito_archive.operations.
+// Partitions with open reserves are kept, as reserves are committed in live
+// operations only.
+func (s *Storage) archivePartitions(ctx context.Context) error {
+	var cutoff time.Time
+
+	err := s.db.QueryRow(ctx, `SELECT date_trunc('month', NOW()::timestamp) - make_interval(months => $1::int)`,
+		s.partitions.RetentionMonths).Scan(&cutoff)
+	if err != nil {
+		return err
+	}
+
+	rows, err := s.db.Query(ctx, `SELECT c.relname
+		FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'avito.operations'::regclass`)
+	if err != nil {
+		return err
+	}
+
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		since, ok := partitionMonth(name)
+		if !ok || since.AddDate(0, 1, 0).After(cutoff) {
+			continue
+		}
+
+		archived, err := s.archivePartition(ctx, name, since)
+		if err != nil {
+			return errors.WithMessagef(err, "archive partition %s", name)
+		}
+
+		if archived {
+			s.log.Infof("archived partition %s", name)
+		} else {
+			s.log.Infof("partition %s has open reserves, kept live", name)
+		}
+	}
+
+	return nil
+}
+
+// archivePartition moves partition to the archive schema, or merges its rows
+// into the archive partition of that month created by moves between shards.
+// It returns false when the partition has open reserves.
+//
+// Attaching a table scans it for rows out of the partition bounds, unless a
+// valid check constraint proves there are none. The constraint is added
+// without validation and validated in separate transactions, which do not
+// block operations, so avito.operations is locked only by detach and attach.
+func (s *Storage) archivePartition(ctx context.Context, name string, since time.Time) (bool, error) {
+	live := pgx.Identifier{"avito", name}.Sanitize()
+	archive := pgx.Identifier{"avito_archive", name}.Sanitize()
+	bounds := pgx.Identifier{name + "_bounds"}.Sanitize()
+	from, to := since.Format(partitionBoundLayout), since.AddDate(0, 1, 0).Format(partitionBoundLayout)
+
+	var exists, open bool
+
+	err := s.maintenanceTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		if open, err = openReserves(ctx, tx, live); err != nil || open {
+			return err
+		}
+
+		if err = tx.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL`, archive).Scan(&exists); err != nil || exists {
+			return err
+		}
+
+		return execAll(ctx, tx,
+			fmt.Sprintf(`ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s`, live, bounds),
+			fmt.Sprintf(`ALTER TABLE %s ADD CONSTRAINT %s CHECK (created_at >= '%s' AND created_at < '%s') NOT VALID`,
+				live, bounds, from, to),
+		)
+	})
+	if err != nil || open {
+		return false, err
+	}
+
+	// reserves of closed months only decrease, so the partition has none from now on
+	if exists {
+		return true, s.maintenanceTx(ctx, func(tx pgx.Tx) error {
+			return execAll(ctx, tx,
+				fmt.Sprintf(`INSERT INTO %s SELECT * FROM %s`, archive, live),
+				fmt.Sprintf(`ALTER TABLE avito.operations DETACH PARTITION %s`, live),
+				fmt.Sprintf(`DROP TABLE %s`, live),
+			)
+		})
+	}
+
+	err = s.maintenanceTx(ctx, func(tx pgx.Tx) error {
+		return execAll(ctx, tx, fmt.Sprintf(`ALTER TABLE %s VALIDATE CONSTRAINT %s`, live, bounds))
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return true, s.maintenanceTx(ctx, func(tx pgx.Tx) error {
+		return execAll(ctx, tx,
+			fmt.Sprintf(`ALTER TABLE avito.operations DETACH PARTITION %s`, live),
+			fmt.Sprintf(`ALTER TABLE %s SET SCHEMA avito_archive`, live),
+			fmt.Sprintf(`ALTER TABLE avito_archive.operations ATTACH PARTITION %s FOR VALUES FROM ('%s') TO ('%s')`,
+				archive, from, to),
+			fmt.Sprintf(`ALTER TABLE %s DROP CONSTRAINT %s`, archive, bounds),
+		)
+	})
+}
+
+// openReserves reports whether partition has reserves not committed yet.
+func openReserves(ctx context.Context, db db, partition string) (bool, error) {
+	var open bool
+
+	err := db.QueryRow(ctx, fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s WHERE reserve > 0)`, partition)).Scan(&open)
+
+	return open, err
+}
+
+func execAll(ctx context.Context, tx pgx.Tx, queries ...string) error {
+	for _, query := range queries {
+		if _, err := tx.Exec(ctx, query); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// partitionMonth parses start of the month of partition named by
+// avito.create_operations_partition, false for other tables.
+func partitionMonth(name string) (time.Time, bool) {
+	since, err := time.Parse(partitionNameLayout, name)
+
+	return since, err == nil
+}
@@ -0,0 +1,19 @@
+package postgres
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPartitionMonth(t *testing.T) {
+	since, ok := partitionMonth("operations_2022_03")
+	if !ok || !since.Equal(time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("operations_2022_03: month = %s, %v", since, ok)
+	}
+
+	for _, name := range []string{"operations_default", "operations_2022_13", "balances"} {
+		if _, ok = partitionMonth(name); ok {
+			t.Errorf("%s: parsed as partition", name)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package postgres
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+	"service/internal/entities"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultConnectTimeout = 5 * time.Second
+	defaultQueryTimeout   = 10 * time.Second
+)
+
+// PoolOptions tune connection pools of the primary and replicas, zero
+// values keep pgxpool defaults.
+type PoolOptions struct {
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+	// ConnectTimeout bounds establishing pools on start.
+	ConnectTimeout time.Duration
+	// StatementTimeout is set as statement_timeout of every connection,
+	// so the server aborts queries running longer.
+	StatementTimeout time.Duration
+}
+
+func (o PoolOptions) validate() error {
+	if o.MaxConns < 0 || o.MinConns < 0 {
+		return errors.WithMessage(entities.ErrInvalidParam, "negative pool size")
+	}
+
+	if o.MaxConns > 0 && o.MinConns > o.MaxConns {
+		return errors.WithMessagef(entities.ErrInvalidParam, "min conns %d exceeds max conns %d", o.MinConns, o.MaxConns)
+	}
+
+	if o.MaxConnLifetime < 0 || o.MaxConnIdleTime < 0 || o.HealthCheckPeriod < 0 ||
+		o.ConnectTimeout < 0 || o.StatementTimeout < 0 {
+		return errors.WithMessage(entities.ErrInvalidParam, "negative pool duration")
+	}
+
+	return nil
+}
+
+// poolConfig parses dsn and applies pool options on top of it.
+func (o PoolOptions) poolConfig(dsn string) (*pgxpool.Config, error) {
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.MaxConns > 0 {
+		config.MaxConns = o.MaxConns
+	}
+
+	if o.MinConns > 0 {
+		config.MinConns = o.MinConns
+	}
+
+	if o.MaxConnLifetime > 0 {
+		config.MaxConnLifetime = o.MaxConnLifetime
+	}
+
+	if o.MaxConnIdleTime > 0 {
+		config.MaxConnIdleTime = o.MaxConnIdleTime
+	}
+
+	if o.HealthCheckPeriod > 0 {
+		config.HealthCheckPeriod = o.HealthCheckPeriod
+	}
+
+	if o.StatementTimeout > 0 {
+		config.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(o.StatementTimeout.Milliseconds(), 10)
+	}
+
+	config.ConnConfig.Tracer = newQueryTracer()
+
+	return config, nil
+}
+
+// connect opens pool, establishing min conns within connect timeout.
+func (o PoolOptions) connect(dsn string) (*pgxpool.Pool, error) {
+	config, err := o.poolConfig(dsn)
+	if err != nil {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.connectTimeout())
+	defer cancel()
+
+	return pgxpool.NewWithConfig(ctx, config)
+}
+
+// ping checks db is reachable within connect timeout.
+func (o PoolOptions) ping(db *pgxpool.Pool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), o.connectTimeout())
+	defer cancel()
+
+	return db.Ping(ctx)
+}
+
+func (o PoolOptions) connectTimeout() time.Duration {
+	if o.ConnectTimeout <= 0 {
+		return defaultConnectTimeout
+	}
+
+	return o.ConnectTimeout
+}
+
+// withTimeout bounds ctx by query timeout unless it already has an earlier
+// deadline, so a hung database fails calls instead of blocking callers.
+func (s *Storage) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return ctx, func() {}
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= s.queryTimeout {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, s.queryTimeout)
+}
@@ -8,23 +8,39 @@ import (
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
+	"service/internal/adapters/storage/subscribers"
 	"service/internal/cases"
 	"service/internal/entities"
+	"service/internal/logging"
+	"sync/atomic"
 	"time"
 )
 
 var (
-	_ cases.Storage = (*Storage)(nil)
+	_ cases.Storage          = (*Storage)(nil)
+	_ cases.Notifier         = (*Storage)(nil)
+	_ cases.OperationArchive = (*Storage)(nil)
 )
 
 type Storage struct {
-	log    *zap.SugaredLogger
-	cancel context.CancelFunc
-	db     *pgxpool.Pool
+	log             *zap.SugaredLogger
+	cancel          context.CancelFunc
+	db              *pgxpool.Pool
+	subscribers     *subscribers.Subscribers
+	isolationLevels map[string]pgx.TxIsoLevel
+	retry           RetryOptions
+	txConflicts     *prometheus.CounterVec
+	replicas        []*replica
+	replicaMaxLag   time.Duration
+	nextReplica     atomic.Uint32
+	reads           *prometheus.CounterVec
+	queryTimeout    time.Duration
+	partitions      PartitionOptions
 }
 
-func NewStorage(log *zap.SugaredLogger, dsn string) (*Storage, error) {
+func NewStorage(log *zap.SugaredLogger, dsn string, opts Options) (*Storage, error) {
 	if log == nil {
 		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty logger")
 	}
@@ -33,25 +49,83 @@ func NewStorage(log *zap.SugaredLogger, dsn string) (*Storage, error) {
 		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty dsn")
 	}
 
-	st := &Storage{
-		log: log,
+	isolationLevels, err := parseIsolation(opts.Isolation)
+	if err != nil {
+		return nil, err
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	st.cancel = cancel
+	if err = opts.Pool.validate(); err != nil {
+		return nil, err
+	}
 
-	conn, err := pgxpool.New(ctx, dsn)
-	if err != nil {
+	if err = opts.Partitions.validate(); err != nil {
 		return nil, err
 	}
 
-	err = conn.Ping(ctx)
+	if opts.QueryTimeout < 0 {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "negative query timeout")
+	}
+
+	if opts.Retry.MaxAttempts < 1 {
+		opts.Retry.MaxAttempts = 1
+	}
+
+	st := &Storage{
+		log:             log,
+		subscribers:     subscribers.New(),
+		isolationLevels: isolationLevels,
+		retry:           opts.Retry,
+		txConflicts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "balance",
+			Subsystem: "storage",
+			Name:      "tx_conflicts_total",
+			Help:      "Transactions failed with serialization failure or deadlock, by outcome: retried or exhausted.",
+		}, []string{"operation", "code", "outcome"}),
+		replicaMaxLag: opts.ReplicaMaxLag,
+		queryTimeout:  opts.QueryTimeout,
+		partitions:    opts.Partitions,
+		reads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "balance",
+			Subsystem: "storage",
+			Name:      "reads_total",
+			Help:      "Read-only queries by pool they were routed to.",
+		}, []string{"target"}),
+	}
+
+	if st.replicaMaxLag <= 0 {
+		st.replicaMaxLag = defaultReplicaMaxLag
+	}
+
+	conn, err := opts.Pool.connect(dsn)
 	if err != nil {
 		return nil, err
 	}
 
+	if err = opts.Pool.ping(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
 	st.db = conn
 
+	if err = st.connectReplicas(opts.Replicas, opts.Pool); err != nil {
+		st.closeReplicas()
+		conn.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	st.cancel = cancel
+
+	go st.listen(ctx)
+
+	if len(st.replicas) != 0 {
+		go st.checkReplicas(ctx)
+	}
+
+	go st.maintainPartitions(ctx)
+	go st.sweepRateLimits(ctx)
+
 	return st, nil
 }
 
@@ -59,7 +133,10 @@ func (s *Storage) CreateOrUpdateBalance(
 	ctx context.Context,
 	operation *entities.Operation,
 ) error {
-	if err := s.tx(ctx, func(tx pgx.Tx) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.tx(ctx, OperationCredit, func(tx pgx.Tx) error {
 		err := s.createOrUpdateBalance(ctx, tx, operation.UserID(), operation.Value())
 		if err != nil {
 			return err
@@ -70,15 +147,20 @@ func (s *Storage) CreateOrUpdateBalance(
 			return err
 		}
 
-		return nil
+		return s.notify(ctx, tx, operation)
 	}); err != nil {
 		return err
 	}
 
+	s.recordToken(ctx)
+
 	return nil
 }
 
 func (s *Storage) GetBalance(ctx context.Context, userID string) (*entities.Balance, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	query := `SELECT "value" from avito.balances 
 		WHERE user_id=$1`
 
@@ -86,15 +168,15 @@ func (s *Storage) GetBalance(ctx context.Context, userID string) (*entities.Bala
 		value int
 	)
 
-	row := s.db.QueryRow(ctx, query, &userID)
+	row := s.reader(ctx).QueryRow(ctx, query, &userID)
 	err := row.Scan(&value)
 	if errors.Is(err, pgx.ErrNoRows) {
 		err = errors.WithMessage(entities.ErrNotFound, "balance not found")
-		s.log.Error(err)
+		s.logger(ctx).Error(err)
 		return nil, err
 	}
 	if err != nil {
-		s.log.Error(err)
+		s.logger(ctx).Error(err)
 		return nil, errors.WithMessage(entities.ErrInternal, err.Error())
 	}
 
@@ -104,21 +186,26 @@ func (s *Storage) GetBalance(ctx context.Context, userID string) (*entities.Bala
 }
 
 func (s *Storage) CreateOperation(ctx context.Context, operation *entities.Operation) error {
-	if err := s.tx(ctx, func(tx pgx.Tx) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.tx(ctx, OperationReserve, func(tx pgx.Tx) error {
 		err := s.decreaseBalance(ctx, tx, operation.UserID(), operation.Value())
 		if err != nil {
 			return err
 		}
 
-		if err := s.createOperation(ctx, s.db, operation, operation.Value()); err != nil {
+		if err := s.createOperation(ctx, tx, operation, operation.Value()); err != nil {
 			return err
 		}
 
-		return nil
+		return s.notify(ctx, tx, operation)
 	}); err != nil {
 		return err
 	}
 
+	s.recordToken(ctx)
+
 	return nil
 }
 
@@ -128,6 +215,9 @@ func (s *Storage) GetOperation(
 	orderID string,
 	serviceID string,
 ) (*entities.Operation, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	query := `SELECT operation_type, "value", created_at
 		from avito.operations 
 		WHERE order_id=$1 AND service_id=$2 AND user_id=$3`
@@ -138,15 +228,15 @@ func (s *Storage) GetOperation(
 		createdAt     time.Time
 	)
 
-	row := s.db.QueryRow(ctx, query, &orderID, &serviceID, &userID)
+	row := s.reader(ctx).QueryRow(ctx, query, &orderID, &serviceID, &userID)
 	err := row.Scan(&operationType, &value, &createdAt)
 	if errors.Is(err, pgx.ErrNoRows) {
 		err = errors.WithMessage(entities.ErrNotFound, "operation not found")
-		s.log.Error(err)
+		s.logger(ctx).Error(err)
 		return nil, err
 	}
 	if err != nil {
-		s.log.Error(err)
+		s.logger(ctx).Error(err)
 		return nil, errors.WithMessage(entities.ErrInternal, err.Error())
 	}
 
@@ -163,6 +253,9 @@ func (s *Storage) GetOperation(
 }
 
 func (s *Storage) UpdateOperationReserve(ctx context.Context, operation *entities.Operation) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	query := `UPDATE avito.operations
 				SET reserve            = reserve - $1
 				WHERE order_id = $2 AND service_id=$3 AND user_id = $4`
@@ -174,24 +267,33 @@ func (s *Storage) UpdateOperationReserve(ctx context.Context, operation *entitie
 		operation.UserID(),
 	}
 
-	res, err := s.db.Exec(ctx, query, params...)
-	var pge *pgconn.PgError
-	if errors.As(err, &pge) {
-		s.log.Error(err)
-		if pge.Code == pgerrcode.CheckViolation {
-			return entities.ErrCommitInvalidValue
+	err := s.tx(ctx, OperationCommit, func(tx pgx.Tx) error {
+		res, err := tx.Exec(ctx, query, params...)
+		var pge *pgconn.PgError
+		if errors.As(err, &pge) {
+			s.logger(ctx).Error(err)
+			if pge.Code == pgerrcode.CheckViolation {
+				return entities.ErrCommitInvalidValue
+			}
 		}
-	}
+		if err != nil {
+			s.logger(ctx).Error(err)
+			return err
+		}
+
+		if res.RowsAffected() == 0 {
+			err = errors.WithMessage(entities.ErrNotFound, "operation not found")
+			s.logger(ctx).Error(err)
+			return err
+		}
+
+		return s.notify(ctx, tx, operation)
+	})
 	if err != nil {
-		s.log.Error(err)
 		return err
 	}
 
-	if res.RowsAffected() == 0 {
-		err = errors.WithMessage(entities.ErrNotFound, "operation not found")
-		s.log.Error(err)
-		return err
-	}
+	s.recordToken(ctx)
 
 	return nil
 }
@@ -202,7 +304,54 @@ func (s *Storage) ListOperations(
 	limit, offset int,
 	sortBy string, desc bool,
 ) ([]*entities.Operation, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`SELECT service_id, order_id, operation_type, value, created_at
+				FROM avito.operations
+				WHERE user_id = $1
+				ORDER BY %s`, operationsOrder(limit, offset, sortBy, desc))
+
+	return s.listOperations(ctx, userID, query, userID)
+}
 
+// ListOperationsInRange lists operations created in [from, to) including
+// archived ones, zero from or to leaves the range open.
+func (s *Storage) ListOperationsInRange(
+	ctx context.Context,
+	userID string,
+	from, to time.Time,
+	limit, offset int,
+	sortBy string, desc bool,
+) ([]*entities.Operation, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	where := "user_id = $1"
+	params := []interface{}{userID}
+
+	if !from.IsZero() {
+		params = append(params, from)
+		where += fmt.Sprintf(" AND created_at >= $%d", len(params))
+	}
+
+	if !to.IsZero() {
+		params = append(params, to)
+		where += fmt.Sprintf(" AND created_at < $%d", len(params))
+	}
+
+	query := fmt.Sprintf(`SELECT service_id, order_id, operation_type, value, created_at
+				FROM (
+					SELECT * FROM avito.operations WHERE %[1]s
+					UNION ALL
+					SELECT * FROM avito_archive.operations WHERE %[1]s
+				) operations
+				ORDER BY %[2]s`, where, operationsOrder(limit, offset, sortBy, desc))
+
+	return s.listOperations(ctx, userID, query, params...)
+}
+
+func operationsOrder(limit, offset int, sortBy string, desc bool) string {
 	var orderBy string
 
 	switch sortBy {
@@ -228,19 +377,20 @@ func (s *Storage) ListOperations(
 		queryParams += fmt.Sprintf(" OFFSET %d", offset)
 	}
 
-	query := fmt.Sprintf(`SELECT service_id, order_id, operation_type, value, created_at
-				FROM avito.operations
-				WHERE user_id = $1
-				ORDER BY %s`, queryParams)
-
-	params := []interface{}{
-		userID,
-	}
+	return queryParams
+}
 
-	rows, err := s.db.Query(ctx, query, params...)
+// listOperations runs query selecting operations of userID.
+func (s *Storage) listOperations(
+	ctx context.Context,
+	userID string,
+	query string,
+	params ...interface{},
+) ([]*entities.Operation, error) {
+	rows, err := s.reader(ctx).Query(ctx, query, params...)
 	if err != nil {
 		err = errors.WithMessage(entities.ErrInternal, err.Error())
-		s.log.Error(err)
+		s.logger(ctx).Error(err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -259,7 +409,7 @@ func (s *Storage) ListOperations(
 		err = rows.Scan(&serviceID, &orderID, &operationType, &value, &createdAt)
 		if err != nil {
 			err = errors.WithMessage(entities.ErrInternal, err.Error())
-			s.log.Error(err)
+			s.logger(ctx).Error(err)
 			return nil, err
 		}
 
@@ -276,9 +426,35 @@ func (s *Storage) ListOperations(
 	return operations, nil
 }
 
+// Ready checks that database is reachable and schema is migrated.
+func (s *Storage) Ready(ctx context.Context) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.db.Ping(ctx); err != nil {
+		return errors.WithMessage(err, "ping database")
+	}
+
+	var balances, operations *string
+
+	err := s.db.QueryRow(ctx, `SELECT to_regclass('avito.balances')::text, to_regclass('avito.operations')::text`).
+		Scan(&balances, &operations)
+	if err != nil {
+		return errors.WithMessage(err, "check schema")
+	}
+
+	if balances == nil || operations == nil {
+		return errors.New("schema is not migrated")
+	}
+
+	return nil
+}
+
+// Close stops notifications listener and replica checks and closes the pools.
 func (s *Storage) Close() {
-	s.db.Close()
 	s.cancel()
+	s.closeReplicas()
+	s.db.Close()
 }
 
 func (s *Storage) createOrUpdateBalance(ctx context.Context, db db, userID string, value entities.Currency) error {
@@ -292,8 +468,8 @@ func (s *Storage) createOrUpdateBalance(ctx context.Context, db db, userID strin
 
 	_, err := db.Exec(ctx, query, userID, value)
 	if err != nil {
-		s.log.Error(err)
-		return errors.WithMessage(entities.ErrInternal, err.Error())
+		s.logger(ctx).Error(err)
+		return internalError(err)
 	}
 
 	return nil
@@ -308,19 +484,19 @@ func (s *Storage) decreaseBalance(ctx context.Context, db db, userID string, val
 	res, err := db.Exec(ctx, query, value, userID)
 	var pge *pgconn.PgError
 	if errors.As(err, &pge) {
-		s.log.Error(err)
+		s.logger(ctx).Error(err)
 		if pge.Code == pgerrcode.CheckViolation {
 			return entities.ErrReserveInvalidValue
 		}
 	}
 	if err != nil {
-		s.log.Error(err)
-		return errors.WithMessage(entities.ErrInternal, err.Error())
+		s.logger(ctx).Error(err)
+		return internalError(err)
 	}
 
 	if res.RowsAffected() == 0 {
 		err = errors.WithMessage(entities.ErrNotFound, "balance not found")
-		s.log.Error(err)
+		s.logger(ctx).Error(err)
 		return err
 	}
 
@@ -333,7 +509,12 @@ func (s *Storage) createOperation(
 	operation *entities.Operation,
 	reserve entities.Currency,
 ) error {
-	query := `INSERT INTO avito.operations 
+	// operation_keys holds the key unique across partitions of operations
+	query := `WITH operation_key AS (
+		INSERT INTO avito.operation_keys (user_id, service_id, order_id, created_at)
+		VALUES ($1, $2, $3, NOW())
+	)
+	INSERT INTO avito.operations 
     (user_id, service_id, order_id, operation_type, "value", reserve, created_at, updated_at)
 	VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW())`
 
@@ -349,7 +530,7 @@ func (s *Storage) createOperation(
 	_, err := db.Exec(ctx, query, params...)
 	var pge *pgconn.PgError
 	if errors.As(err, &pge) {
-		s.log.Error(err)
+		s.logger(ctx).Error(err)
 		if pge.Code == pgerrcode.UniqueViolation {
 			return entities.ErrReserveAlreadyExists
 		}
@@ -358,30 +539,13 @@ func (s *Storage) createOperation(
 		}
 	}
 	if err != nil {
-		s.log.Error(err)
+		s.logger(ctx).Error(err)
 		return err
 	}
 
 	return nil
 }
 
-// nolint:errcheck // safety in library
-func (s *Storage) tx(ctx context.Context, fn func(tx pgx.Tx) error) error {
-	tx, err := s.db.Begin(ctx)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback(ctx)
-
-	err = fn(tx)
-	if err != nil {
-		return err
-	}
-
-	err = tx.Commit(ctx)
-	if err != nil {
-		return err
-	}
-
-	return nil
+func (s *Storage) logger(ctx context.Context) *zap.SugaredLogger {
+	return logging.FromContext(ctx, s.log)
 }
@@ -0,0 +1,102 @@
+package postgres
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"os"
+	"service/internal/adapters/storage/storagetest"
+	"service/internal/cases"
+	"testing"
+	"time"
+)
+
+// testDSNEnv names a database the storage suite runs against, the suite is
+// skipped when it is not set. Migrations are applied, data is not cleaned up.
+const testDSNEnv = "BALANCE_TEST_POSTGRES_DSN"
+
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDSNEnv)
+	}
+
+	ctx := context.Background()
+	log := zap.NewNop().Sugar()
+
+	migrator, err := NewMigrator(ctx, log, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = migrator.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := NewStorage(log, dsn, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(st.Close)
+
+	return st
+}
+
+func TestStorage(t *testing.T) {
+	st := newTestStorage(t)
+
+	storagetest.Run(t, func(t *testing.T) cases.Storage {
+		return st
+	})
+}
+
+func TestStorage_SweepRateLimits(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t)
+
+	// refills in a millisecond and in a thousand seconds
+	fast, slow := "test|"+uuid.NewString(), "test|"+uuid.NewString()
+
+	if _, err := st.Take(ctx, fast, 1000, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := st.Take(ctx, slow, 0.001, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	if err := st.SweepRateLimits(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	exists := func(key string) bool {
+		var found bool
+		if err := st.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM avito.rate_limits WHERE key = $1)`, key).
+			Scan(&found); err != nil {
+			t.Fatal(err)
+		}
+
+		return found
+	}
+
+	if exists(fast) {
+		t.Error("full bucket is not swept")
+	}
+
+	if !exists(slow) {
+		t.Fatal("bucket not refilled yet is swept")
+	}
+
+	// the kept bucket is still empty
+	if wait, err := st.Take(ctx, slow, 0.001, 1); err != nil || wait == 0 {
+		t.Errorf("take from kept slow bucket: wait %s, %v", wait, err)
+	}
+}
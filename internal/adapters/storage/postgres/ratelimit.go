@@ -0,0 +1,91 @@
+package postgres
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"service/internal/entities"
+	"time"
+)
+
+const rateLimitsSweepPeriod = time.Minute
+
+// Take keeps token buckets in avito.rate_limits, so limits hold across
+// replicas. Bucket row is locked while a token is taken.
+func (s *Storage) Take(ctx context.Context, key string, rate float64, burst int) (time.Duration, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var wait time.Duration
+
+	err := s.tx(ctx, OperationRateLimit, func(tx pgx.Tx) error {
+		var (
+			tokens float64
+			now    time.Time
+		)
+
+		err := tx.QueryRow(ctx, `INSERT INTO avito.rate_limits AS rl (key, tokens, updated_at)
+			VALUES ($1, $2::double precision, clock_timestamp())
+			ON CONFLICT (key) DO UPDATE SET key = rl.key
+			RETURNING
+				LEAST(
+					$2::double precision,
+					rl.tokens + EXTRACT(EPOCH FROM clock_timestamp() - rl.updated_at)::double precision * $3::double precision
+				),
+				clock_timestamp()`,
+			key, float64(burst), rate,
+		).Scan(&tokens, &now)
+		if err != nil {
+			return err
+		}
+
+		if tokens < 1 {
+			wait = time.Duration((1 - tokens) / rate * float64(time.Second))
+		} else {
+			tokens--
+		}
+
+		full := now.Add(time.Duration((float64(burst) - tokens) / rate * float64(time.Second)))
+
+		_, err = tx.Exec(ctx, `UPDATE avito.rate_limits SET tokens = $2, updated_at = $3, full_at = $4 WHERE key = $1`,
+			key, tokens, now, full)
+
+		return err
+	})
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return 0, errors.WithMessage(entities.ErrInternal, err.Error())
+	}
+
+	return wait, nil
+}
+
+// sweepRateLimits deletes buckets refilled up to burst every sweep period
+// until ctx is done, a bucket created again starts full, so limits are not
+// loosened. Without it the table keeps a row per key forever.
+func (s *Storage) sweepRateLimits(ctx context.Context) {
+	ticker := time.NewTicker(rateLimitsSweepPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.SweepRateLimits(ctx); err != nil && ctx.Err() == nil {
+			s.log.Errorf("sweep rate limits: %s", err)
+		}
+	}
+}
+
+// SweepRateLimits deletes buckets full by now.
+func (s *Storage) SweepRateLimits(ctx context.Context) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.Exec(ctx, `DELETE FROM avito.rate_limits WHERE full_at <= clock_timestamp()`)
+
+	return err
+}
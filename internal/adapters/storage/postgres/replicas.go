@@ -0,0 +1,220 @@
+package postgres
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+	"service/internal/consistency"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	replicaCheckPeriod    = 5 * time.Second
+	defaultReplicaMaxLag  = 5 * time.Second
+	replicaTokenTimeout   = time.Second
+	readTargetPrimary     = "primary"
+	readTargetMutation    = "primary_mutation"
+	readTargetReplica     = "replica"
+	readTargetStaleToken  = "primary_stale_token"
+	readTargetNoneHealthy = "primary_no_replica"
+)
+
+// replica is a read-only pool, excluded from reads while it lags behind
+// the primary more than max lag or is unreachable.
+type replica struct {
+	db      *pgxpool.Pool
+	healthy atomic.Bool
+	// behind is the oldest primary position the replica has not replayed,
+	// used by checkReplicas only
+	behind walPosition
+}
+
+type walPosition struct {
+	lsn uint64
+	// seen is when the position was read from the primary, zero for none
+	seen time.Time
+}
+
+func (s *Storage) connectReplicas(dsns []string, pool PoolOptions) error {
+	for i, dsn := range dsns {
+		db, err := pool.connect(dsn)
+		if err != nil {
+			return errors.WithMessagef(err, "replica %d", i)
+		}
+
+		s.replicas = append(s.replicas, &replica{db: db})
+	}
+
+	return nil
+}
+
+// checkReplicas refreshes replica health until ctx is done.
+func (s *Storage) checkReplicas(ctx context.Context) {
+	for {
+		for i, r := range s.replicas {
+			lag, err := s.replicaLag(ctx, r)
+
+			healthy := err == nil && lag <= s.replicaMaxLag
+			if healthy != r.healthy.Swap(healthy) {
+				s.log.With("replica", i, "lag", lag).Infof("replica healthy: %v, %v", healthy, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(replicaCheckPeriod):
+		}
+	}
+}
+
+// replicaLag is time since the primary was seen at a WAL position the
+// replica has not replayed yet. Replay timestamps are not used as they grow
+// on an idle primary, and received WAL is not compared with replayed as a
+// replica disconnected from the primary has replayed all it received.
+func (s *Storage) replicaLag(ctx context.Context, r *replica) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, replicaCheckPeriod)
+	defer cancel()
+
+	var primary, replayed string
+
+	if err := s.db.QueryRow(ctx, `SELECT pg_current_wal_lsn()::text`).Scan(&primary); err != nil {
+		return 0, errors.WithMessage(err, "primary wal position")
+	}
+
+	seen := time.Now()
+
+	if err := r.db.QueryRow(ctx, `SELECT COALESCE(pg_last_wal_replay_lsn()::text, '0/0')`).Scan(&replayed); err != nil {
+		return 0, err
+	}
+
+	primaryLSN, err := parseLSN(primary)
+	if err != nil {
+		return 0, err
+	}
+
+	replayedLSN, err := parseLSN(replayed)
+	if err != nil {
+		return 0, err
+	}
+
+	return r.behind.observe(primaryLSN, replayedLSN, seen), nil
+}
+
+// observe returns lag of a replica which has replayed up to replayed when
+// the primary was at primary at time seen.
+func (p *walPosition) observe(primary, replayed uint64, seen time.Time) time.Duration {
+	if !p.seen.IsZero() && replayed >= p.lsn {
+		*p = walPosition{}
+	}
+
+	if replayed >= primary {
+		return 0
+	}
+
+	if p.seen.IsZero() {
+		*p = walPosition{lsn: primary, seen: seen}
+	}
+
+	return seen.Sub(p.seen)
+}
+
+// parseLSN parses pg_lsn text form, two hex halves separated by slash.
+func parseLSN(lsn string) (uint64, error) {
+	hi, lo, ok := strings.Cut(lsn, "/")
+	if !ok {
+		return 0, errors.Errorf("invalid lsn %q", lsn)
+	}
+
+	high, err := strconv.ParseUint(hi, 16, 32)
+	if err != nil {
+		return 0, errors.Errorf("invalid lsn %q", lsn)
+	}
+
+	low, err := strconv.ParseUint(lo, 16, 32)
+	if err != nil {
+		return 0, errors.Errorf("invalid lsn %q", lsn)
+	}
+
+	return high<<32 | low, nil
+}
+
+// reader picks pool for read-only query: the first healthy replica in round
+// robin order which, when ctx has consistency token, has replayed the token's
+// WAL position; the primary otherwise.
+func (s *Storage) reader(ctx context.Context) db {
+	if len(s.replicas) == 0 {
+		s.reads.WithLabelValues(readTargetPrimary).Inc()
+		return s.db
+	}
+
+	if consistency.Primary(ctx) {
+		s.reads.WithLabelValues(readTargetMutation).Inc()
+		return s.db
+	}
+
+	start := s.nextReplica.Add(1)
+	token := consistency.Token(ctx)
+	stale := false
+
+	for i := range s.replicas {
+		r := s.replicas[(int(start)+i)%len(s.replicas)]
+		if !r.healthy.Load() {
+			continue
+		}
+
+		if token != "" && !replayed(ctx, r.db, token) {
+			stale = true
+			continue
+		}
+
+		s.reads.WithLabelValues(readTargetReplica).Inc()
+
+		return r.db
+	}
+
+	if stale {
+		s.reads.WithLabelValues(readTargetStaleToken).Inc()
+	} else {
+		s.reads.WithLabelValues(readTargetNoneHealthy).Inc()
+	}
+
+	return s.db
+}
+
+// replayed reports whether replica has applied WAL up to token, invalid tokens are treated as not replayed.
+func replayed(ctx context.Context, db db, token string) bool {
+	ctx, cancel := context.WithTimeout(ctx, replicaTokenTimeout)
+	defer cancel()
+
+	var ok bool
+
+	err := db.QueryRow(ctx, `SELECT COALESCE(pg_last_wal_replay_lsn() >= $1::text::pg_lsn, false)`, token).Scan(&ok)
+
+	return err == nil && ok
+}
+
+// recordToken stores WAL position of the committed mutation for read-your-writes.
+func (s *Storage) recordToken(ctx context.Context) {
+	if len(s.replicas) == 0 || !consistency.Recording(ctx) {
+		return
+	}
+
+	var lsn string
+
+	if err := s.db.QueryRow(ctx, `SELECT pg_current_wal_insert_lsn()::text`).Scan(&lsn); err != nil {
+		s.logger(ctx).Error(err)
+		return
+	}
+
+	consistency.Record(ctx, lsn)
+}
+
+func (s *Storage) closeReplicas() {
+	for _, r := range s.replicas {
+		r.db.Close()
+	}
+}
@@ -0,0 +1,51 @@
+package postgres
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLSN(t *testing.T) {
+	lsn, err := parseLSN("16/B374D848")
+	if err != nil || lsn != 0x16_B374D848 {
+		t.Errorf("lsn = %x, %v", lsn, err)
+	}
+
+	for _, invalid := range []string{"", "16", "x/1", "1/100000000"} {
+		if _, err = parseLSN(invalid); err == nil {
+			t.Errorf("%q parsed", invalid)
+		}
+	}
+}
+
+func TestWALPosition_Observe(t *testing.T) {
+	var p walPosition
+
+	now := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	if lag := p.observe(100, 100, now); lag != 0 {
+		t.Errorf("caught up replica lag = %s", lag)
+	}
+
+	// a streaming replica replays what it missed by the next check
+	if lag := p.observe(200, 150, now.Add(5*time.Second)); lag != 0 {
+		t.Errorf("first check behind lag = %s", lag)
+	}
+
+	if lag := p.observe(300, 250, now.Add(10*time.Second)); lag != 0 {
+		t.Errorf("streaming replica lag = %s", lag)
+	}
+
+	// a disconnected replica stays at 250 while the primary moves on
+	if lag := p.observe(400, 250, now.Add(15*time.Second)); lag != 5*time.Second {
+		t.Errorf("disconnected replica lag = %s, want 5s", lag)
+	}
+
+	if lag := p.observe(500, 250, now.Add(20*time.Second)); lag != 10*time.Second {
+		t.Errorf("disconnected replica lag = %s, want 10s", lag)
+	}
+
+	if lag := p.observe(500, 500, now.Add(25*time.Second)); lag != 0 {
+		t.Errorf("reconnected replica lag = %s", lag)
+	}
+}
@@ -0,0 +1,159 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pkg/errors"
+	"math/rand"
+	"service/internal/entities"
+	"time"
+)
+
+// Operations are transactions with configurable isolation level.
+const (
+	OperationCredit    = "credit"
+	OperationReserve   = "reserve"
+	OperationCommit    = "commit"
+	OperationRateLimit = "rate_limit"
+
+	IsolationReadCommitted  = "read_committed"
+	IsolationRepeatableRead = "repeatable_read"
+	IsolationSerializable   = "serializable"
+
+	defaultMaxAttempts = 3
+	defaultBaseDelay   = 10 * time.Millisecond
+	defaultMaxDelay    = 200 * time.Millisecond
+)
+
+func parseIsolation(operations map[string]string) (map[string]pgx.TxIsoLevel, error) {
+	levels := make(map[string]pgx.TxIsoLevel, len(operations))
+
+	for operation, level := range operations {
+		switch operation {
+		case OperationCredit, OperationReserve, OperationCommit, OperationRateLimit:
+		default:
+			return nil, errors.WithMessagef(entities.ErrInvalidParam, "unknown operation %q", operation)
+		}
+
+		switch level {
+		case "", IsolationReadCommitted:
+			levels[operation] = pgx.ReadCommitted
+		case IsolationRepeatableRead:
+			levels[operation] = pgx.RepeatableRead
+		case IsolationSerializable:
+			levels[operation] = pgx.Serializable
+		default:
+			return nil, errors.WithMessagef(entities.ErrInvalidParam, "unknown isolation level %q of %s", level, operation)
+		}
+	}
+
+	return levels, nil
+}
+
+// tx runs fn in transaction of operation's isolation level, repeating it while
+// it fails with serialization failure or deadlock and attempts remain.
+func (s *Storage) tx(ctx context.Context, operation string, fn func(tx pgx.Tx) error) error {
+	log := s.logger(ctx).With("operation", operation)
+
+	var err error
+
+	for attempt := 1; ; attempt++ {
+		err = s.runTx(ctx, operation, fn)
+
+		code := conflictCode(err)
+		if code == "" {
+			if err == nil && attempt > 1 {
+				log.Infof("transaction succeeded after %d retries", attempt-1)
+			}
+
+			return err
+		}
+
+		if attempt >= s.retry.MaxAttempts {
+			s.txConflicts.WithLabelValues(operation, code, "exhausted").Inc()
+			log.Errorf("transaction failed after %d attempts: %s", attempt, err)
+
+			return errors.WithMessage(entities.ErrInternal, err.Error())
+		}
+
+		s.txConflicts.WithLabelValues(operation, code, "retried").Inc()
+
+		delay := s.backoff(attempt)
+		log.Warnf("retrying transaction in %s, attempt %d of %d: %s", delay, attempt, s.retry.MaxAttempts, err)
+
+		select {
+		case <-ctx.Done():
+			return errors.WithMessage(entities.ErrInternal, ctx.Err().Error())
+		case <-time.After(delay):
+		}
+	}
+}
+
+// nolint:errcheck // safety in library
+func (s *Storage) runTx(ctx context.Context, operation string, fn func(tx pgx.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: s.isolation(operation)})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *Storage) isolation(operation string) pgx.TxIsoLevel {
+	if level, ok := s.isolationLevels[operation]; ok {
+		return level
+	}
+
+	return pgx.ReadCommitted
+}
+
+func (s *Storage) backoff(attempt int) time.Duration {
+	delay := s.retry.BaseDelay << (attempt - 1)
+	if delay > s.retry.MaxDelay || delay <= 0 {
+		delay = s.retry.MaxDelay
+	}
+
+	if delay <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// conflictCode returns SQLSTATE of errors worth retrying, empty for others.
+func conflictCode(err error) string {
+	var pge *pgconn.PgError
+	if !errors.As(err, &pge) {
+		return ""
+	}
+
+	switch pge.Code {
+	case pgerrcode.SerializationFailure, pgerrcode.DeadlockDetected:
+		return pge.Code
+	}
+
+	return ""
+}
+
+// internalError hides database error behind ErrInternal, except conflicts
+// which tx has to see to retry and writes of users moved to another shard.
+func internalError(err error) error {
+	if conflictCode(err) != "" {
+		return err
+	}
+
+	var pge *pgconn.PgError
+	if errors.As(err, &pge) && pge.Code == sqlStateUserMoved {
+		return errors.WithMessage(ErrUserMoved, pge.Message)
+	}
+
+	return errors.WithMessage(entities.ErrInternal, fmt.Sprint(err))
+}
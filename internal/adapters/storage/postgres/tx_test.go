@@ -0,0 +1,83 @@
+package postgres
+
+import (
+	"errors"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"service/internal/entities"
+	"testing"
+	"time"
+)
+
+func TestParseIsolation(t *testing.T) {
+	levels, err := parseIsolation(map[string]string{
+		OperationCredit:  IsolationSerializable,
+		OperationReserve: IsolationRepeatableRead,
+		OperationCommit:  "",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := &Storage{isolationLevels: levels}
+
+	for operation, want := range map[string]pgx.TxIsoLevel{
+		OperationCredit:    pgx.Serializable,
+		OperationReserve:   pgx.RepeatableRead,
+		OperationCommit:    pgx.ReadCommitted,
+		OperationRateLimit: pgx.ReadCommitted,
+	} {
+		if got := st.isolation(operation); got != want {
+			t.Errorf("%s: isolation = %s, want %s", operation, got, want)
+		}
+	}
+
+	for _, invalid := range []map[string]string{
+		{OperationCredit: "snapshot"},
+		{"transfer": IsolationSerializable},
+	} {
+		if _, err = parseIsolation(invalid); !errors.Is(err, entities.ErrInvalidParam) {
+			t.Errorf("%v: expected ErrInvalidParam, got %v", invalid, err)
+		}
+	}
+}
+
+func TestConflictCode(t *testing.T) {
+	for err, want := range map[error]string{
+		&pgconn.PgError{Code: pgerrcode.SerializationFailure}: pgerrcode.SerializationFailure,
+		&pgconn.PgError{Code: pgerrcode.DeadlockDetected}:     pgerrcode.DeadlockDetected,
+		&pgconn.PgError{Code: pgerrcode.CheckViolation}:       "",
+		entities.ErrInternal:                                  "",
+	} {
+		if got := conflictCode(err); got != want {
+			t.Errorf("%v: code = %q, want %q", err, got, want)
+		}
+	}
+
+	conflict := &pgconn.PgError{Code: pgerrcode.SerializationFailure}
+	if !errors.Is(internalError(conflict), conflict) {
+		t.Error("conflict must not be hidden from retries")
+	}
+
+	if !errors.Is(internalError(errors.New("connection reset")), entities.ErrInternal) {
+		t.Error("other errors must be ErrInternal")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	st := &Storage{retry: RetryOptions{MaxAttempts: 5, BaseDelay: 10 * time.Millisecond, MaxDelay: 25 * time.Millisecond}}
+
+	for attempt, limit := range map[int]time.Duration{
+		1:  10 * time.Millisecond,
+		2:  20 * time.Millisecond,
+		3:  25 * time.Millisecond,
+		40: 25 * time.Millisecond,
+	} {
+		for i := 0; i < 100; i++ {
+			if delay := st.backoff(attempt); delay < 0 || delay > limit {
+				t.Fatalf("attempt %d: delay %s is out of [0, %s]", attempt, delay, limit)
+			}
+		}
+	}
+}
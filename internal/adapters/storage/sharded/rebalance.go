@@ -0,0 +1,211 @@
+package sharded
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"service/internal/adapters/storage/postgres"
+	"service/internal/entities"
+	"time"
+)
+
+const (
+	defaultRebalanceBatch = 1000
+	// defaultRecoverAge keeps Recover off moves a running mover is about to finish
+	defaultRecoverAge = time.Minute
+)
+
+// ShardStatus counts users of a shard and those of them owned by another
+// shard by the current ring.
+type ShardStatus struct {
+	Shard     int
+	Users     int
+	Misplaced int
+}
+
+// Move moves user between shards in two phases. The source prepares
+// deletion of the user, the target commits the copy together with a record
+// of the move, then the source commits. The record decides the outcome of a
+// move interrupted between phases, see Recover.
+func (s *Storage) Move(ctx context.Context, userID string, from, to int) error {
+	if from == to || from < 0 || to < 0 || from >= len(s.shards) || to >= len(s.shards) {
+		return errors.WithMessagef(entities.ErrInvalidParam, "invalid move from shard %d to %d", from, to)
+	}
+
+	source, target := s.shards[from], s.shards[to]
+	gid := postgres.MoveGIDPrefix + uuid.NewString()
+	log := s.log.With("user_id", userID, "from", from, "to", to, "gid", gid)
+
+	data, err := source.PrepareMoveOut(ctx, userID, gid)
+	if err != nil {
+		return errors.WithMessage(err, "prepare move out")
+	}
+
+	if err = target.MoveIn(ctx, data, gid); err != nil {
+		// commit of the target may have succeeded before the error
+		committed, checkErr := target.MoveCommitted(context.Background(), gid)
+		if checkErr != nil {
+			log.Errorf("move left prepared, run recovery: %s", checkErr)
+			return errors.WithMessage(err, "move in")
+		}
+
+		if !committed {
+			if rollbackErr := source.RollbackPrepared(context.Background(), gid); rollbackErr != nil {
+				log.Errorf("move left prepared, run recovery: %s", rollbackErr)
+			}
+
+			return errors.WithMessage(err, "move in")
+		}
+	}
+
+	if err = source.CommitPrepared(context.Background(), gid); err != nil {
+		log.Errorf("move left prepared, run recovery: %s", err)
+		return errors.WithMessage(err, "commit prepared")
+	}
+
+	log.Infof("moved user with %d operations", data.Operations())
+
+	return nil
+}
+
+// Recover finishes moves left prepared by failed movers: a prepared move is
+// committed when some shard has its record and rolled back otherwise. Moves
+// are left prepared when a shard can not be checked, moves prepared less than
+// a minute ago are left to their mover.
+func (s *Storage) Recover(ctx context.Context) (committed, rolledBack int, err error) {
+	for i, shard := range s.shards {
+		gids, err := shard.PreparedMoves(ctx, s.recoverAge)
+		if err != nil {
+			return committed, rolledBack, errors.WithMessagef(err, "shard %d", i)
+		}
+
+		for _, gid := range gids {
+			done, err := s.moveCommitted(ctx, gid)
+			if err != nil {
+				return committed, rolledBack, err
+			}
+
+			if done {
+				err = shard.CommitPrepared(ctx, gid)
+				committed++
+			} else {
+				err = shard.RollbackPrepared(ctx, gid)
+				rolledBack++
+			}
+
+			if err != nil {
+				return committed, rolledBack, errors.WithMessagef(err, "shard %d: %s", i, gid)
+			}
+
+			s.log.With("shard", i, "gid", gid).Infof("recovered prepared move, committed: %v", done)
+		}
+	}
+
+	return committed, rolledBack, nil
+}
+
+func (s *Storage) moveCommitted(ctx context.Context, gid string) (bool, error) {
+	for i, shard := range s.shards {
+		done, err := shard.MoveCommitted(ctx, gid)
+		if err != nil {
+			return false, errors.WithMessagef(err, "shard %d", i)
+		}
+
+		if done {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Status counts users and misplaced users of every shard.
+func (s *Storage) Status(ctx context.Context) ([]ShardStatus, error) {
+	statuses := make([]ShardStatus, 0, len(s.shards))
+
+	for i := range s.shards {
+		status := ShardStatus{Shard: i}
+
+		err := s.eachUser(ctx, i, defaultRebalanceBatch, func(userID string) error {
+			status.Users++
+			if s.ring.Shard(userID) != i {
+				status.Misplaced++
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// Rebalance recovers interrupted moves and moves every misplaced user to its
+// shard by the current ring, reading batch user ids at a time. Users created
+// on their previous shard during a pass are moved by the next one, so passes
+// are repeated until nothing is moved before PreviousShards is unset.
+func (s *Storage) Rebalance(ctx context.Context, batch int) (int, error) {
+	if batch <= 0 {
+		batch = defaultRebalanceBatch
+	}
+
+	if _, _, err := s.Recover(ctx); err != nil {
+		return 0, err
+	}
+
+	moved := 0
+
+	for i := range s.shards {
+		err := s.eachUser(ctx, i, batch, func(userID string) error {
+			owner := s.ring.Shard(userID)
+			if owner == i {
+				return nil
+			}
+
+			err := s.Move(ctx, userID, i, owner)
+			if errors.Is(err, entities.ErrNotFound) {
+				// deleted or moved since listed
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			moved++
+
+			return nil
+		})
+		if err != nil {
+			return moved, err
+		}
+	}
+
+	return moved, nil
+}
+
+func (s *Storage) eachUser(ctx context.Context, shard, batch int, fn func(userID string) error) error {
+	after := ""
+
+	for {
+		userIDs, err := s.shards[shard].UserIDs(ctx, after, batch)
+		if err != nil {
+			return errors.WithMessagef(err, "shard %d", shard)
+		}
+
+		for _, userID := range userIDs {
+			if err = fn(userID); err != nil {
+				return err
+			}
+		}
+
+		if len(userIDs) < batch {
+			return nil
+		}
+
+		after = userIDs[len(userIDs)-1]
+	}
+}
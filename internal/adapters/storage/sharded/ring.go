@@ -0,0 +1,73 @@
+package sharded
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// Ring is a consistent hash ring of shards. Each shard owns virtual nodes
+// named by its position, so appending a shard moves about 1/n of users and
+// only to the new shard.
+type Ring struct {
+	hashes []uint64
+	shards []int
+}
+
+func NewRing(shards, virtualNodes int) *Ring {
+	r := &Ring{
+		hashes: make([]uint64, 0, shards*virtualNodes),
+		shards: make([]int, 0, shards*virtualNodes),
+	}
+
+	type node struct {
+		hash  uint64
+		shard int
+	}
+
+	nodes := make([]node, 0, shards*virtualNodes)
+
+	for shard := 0; shard < shards; shard++ {
+		for i := 0; i < virtualNodes; i++ {
+			nodes = append(nodes, node{hash: hash("shard-" + strconv.Itoa(shard) + "-" + strconv.Itoa(i)), shard: shard})
+		}
+	}
+
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].hash < nodes[j].hash })
+
+	for _, n := range nodes {
+		r.hashes = append(r.hashes, n.hash)
+		r.shards = append(r.shards, n.shard)
+	}
+
+	return r
+}
+
+// Shard returns position of the shard owning userID: the first virtual node
+// clockwise from the user's hash.
+func (r *Ring) Shard(userID string) int {
+	h := hash(userID)
+
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+
+	return r.shards[i]
+}
+
+// hash is FNV-1a finished with splitmix64, as FNV alone spreads ids that
+// differ in the last characters poorly.
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+
+	return x
+}
@@ -0,0 +1,40 @@
+package sharded
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRing_Shard(t *testing.T) {
+	const users = 30000
+
+	ring := NewRing(3, defaultVirtualNodes)
+	grown := NewRing(4, defaultVirtualNodes)
+
+	counts := make([]int, 3)
+	moved := 0
+
+	for i := 0; i < users; i++ {
+		userID := "user-" + strconv.Itoa(i)
+
+		shard := ring.Shard(userID)
+		counts[shard]++
+
+		if next := grown.Shard(userID); next != shard {
+			if next != 3 {
+				t.Fatalf("%s moved from shard %d to existing shard %d", userID, shard, next)
+			}
+			moved++
+		}
+	}
+
+	for shard, count := range counts {
+		if count < users/3*8/10 || count > users/3*12/10 {
+			t.Errorf("shard %d owns %d of %d users", shard, count, users)
+		}
+	}
+
+	if moved < users/4*7/10 || moved > users/4*13/10 {
+		t.Errorf("%d of %d users moved to appended shard", moved, users)
+	}
+}
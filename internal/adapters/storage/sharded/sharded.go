@@ -0,0 +1,318 @@
+// Package sharded spreads users over several postgres storages by
+// consistent hash of user id.
+package sharded
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"service/internal/adapters/storage/postgres"
+	"service/internal/cases"
+	"service/internal/entities"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	_ cases.Storage          = (*Storage)(nil)
+	_ cases.Notifier         = (*Storage)(nil)
+	_ cases.KeyStorage       = (*Storage)(nil)
+	_ cases.OperationArchive = (*Storage)(nil)
+)
+
+const defaultVirtualNodes = 128
+
+type Options struct {
+	// PreviousShards is the number of shards before the last ones were
+	// appended, zero when users are not being rebalanced. While it is set
+	// users are looked up on their shard of the previous ring first and on
+	// the current one when they are not there or have been moved.
+	PreviousShards int
+	// VirtualNodes per shard on the ring, must not change once users are placed.
+	VirtualNodes int
+}
+
+// Storage routes calls of a user to the shard owning the user. API keys and
+// rate limits are not per user and are kept on the first shard.
+type Storage struct {
+	log      *zap.SugaredLogger
+	shards   []*postgres.Storage
+	ring     *Ring
+	previous *Ring
+	// recoverAge is the age of prepared moves Recover finishes
+	recoverAge time.Duration
+}
+
+func NewStorage(log *zap.SugaredLogger, shards []*postgres.Storage, opts Options) (*Storage, error) {
+	if log == nil {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty logger")
+	}
+
+	if len(shards) == 0 {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty shards")
+	}
+
+	if opts.PreviousShards < 0 || opts.PreviousShards >= len(shards) {
+		return nil, errors.WithMessagef(entities.ErrInvalidParam,
+			"previous shards %d must be in 0..%d", opts.PreviousShards, len(shards)-1)
+	}
+
+	if opts.VirtualNodes <= 0 {
+		opts.VirtualNodes = defaultVirtualNodes
+	}
+
+	st := &Storage{
+		log:        log,
+		shards:     shards,
+		ring:       NewRing(len(shards), opts.VirtualNodes),
+		recoverAge: defaultRecoverAge,
+	}
+
+	if opts.PreviousShards > 0 {
+		st.previous = NewRing(opts.PreviousShards, opts.VirtualNodes)
+	}
+
+	return st, nil
+}
+
+// owners returns user's shard and, while rebalancing, user's shard of the
+// previous ring if it differs.
+func (s *Storage) owners(userID string) (owner int, previous int, moving bool) {
+	owner = s.ring.Shard(userID)
+
+	if s.previous == nil {
+		return owner, owner, false
+	}
+
+	previous = s.previous.Shard(userID)
+
+	return owner, previous, previous != owner
+}
+
+// route runs fn on user's shard. While rebalancing fn runs on the shard of
+// the previous ring first and is repeated on the current one when the user
+// is not found there or has been moved.
+func (s *Storage) route(userID string, fn func(shard *postgres.Storage) error) error {
+	owner, previous, moving := s.owners(userID)
+	if !moving {
+		return fn(s.shards[owner])
+	}
+
+	err := fn(s.shards[previous])
+	if !errors.Is(err, entities.ErrNotFound) && !errors.Is(err, postgres.ErrUserMoved) {
+		return err
+	}
+
+	return fn(s.shards[owner])
+}
+
+// locate returns shard holding user's balance, for reads which do not fail
+// when the user is missing.
+func (s *Storage) locate(ctx context.Context, userID string) (*postgres.Storage, error) {
+	owner, previous, moving := s.owners(userID)
+	if !moving {
+		return s.shards[owner], nil
+	}
+
+	_, err := s.shards[previous].GetBalance(ctx, userID)
+	if errors.Is(err, entities.ErrNotFound) {
+		return s.shards[owner], nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.shards[previous], nil
+}
+
+func (s *Storage) CreateOrUpdateBalance(ctx context.Context, operation *entities.Operation) error {
+	return s.route(operation.UserID(), func(shard *postgres.Storage) error {
+		return shard.CreateOrUpdateBalance(ctx, operation)
+	})
+}
+
+func (s *Storage) GetBalance(ctx context.Context, userID string) (*entities.Balance, error) {
+	var balance *entities.Balance
+
+	err := s.route(userID, func(shard *postgres.Storage) (err error) {
+		balance, err = shard.GetBalance(ctx, userID)
+		return err
+	})
+
+	return balance, err
+}
+
+func (s *Storage) CreateOperation(ctx context.Context, operation *entities.Operation) error {
+	return s.route(operation.UserID(), func(shard *postgres.Storage) error {
+		return shard.CreateOperation(ctx, operation)
+	})
+}
+
+func (s *Storage) GetOperation(
+	ctx context.Context,
+	userID string,
+	orderID string,
+	serviceID string,
+) (*entities.Operation, error) {
+	var operation *entities.Operation
+
+	err := s.route(userID, func(shard *postgres.Storage) (err error) {
+		operation, err = shard.GetOperation(ctx, userID, orderID, serviceID)
+		return err
+	})
+
+	return operation, err
+}
+
+func (s *Storage) UpdateOperationReserve(ctx context.Context, operation *entities.Operation) error {
+	return s.route(operation.UserID(), func(shard *postgres.Storage) error {
+		return shard.UpdateOperationReserve(ctx, operation)
+	})
+}
+
+func (s *Storage) ListOperations(
+	ctx context.Context,
+	userID string,
+	limit, offset int,
+	sortBy string, desc bool,
+) ([]*entities.Operation, error) {
+	shard, err := s.locate(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return shard.ListOperations(ctx, userID, limit, offset, sortBy, desc)
+}
+
+func (s *Storage) ListOperationsInRange(
+	ctx context.Context,
+	userID string,
+	from, to time.Time,
+	limit, offset int,
+	sortBy string, desc bool,
+) ([]*entities.Operation, error) {
+	shard, err := s.locate(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return shard.ListOperationsInRange(ctx, userID, from, to, limit, offset, sortBy, desc)
+}
+
+// Subscribe listens on user's shard, and while rebalancing on the shard of
+// the previous ring too, as the user may be moved during subscription.
+func (s *Storage) Subscribe(ctx context.Context, userID string) (<-chan *entities.Operation, error) {
+	owner, previous, moving := s.owners(userID)
+	if !moving {
+		return s.shards[owner].Subscribe(ctx, userID)
+	}
+
+	fromPrevious, err := s.shards[previous].Subscribe(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	fromOwner, err := s.shards[owner].Subscribe(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return merge(ctx, fromPrevious, fromOwner), nil
+}
+
+// WatchChanges streams changes of every shard.
+func (s *Storage) WatchChanges(ctx context.Context) (<-chan string, error) {
+	channels := make([]<-chan string, 0, len(s.shards))
+
+	for _, shard := range s.shards {
+		ch, err := shard.WatchChanges(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		channels = append(channels, ch)
+	}
+
+	return merge(ctx, channels...), nil
+}
+
+// merge forwards values of channels until all of them are closed or ctx is done.
+func merge[T any](ctx context.Context, channels ...<-chan T) <-chan T {
+	out := make(chan T)
+
+	var wg sync.WaitGroup
+
+	for _, ch := range channels {
+		wg.Add(1)
+
+		go func(ch <-chan T) {
+			defer wg.Done()
+
+			for value := range ch {
+				select {
+				case out <- value:
+				case <-ctx.Done():
+				}
+			}
+		}(ch)
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+func (s *Storage) CreateAPIKey(ctx context.Context, key *entities.APIKey, hash string) error {
+	return s.shards[0].CreateAPIKey(ctx, key, hash)
+}
+
+func (s *Storage) GetAPIKeyByHash(ctx context.Context, hash string) (*entities.APIKey, error) {
+	return s.shards[0].GetAPIKeyByHash(ctx, hash)
+}
+
+func (s *Storage) ListAPIKeys(ctx context.Context) ([]*entities.APIKey, error) {
+	return s.shards[0].ListAPIKeys(ctx)
+}
+
+func (s *Storage) DeleteAPIKey(ctx context.Context, id string) error {
+	return s.shards[0].DeleteAPIKey(ctx, id)
+}
+
+// Take keeps rate limit buckets on the first shard.
+func (s *Storage) Take(ctx context.Context, key string, rate float64, burst int) (time.Duration, error) {
+	return s.shards[0].Take(ctx, key, rate, burst)
+}
+
+// Ready checks every shard.
+func (s *Storage) Ready(ctx context.Context) error {
+	for i, shard := range s.shards {
+		if err := shard.Ready(ctx); err != nil {
+			return errors.WithMessagef(err, "shard %d", i)
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) Close() {
+	for _, shard := range s.shards {
+		shard.Close()
+	}
+}
+
+// Register registers metrics of every shard labeled by shard position.
+func (s *Storage) Register(reg prometheus.Registerer) error {
+	for i, shard := range s.shards {
+		labeled := prometheus.WrapRegistererWith(prometheus.Labels{"shard": strconv.Itoa(i)}, reg)
+		if err := labeled.Register(shard.Collector()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,339 @@
+package sharded
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"os"
+	"service/internal/adapters/storage/postgres"
+	"service/internal/adapters/storage/storagetest"
+	"service/internal/cases"
+	"service/internal/entities"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testShardsEnv names comma separated databases the suites run against as
+// shards, at least two, with max_prepared_transactions > 0. The suites are
+// skipped when it is not set. Rebalance tests move users of other tests.
+const testShardsEnv = "BALANCE_TEST_POSTGRES_SHARDS"
+
+const testServiceID = "shardedtest"
+
+func newShards(t *testing.T) []*postgres.Storage {
+	t.Helper()
+
+	dsns := os.Getenv(testShardsEnv)
+	if dsns == "" {
+		t.Skipf("%s is not set", testShardsEnv)
+	}
+
+	ctx := context.Background()
+	log := zap.NewNop().Sugar()
+
+	var shards []*postgres.Storage
+
+	for _, dsn := range strings.Split(dsns, ",") {
+		migrator, err := postgres.NewMigrator(ctx, log, dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = migrator.Up(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = migrator.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		shard, err := postgres.NewStorage(log, dsn, postgres.DefaultOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(shard.Close)
+
+		shards = append(shards, shard)
+	}
+
+	if len(shards) < 2 {
+		t.Skipf("%s names less than two shards", testShardsEnv)
+	}
+
+	return shards
+}
+
+func newStorage(t *testing.T, shards []*postgres.Storage, opts Options) *Storage {
+	t.Helper()
+
+	st, err := NewStorage(zap.NewNop().Sugar(), shards, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return st
+}
+
+// userOf returns a new user id owned by shard by ring.
+func userOf(ring *Ring, shard int) string {
+	for {
+		if userID := uuid.NewString(); ring.Shard(userID) == shard {
+			return userID
+		}
+	}
+}
+
+func credit(ctx context.Context, st cases.Storage, userID string, value entities.Currency) error {
+	return st.CreateOrUpdateBalance(ctx,
+		entities.NewOperation(userID, testServiceID, uuid.NewString(), entities.Credit, value, time.Now()))
+}
+
+func mustCredit(t *testing.T, st cases.Storage, userID string, value entities.Currency) {
+	t.Helper()
+
+	if err := credit(context.Background(), st, userID, value); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func wantBalance(t *testing.T, st cases.Storage, userID string, want entities.Currency) {
+	t.Helper()
+
+	balance, err := st.GetBalance(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("balance of %s: %s", userID, err)
+	}
+
+	if balance.Value() != want {
+		t.Errorf("balance of %s = %d, want %d", userID, balance.Value(), want)
+	}
+}
+
+func wantMissing(t *testing.T, st cases.Storage, userID string) {
+	t.Helper()
+
+	if _, err := st.GetBalance(context.Background(), userID); !errors.Is(err, entities.ErrNotFound) {
+		t.Errorf("balance of %s: %v, want not found", userID, err)
+	}
+}
+
+func TestStorage(t *testing.T) {
+	st := newStorage(t, newShards(t), Options{})
+
+	storagetest.Run(t, func(t *testing.T) cases.Storage {
+		return st
+	})
+}
+
+func TestStorage_MoveInterruptedBeforeTargetCommit(t *testing.T) {
+	ctx := context.Background()
+	shards := newShards(t)
+	st := newStorage(t, shards, Options{})
+	st.recoverAge = 0
+
+	userID := userOf(st.ring, 0)
+	mustCredit(t, st, userID, 100)
+
+	gid := postgres.MoveGIDPrefix + uuid.NewString()
+	if _, err := shards[0].PrepareMoveOut(ctx, userID, gid); err != nil {
+		t.Fatal(err)
+	}
+
+	// the mover fails before MoveIn, the target has no record of the move
+	committed, rolledBack, err := st.Recover(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if committed != 0 || rolledBack < 1 {
+		t.Errorf("committed %d, rolled back %d, want the move rolled back", committed, rolledBack)
+	}
+
+	wantBalance(t, shards[0], userID, 100)
+	wantMissing(t, shards[1], userID)
+
+	// the user is not marked moved after rollback
+	mustCredit(t, shards[0], userID, 50)
+	wantBalance(t, st, userID, 150)
+}
+
+func TestStorage_MoveInterruptedAfterTargetCommit(t *testing.T) {
+	ctx := context.Background()
+	shards := newShards(t)
+	st := newStorage(t, shards, Options{})
+	st.recoverAge = 0
+
+	userID := userOf(st.ring, 0)
+	mustCredit(t, st, userID, 100)
+	mustCredit(t, st, userID, 20)
+
+	gid := postgres.MoveGIDPrefix + uuid.NewString()
+
+	data, err := shards[0].PrepareMoveOut(ctx, userID, gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data.Operations() != 2 {
+		t.Errorf("moved operations = %d, want 2", data.Operations())
+	}
+
+	// the mover fails after MoveIn, before committing the source
+	if err = shards[1].MoveIn(ctx, data, gid); err != nil {
+		t.Fatal(err)
+	}
+
+	committed, _, err := st.Recover(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if committed < 1 {
+		t.Errorf("committed %d, want the move committed", committed)
+	}
+
+	wantMissing(t, shards[0], userID)
+	wantBalance(t, shards[1], userID, 120)
+
+	operations, err := shards[1].ListOperations(ctx, userID, 10, 0, entities.Date, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(operations) != 2 {
+		t.Errorf("operations on target = %d, want 2", len(operations))
+	}
+
+	if err = credit(ctx, shards[0], userID, 10); !errors.Is(err, postgres.ErrUserMoved) {
+		t.Errorf("credit on source: %v, want user moved", err)
+	}
+}
+
+func TestStorage_UserMovedFallback(t *testing.T) {
+	ctx := context.Background()
+	shards := newShards(t)
+	// the previous ring of one shard places every user on shard 0
+	st := newStorage(t, shards, Options{PreviousShards: 1})
+
+	userID := userOf(st.ring, 1)
+	mustCredit(t, st, userID, 100)
+
+	wantBalance(t, shards[0], userID, 100)
+
+	if err := st.Move(ctx, userID, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// shard 0 rejects the moved user, the write goes on to shard 1
+	mustCredit(t, st, userID, 50)
+
+	wantMissing(t, shards[0], userID)
+	wantBalance(t, shards[1], userID, 150)
+	wantBalance(t, st, userID, 150)
+
+	if _, err := st.GetOperation(ctx, userID, uuid.NewString(), testServiceID); !errors.Is(err, entities.ErrNotFound) {
+		t.Errorf("missing operation: %v, want not found", err)
+	}
+}
+
+func TestStorage_WritesDuringRebalance(t *testing.T) {
+	const (
+		users   = 20
+		credits = 5
+	)
+
+	ctx := context.Background()
+	shards := newShards(t)
+	st := newStorage(t, shards, Options{PreviousShards: 1})
+
+	userIDs := make([]string, users)
+	for i := range userIDs {
+		userIDs[i] = userOf(st.ring, 1)
+		mustCredit(t, st, userIDs[i], 10)
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events, err := st.Subscribe(subCtx, userIDs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, users*credits)
+
+	for _, userID := range userIDs {
+		wg.Add(1)
+
+		go func(userID string) {
+			defer wg.Done()
+
+			for i := 0; i < credits; i++ {
+				if err := credit(ctx, st, userID, 1); err != nil {
+					errs <- err
+				}
+			}
+		}(userID)
+	}
+
+	written := make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(written)
+	}()
+
+	rebalance := func() int {
+		moved, err := st.Rebalance(ctx, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return moved
+	}
+
+	// users credited on shard 0 during a pass are moved by the next one
+	for writing := true; writing; {
+		select {
+		case <-written:
+			writing = false
+		default:
+			rebalance()
+		}
+	}
+
+	for rebalance() != 0 {
+	}
+
+	close(errs)
+	for err := range errs {
+		t.Errorf("credit during rebalance: %s", err)
+	}
+
+	for _, userID := range userIDs {
+		wantMissing(t, shards[0], userID)
+		wantBalance(t, shards[1], userID, 10+credits)
+		wantBalance(t, st, userID, 10+credits)
+	}
+
+	// the subscription sees operations made before and after the user moved
+	received := 0
+	timeout := time.After(5 * time.Second)
+
+	for received < credits {
+		select {
+		case operation := <-events:
+			if operation.UserID() != userIDs[0] {
+				t.Errorf("event of %s, want %s", operation.UserID(), userIDs[0])
+			}
+			received++
+		case <-timeout:
+			t.Fatalf("received %d of %d events", received, credits)
+		}
+	}
+}
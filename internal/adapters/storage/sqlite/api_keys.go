@@ -0,0 +1,115 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"github.com/pkg/errors"
+	"service/internal/entities"
+	"strings"
+	"time"
+)
+
+func (s *Storage) CreateAPIKey(ctx context.Context, key *entities.APIKey, hash string) error {
+	query := `INSERT INTO api_keys (id, service_id, key_hash, permissions, created_at)
+		VALUES (?, ?, ?, ?, ?)`
+
+	permissions := make([]string, 0, len(key.Permissions()))
+	for _, p := range key.Permissions() {
+		permissions = append(permissions, string(p))
+	}
+
+	_, err := s.db.ExecContext(ctx, query,
+		key.ID(), key.ServiceID(), hash, strings.Join(permissions, ","), key.CreatedAt().UnixNano())
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return errors.WithMessage(entities.ErrInternal, err.Error())
+	}
+
+	return nil
+}
+
+func (s *Storage) GetAPIKeyByHash(ctx context.Context, hash string) (*entities.APIKey, error) {
+	query := `SELECT id, service_id, permissions, created_at
+		FROM api_keys
+		WHERE key_hash = ?`
+
+	key, err := scanAPIKey(s.db.QueryRowContext(ctx, query, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.WithMessage(entities.ErrNotFound, "api key not found")
+	}
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return nil, errors.WithMessage(entities.ErrInternal, err.Error())
+	}
+
+	return key, nil
+}
+
+func (s *Storage) ListAPIKeys(ctx context.Context) ([]*entities.APIKey, error) {
+	query := `SELECT id, service_id, permissions, created_at
+		FROM api_keys
+		ORDER BY service_id, created_at`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		err = errors.WithMessage(entities.ErrInternal, err.Error())
+		s.logger(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]*entities.APIKey, 0)
+
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			err = errors.WithMessage(entities.ErrInternal, err.Error())
+			s.logger(ctx).Error(err)
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (s *Storage) DeleteAPIKey(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM api_keys WHERE id = ?`, id)
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return errors.WithMessage(entities.ErrInternal, err.Error())
+	}
+
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return errors.WithMessage(entities.ErrNotFound, "api key not found")
+	}
+
+	return nil
+}
+
+type row interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIKey(row row) (*entities.APIKey, error) {
+	var (
+		id          string
+		serviceID   string
+		permissions string
+		createdAt   int64
+	)
+
+	if err := row.Scan(&id, &serviceID, &permissions, &createdAt); err != nil {
+		return nil, err
+	}
+
+	perms := make([]entities.Permission, 0)
+	for _, p := range strings.Split(permissions, ",") {
+		if p != "" {
+			perms = append(perms, entities.Permission(p))
+		}
+	}
+
+	return entities.NewAPIKey(id, serviceID, perms, time.Unix(0, createdAt).UTC()), nil
+}
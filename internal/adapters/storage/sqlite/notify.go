@@ -0,0 +1,23 @@
+package sqlite
+
+import (
+	"context"
+	"service/internal/entities"
+	"time"
+)
+
+func (s *Storage) Subscribe(ctx context.Context, userID string) (<-chan *entities.Operation, error) {
+	return s.subscribers.Subscribe(ctx, userID)
+}
+
+// notify publishes committed operation to subscribers of this process.
+func (s *Storage) notify(operation *entities.Operation) {
+	s.subscribers.Publish(entities.NewOperation(
+		operation.UserID(),
+		operation.ServiceID(),
+		operation.OrderID(),
+		operation.OperationType(),
+		operation.Value(),
+		time.Now().UTC(),
+	))
+}
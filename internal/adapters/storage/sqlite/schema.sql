@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS balances
+(
+    user_id    TEXT PRIMARY KEY,
+    value      INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    CONSTRAINT balances_value_positive CHECK (value >= 0)
+);
+
+CREATE TABLE IF NOT EXISTS operations
+(
+    user_id        TEXT    NOT NULL,
+    service_id     TEXT    NOT NULL,
+    order_id       TEXT    NOT NULL,
+    operation_type INTEGER NOT NULL,
+    value          INTEGER NOT NULL,
+    reserve        INTEGER NOT NULL DEFAULT 0,
+    created_at     INTEGER NOT NULL,
+    updated_at     INTEGER NOT NULL,
+    CONSTRAINT operations_value_positive CHECK (value >= 0),
+    CONSTRAINT operations_reserve_positive CHECK (reserve >= 0),
+    PRIMARY KEY (user_id, service_id, order_id)
+);
+
+CREATE INDEX IF NOT EXISTS operations_user_created_at ON operations (user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id          TEXT PRIMARY KEY,
+    service_id  TEXT    NOT NULL,
+    key_hash    TEXT    NOT NULL UNIQUE,
+    permissions TEXT    NOT NULL,
+    created_at  INTEGER NOT NULL
+);
@@ -0,0 +1,397 @@
+// Package sqlite is a storage for single-node deployments in a SQLite file,
+// built on a pure-Go driver.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"fmt"
+	"github.com/glebarez/go-sqlite"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"service/internal/adapters/storage/subscribers"
+	"service/internal/cases"
+	"service/internal/entities"
+	"service/internal/logging"
+	"time"
+)
+
+var (
+	_ cases.Storage    = (*Storage)(nil)
+	_ cases.Notifier   = (*Storage)(nil)
+	_ cases.KeyStorage = (*Storage)(nil)
+)
+
+// extended result codes of constraint violations
+const (
+	constraintCheck      = 275
+	constraintPrimaryKey = 1555
+
+	busyTimeout = 5 * time.Second
+)
+
+//go:embed schema.sql
+var schema string
+
+type db interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type Storage struct {
+	log         *zap.SugaredLogger
+	db          *sql.DB
+	subscribers *subscribers.Subscribers
+}
+
+// NewStorage opens or creates database file at path and creates schema.
+// Reads and writes are serialized through a single connection, as SQLite
+// allows one writer, the storage is meant for single-node deployments with
+// low load.
+func NewStorage(log *zap.SugaredLogger, path string) (*Storage, error) {
+	if log == nil {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty logger")
+	}
+
+	if path == "" {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty path")
+	}
+
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)",
+		path, busyTimeout.Milliseconds())
+
+	conn, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, err.Error())
+	}
+
+	conn.SetMaxOpenConns(1)
+
+	if _, err = conn.Exec(schema); err != nil {
+		_ = conn.Close()
+		return nil, errors.WithMessage(err, "create schema")
+	}
+
+	return &Storage{
+		log:         log,
+		db:          conn,
+		subscribers: subscribers.New(),
+	}, nil
+}
+
+func (s *Storage) CreateOrUpdateBalance(ctx context.Context, operation *entities.Operation) error {
+	if err := s.tx(ctx, func(tx *sql.Tx) error {
+		err := s.createOrUpdateBalance(ctx, tx, operation.UserID(), operation.Value())
+		if err != nil {
+			return err
+		}
+
+		return s.createOperation(ctx, tx, operation, 0)
+	}); err != nil {
+		return err
+	}
+
+	s.notify(operation)
+
+	return nil
+}
+
+func (s *Storage) GetBalance(ctx context.Context, userID string) (*entities.Balance, error) {
+	var value int
+
+	err := s.db.QueryRowContext(ctx, `SELECT value FROM balances WHERE user_id = ?`, userID).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = errors.WithMessage(entities.ErrNotFound, "balance not found")
+		s.logger(ctx).Error(err)
+		return nil, err
+	}
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return nil, errors.WithMessage(entities.ErrInternal, err.Error())
+	}
+
+	return entities.NewBalance(userID, entities.Currency(value)), nil
+}
+
+func (s *Storage) CreateOperation(ctx context.Context, operation *entities.Operation) error {
+	if err := s.tx(ctx, func(tx *sql.Tx) error {
+		err := s.decreaseBalance(ctx, tx, operation.UserID(), operation.Value())
+		if err != nil {
+			return err
+		}
+
+		return s.createOperation(ctx, tx, operation, operation.Value())
+	}); err != nil {
+		return err
+	}
+
+	s.notify(operation)
+
+	return nil
+}
+
+func (s *Storage) GetOperation(
+	ctx context.Context,
+	userID string,
+	orderID string,
+	serviceID string,
+) (*entities.Operation, error) {
+	query := `SELECT operation_type, value, created_at
+		FROM operations
+		WHERE order_id = ? AND service_id = ? AND user_id = ?`
+
+	var (
+		operationType int
+		value         int
+		createdAt     int64
+	)
+
+	err := s.db.QueryRowContext(ctx, query, orderID, serviceID, userID).Scan(&operationType, &value, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = errors.WithMessage(entities.ErrNotFound, "operation not found")
+		s.logger(ctx).Error(err)
+		return nil, err
+	}
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return nil, errors.WithMessage(entities.ErrInternal, err.Error())
+	}
+
+	return entities.NewOperation(
+		userID,
+		serviceID,
+		orderID,
+		entities.OperationType(operationType),
+		entities.Currency(value),
+		time.Unix(0, createdAt).UTC(),
+	), nil
+}
+
+func (s *Storage) UpdateOperationReserve(ctx context.Context, operation *entities.Operation) error {
+	query := `UPDATE operations
+		SET reserve = reserve - ?, updated_at = ?
+		WHERE order_id = ? AND service_id = ? AND user_id = ?`
+
+	res, err := s.db.ExecContext(ctx, query,
+		operation.Value(),
+		time.Now().UnixNano(),
+		operation.OrderID(),
+		operation.ServiceID(),
+		operation.UserID(),
+	)
+	if isConstraint(err, constraintCheck) {
+		s.logger(ctx).Error(err)
+		return entities.ErrCommitInvalidValue
+	}
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return errors.WithMessage(entities.ErrInternal, err.Error())
+	}
+
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		err = errors.WithMessage(entities.ErrNotFound, "operation not found")
+		s.logger(ctx).Error(err)
+		return err
+	}
+
+	s.notify(operation)
+
+	return nil
+}
+
+func (s *Storage) ListOperations(
+	ctx context.Context,
+	userID string,
+	limit, offset int,
+	sortBy string, desc bool,
+) ([]*entities.Operation, error) {
+	orderBy := "created_at"
+	if sortBy == entities.Value {
+		orderBy = "value"
+	}
+
+	direction := "ASC"
+	if desc {
+		direction = "DESC"
+	}
+
+	// LIMIT -1 is unlimited in SQLite
+	if limit == 0 {
+		limit = -1
+	}
+
+	query := fmt.Sprintf(`SELECT service_id, order_id, operation_type, value, created_at
+		FROM operations
+		WHERE user_id = ?
+		ORDER BY %[1]s %[2]s, rowid %[2]s
+		LIMIT ? OFFSET ?`, orderBy, direction)
+
+	rows, err := s.db.QueryContext(ctx, query, userID, limit, offset)
+	if err != nil {
+		err = errors.WithMessage(entities.ErrInternal, err.Error())
+		s.logger(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	operations := make([]*entities.Operation, 0)
+
+	for rows.Next() {
+		var (
+			serviceID     string
+			orderID       string
+			operationType int
+			value         int
+			createdAt     int64
+		)
+
+		if err = rows.Scan(&serviceID, &orderID, &operationType, &value, &createdAt); err != nil {
+			err = errors.WithMessage(entities.ErrInternal, err.Error())
+			s.logger(ctx).Error(err)
+			return nil, err
+		}
+
+		operations = append(operations, entities.NewOperation(
+			userID,
+			serviceID,
+			orderID,
+			entities.OperationType(operationType),
+			entities.Currency(value),
+			time.Unix(0, createdAt).UTC(),
+		))
+	}
+
+	if err = rows.Err(); err != nil {
+		err = errors.WithMessage(entities.ErrInternal, err.Error())
+		s.logger(ctx).Error(err)
+		return nil, err
+	}
+
+	return operations, nil
+}
+
+// Ready checks that database file is readable.
+func (s *Storage) Ready(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return errors.WithMessage(err, "ping database")
+	}
+
+	return nil
+}
+
+func (s *Storage) Close() {
+	if err := s.db.Close(); err != nil {
+		s.log.Error(err)
+	}
+}
+
+func (s *Storage) createOrUpdateBalance(ctx context.Context, db db, userID string, value entities.Currency) error {
+	query := `INSERT INTO balances (user_id, value, created_at, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			value      = balances.value + excluded.value,
+			updated_at = excluded.updated_at`
+
+	now := time.Now().UnixNano()
+
+	_, err := db.ExecContext(ctx, query, userID, value, now, now)
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return errors.WithMessage(entities.ErrInternal, err.Error())
+	}
+
+	return nil
+}
+
+func (s *Storage) decreaseBalance(ctx context.Context, db db, userID string, value entities.Currency) error {
+	query := `UPDATE balances
+		SET value = value - ?, updated_at = ?
+		WHERE user_id = ?`
+
+	res, err := db.ExecContext(ctx, query, value, time.Now().UnixNano(), userID)
+	if isConstraint(err, constraintCheck) {
+		s.logger(ctx).Error(err)
+		return entities.ErrReserveInvalidValue
+	}
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return errors.WithMessage(entities.ErrInternal, err.Error())
+	}
+
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		err = errors.WithMessage(entities.ErrNotFound, "balance not found")
+		s.logger(ctx).Error(err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *Storage) createOperation(
+	ctx context.Context,
+	db db,
+	operation *entities.Operation,
+	reserve entities.Currency,
+) error {
+	query := `INSERT INTO operations
+		(user_id, service_id, order_id, operation_type, value, reserve, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	now := time.Now().UnixNano()
+
+	_, err := db.ExecContext(ctx, query,
+		operation.UserID(),
+		operation.ServiceID(),
+		operation.OrderID(),
+		operation.OperationType(),
+		operation.Value(),
+		reserve,
+		now,
+		now,
+	)
+	if isConstraint(err, constraintPrimaryKey) {
+		s.logger(ctx).Error(err)
+		return entities.ErrReserveAlreadyExists
+	}
+	if isConstraint(err, constraintCheck) {
+		s.logger(ctx).Error(err)
+		return entities.ErrReserveInvalidValue
+	}
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return errors.WithMessage(entities.ErrInternal, err.Error())
+	}
+
+	return nil
+}
+
+// nolint:errcheck // safety in library
+func (s *Storage) tx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithMessage(entities.ErrInternal, err.Error())
+	}
+	defer tx.Rollback()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.WithMessage(entities.ErrInternal, err.Error())
+	}
+
+	return nil
+}
+
+func (s *Storage) logger(ctx context.Context) *zap.SugaredLogger {
+	return logging.FromContext(ctx, s.log)
+}
+
+func isConstraint(err error, code int) bool {
+	var sqliteErr *sqlite.Error
+
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == code
+}
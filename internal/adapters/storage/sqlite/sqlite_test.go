@@ -0,0 +1,45 @@
+package sqlite
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"path/filepath"
+	"service/internal/adapters/storage/storagetest"
+	"service/internal/cases"
+	"service/internal/entities"
+	"testing"
+	"time"
+)
+
+func newStorage(t *testing.T) *Storage {
+	t.Helper()
+
+	st, err := NewStorage(zap.NewNop().Sugar(), filepath.Join(t.TempDir(), "balance.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(st.Close)
+
+	return st
+}
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) cases.Storage {
+		return newStorage(t)
+	})
+}
+
+func TestStorage_TxErrorsAreInternal(t *testing.T) {
+	st := newStorage(t)
+
+	// the transaction can not begin in a done context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := st.CreateOrUpdateBalance(ctx,
+		entities.NewOperation("user", entities.DefaultCreditServiceID, "order", entities.Credit, 10, time.Now()))
+	if !errors.Is(err, entities.ErrInternal) {
+		t.Fatalf("credit in done context: %v, want internal error", err)
+	}
+}
@@ -0,0 +1,314 @@
+// Package storagetest is a contract test suite for cases.Storage implementations.
+//
+// Every test works with its own random user ids, so the suite can run against
+// a shared database without cleanup.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"service/internal/cases"
+	"service/internal/entities"
+	"sync"
+	"testing"
+	"time"
+)
+
+const serviceID = "storagetest"
+
+// Run runs the suite, newStorage is called once per test.
+func Run(t *testing.T, newStorage func(t *testing.T) cases.Storage) {
+	for _, tc := range []struct {
+		name string
+		test func(t *testing.T, st cases.Storage)
+	}{
+		{"ConcurrentCredits", testConcurrentCredits},
+		{"DuplicateCredit", testDuplicateCredit},
+		{"InsufficientFunds", testInsufficientFunds},
+		{"DuplicateReserve", testDuplicateReserve},
+		{"PartialCommit", testPartialCommit},
+		{"OverCommit", testOverCommit},
+		{"ListOrdering", testListOrdering},
+		{"ListPagination", testListPagination},
+	} {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			tc.test(t, newStorage(t))
+		})
+	}
+}
+
+func newUserID() string {
+	return "storagetest-" + uuid.New().String()
+}
+
+func credit(userID, orderID string, value entities.Currency) *entities.Operation {
+	return entities.NewOperation(userID, entities.DefaultCreditServiceID, orderID, entities.Credit, value, time.Time{})
+}
+
+func debit(userID, orderID string, value entities.Currency) *entities.Operation {
+	return entities.NewOperation(userID, serviceID, orderID, entities.Debit, value, time.Time{})
+}
+
+func mustCredit(t *testing.T, st cases.Storage, userID string, value entities.Currency) {
+	t.Helper()
+
+	if err := st.CreateOrUpdateBalance(context.Background(), credit(userID, uuid.New().String(), value)); err != nil {
+		t.Fatalf("credit %d: %v", value, err)
+	}
+}
+
+func expectBalance(t *testing.T, st cases.Storage, userID string, want entities.Currency) {
+	t.Helper()
+
+	balance, err := st.GetBalance(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("get balance: %v", err)
+	}
+
+	if balance.Value() != want {
+		t.Fatalf("balance = %d, want %d", balance.Value(), want)
+	}
+}
+
+func expectError(t *testing.T, action string, err, want error) {
+	t.Helper()
+
+	if !errors.Is(err, want) {
+		t.Fatalf("%s: expected %v, got %v", action, want, err)
+	}
+}
+
+func testConcurrentCredits(t *testing.T, st cases.Storage) {
+	const credits = 50
+
+	userID := newUserID()
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, credits)
+
+	for i := 0; i < credits; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+			errs <- st.CreateOrUpdateBalance(context.Background(), credit(userID, fmt.Sprintf("credit-%d", i), 2))
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("credit: %v", err)
+		}
+	}
+
+	expectBalance(t, st, userID, 2*credits)
+}
+
+func testDuplicateCredit(t *testing.T, st cases.Storage) {
+	ctx := context.Background()
+	userID := newUserID()
+
+	if err := st.CreateOrUpdateBalance(ctx, credit(userID, "credit", 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	err := st.CreateOrUpdateBalance(ctx, credit(userID, "credit", 10))
+	expectError(t, "repeated credit", err, entities.ErrReserveAlreadyExists)
+
+	expectBalance(t, st, userID, 10)
+}
+
+func testInsufficientFunds(t *testing.T, st cases.Storage) {
+	ctx := context.Background()
+	userID := newUserID()
+
+	err := st.CreateOperation(ctx, debit(userID, "order", 1))
+	expectError(t, "reserve without balance", err, entities.ErrNotFound)
+
+	mustCredit(t, st, userID, 100)
+
+	err = st.CreateOperation(ctx, debit(userID, "order", 101))
+	expectError(t, "reserve over balance", err, entities.ErrReserveInvalidValue)
+
+	expectBalance(t, st, userID, 100)
+
+	if _, err = st.GetOperation(ctx, userID, "order", serviceID); !errors.Is(err, entities.ErrNotFound) {
+		t.Fatalf("failed reserve was stored: %v", err)
+	}
+
+	if err = st.CreateOperation(ctx, debit(userID, "order", 100)); err != nil {
+		t.Fatalf("reserve of whole balance: %v", err)
+	}
+
+	expectBalance(t, st, userID, 0)
+}
+
+func testDuplicateReserve(t *testing.T, st cases.Storage) {
+	ctx := context.Background()
+	userID := newUserID()
+
+	mustCredit(t, st, userID, 100)
+
+	if err := st.CreateOperation(ctx, debit(userID, "order", 30)); err != nil {
+		t.Fatal(err)
+	}
+
+	err := st.CreateOperation(ctx, debit(userID, "order", 30))
+	expectError(t, "duplicate reserve", err, entities.ErrReserveAlreadyExists)
+
+	expectBalance(t, st, userID, 70)
+
+	// the same order id of another service is a different reserve
+	other := entities.NewOperation(userID, serviceID+"-other", "order", entities.Debit, 30, time.Time{})
+	if err = st.CreateOperation(ctx, other); err != nil {
+		t.Fatalf("reserve of other service: %v", err)
+	}
+
+	expectBalance(t, st, userID, 40)
+}
+
+func testPartialCommit(t *testing.T, st cases.Storage) {
+	ctx := context.Background()
+	userID := newUserID()
+
+	mustCredit(t, st, userID, 100)
+
+	if err := st.CreateOperation(ctx, debit(userID, "order", 50)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, value := range []entities.Currency{20, 30} {
+		if err := st.UpdateOperationReserve(ctx, debit(userID, "order", value)); err != nil {
+			t.Fatalf("commit %d: %v", value, err)
+		}
+	}
+
+	err := st.UpdateOperationReserve(ctx, debit(userID, "order", 1))
+	expectError(t, "commit of spent reserve", err, entities.ErrCommitInvalidValue)
+
+	operation, err := st.GetOperation(ctx, userID, "order", serviceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if operation.Value() != 50 || operation.OperationType() != entities.Debit {
+		t.Fatalf("operation value = %d, type = %d", operation.Value(), operation.OperationType())
+	}
+
+	expectBalance(t, st, userID, 50)
+}
+
+func testOverCommit(t *testing.T, st cases.Storage) {
+	ctx := context.Background()
+	userID := newUserID()
+
+	mustCredit(t, st, userID, 100)
+
+	if err := st.CreateOperation(ctx, debit(userID, "order", 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	err := st.UpdateOperationReserve(ctx, debit(userID, "order", 11))
+	expectError(t, "over-commit", err, entities.ErrCommitInvalidValue)
+
+	if err = st.UpdateOperationReserve(ctx, debit(userID, "order", 10)); err != nil {
+		t.Fatalf("commit of whole reserve after failed over-commit: %v", err)
+	}
+
+	err = st.UpdateOperationReserve(ctx, debit(userID, "missing", 1))
+	expectError(t, "commit of unknown order", err, entities.ErrNotFound)
+}
+
+// createOperations credits values in order, so creation order and value order differ.
+func createOperations(t *testing.T, st cases.Storage, userID string, values ...entities.Currency) {
+	t.Helper()
+
+	for i, value := range values {
+		if err := st.CreateOrUpdateBalance(context.Background(), credit(userID, fmt.Sprintf("%d", i), value)); err != nil {
+			t.Fatal(err)
+		}
+
+		// keeps created_at distinct for storages with coarse clocks
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+func orderIDs(operations []*entities.Operation) string {
+	var ids string
+
+	for _, operation := range operations {
+		ids += operation.OrderID()
+	}
+
+	return ids
+}
+
+func testListOrdering(t *testing.T, st cases.Storage) {
+	userID := newUserID()
+
+	createOperations(t, st, userID, 30, 10, 20)
+
+	for _, tc := range []struct {
+		sortBy string
+		desc   bool
+		want   string
+	}{
+		{entities.Date, false, "012"},
+		{entities.Date, true, "210"},
+		{entities.Value, false, "120"},
+		{entities.Value, true, "021"},
+	} {
+		operations, err := st.ListOperations(context.Background(), userID, 0, 0, tc.sortBy, tc.desc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := orderIDs(operations); got != tc.want {
+			t.Errorf("%s desc=%v: got %q, want %q", tc.sortBy, tc.desc, got, tc.want)
+		}
+	}
+
+	operations, err := st.ListOperations(context.Background(), newUserID(), 0, 0, entities.Date, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(operations) != 0 {
+		t.Errorf("unknown user has %d operations", len(operations))
+	}
+}
+
+func testListPagination(t *testing.T, st cases.Storage) {
+	userID := newUserID()
+
+	createOperations(t, st, userID, 1, 2, 3, 4, 5)
+
+	for _, tc := range []struct {
+		limit, offset int
+		want          string
+	}{
+		{2, 0, "01"},
+		{2, 2, "23"},
+		{2, 4, "4"},
+		{0, 3, "34"},
+		{10, 0, "01234"},
+		{2, 5, ""},
+	} {
+		operations, err := st.ListOperations(context.Background(), userID, tc.limit, tc.offset, entities.Date, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := orderIDs(operations); got != tc.want {
+			t.Errorf("limit=%d offset=%d: got %q, want %q", tc.limit, tc.offset, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Package subscribers fans operations of storages out to subscribers of this
+// process: per user subscribers of balance events and watchers of changes of
+// all users.
+package subscribers
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"service/internal/entities"
+	"sync"
+)
+
+const (
+	subscriberBufferSize = 16
+	watcherBufferSize    = 1024
+)
+
+type Subscribers struct {
+	mu       sync.RWMutex
+	users    map[string]map[chan *entities.Operation]struct{}
+	watchers map[chan string]struct{}
+}
+
+func New() *Subscribers {
+	return &Subscribers{
+		users:    make(map[string]map[chan *entities.Operation]struct{}),
+		watchers: make(map[chan string]struct{}),
+	}
+}
+
+// Subscribe streams operations of user until ctx is done, then closes the channel.
+func (s *Subscribers) Subscribe(ctx context.Context, userID string) (<-chan *entities.Operation, error) {
+	if userID == "" {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty user id")
+	}
+
+	ch := make(chan *entities.Operation, subscriberBufferSize)
+
+	s.mu.Lock()
+	if _, ok := s.users[userID]; !ok {
+		s.users[userID] = make(map[chan *entities.Operation]struct{})
+	}
+	s.users[userID][ch] = struct{}{}
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		delete(s.users[userID], ch)
+		if len(s.users[userID]) == 0 {
+			delete(s.users, userID)
+		}
+
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+// Watch streams ids of changed users until ctx is done, then closes the
+// channel. An empty id means changes may have been missed.
+func (s *Subscribers) Watch(ctx context.Context) <-chan string {
+	ch := make(chan string, watcherBufferSize)
+
+	s.mu.Lock()
+	s.watchers[ch] = struct{}{}
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		delete(s.watchers, ch)
+		close(ch)
+	}()
+
+	return ch
+}
+
+// Publish never blocks: a subscriber that does not keep up loses events.
+// Watchers invalidate caches, they are told first.
+func (s *Subscribers) Publish(operation *entities.Operation) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for ch := range s.watchers {
+		changed(ch, operation.UserID())
+	}
+
+	for ch := range s.users[operation.UserID()] {
+		select {
+		case ch <- operation:
+		default:
+		}
+	}
+}
+
+// Lost tells watchers that changes may have been missed.
+func (s *Subscribers) Lost() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for ch := range s.watchers {
+		changed(ch, "")
+	}
+}
+
+// changed never blocks: a watcher that does not keep up has its pending ids
+// replaced by an empty one, meaning every user may have changed.
+func changed(ch chan string, userID string) {
+	select {
+	case ch <- userID:
+		return
+	default:
+	}
+
+	for len(ch) > 0 {
+		select {
+		case <-ch:
+		default:
+		}
+	}
+
+	select {
+	case ch <- "":
+	default:
+	}
+}
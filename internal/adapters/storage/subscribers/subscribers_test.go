@@ -0,0 +1,77 @@
+package subscribers
+
+import (
+	"context"
+	"service/internal/entities"
+	"testing"
+	"time"
+)
+
+func operation(userID string) *entities.Operation {
+	return entities.NewOperation(userID, "service", "order", entities.Credit, 100, time.Now())
+}
+
+func TestSubscribers_Subscribe(t *testing.T) {
+	s := New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ch, err := s.Subscribe(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Publish(operation("other"))
+	s.Publish(operation("user"))
+
+	if got := (<-ch).UserID(); got != "user" {
+		t.Errorf("operation of %q, want user", got)
+	}
+
+	cancel()
+
+	// the channel is closed and the subscriber removed once ctx is done
+	for range ch {
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.users) != 0 {
+		t.Errorf("subscribers left: %v", s.users)
+	}
+
+	if _, err = s.Subscribe(context.Background(), ""); err == nil {
+		t.Error("empty user id accepted")
+	}
+}
+
+func TestSubscribers_Watch(t *testing.T) {
+	s := New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := s.Watch(ctx)
+
+	s.Publish(operation("user"))
+
+	if got := <-ch; got != "user" {
+		t.Errorf("changed %q, want user", got)
+	}
+
+	// a watcher falling behind gets an empty id instead of its pending ones
+	for i := 0; i <= watcherBufferSize; i++ {
+		s.Publish(operation("user"))
+	}
+
+	if got := <-ch; got != "" || len(ch) != 0 {
+		t.Errorf("changed %q with %d pending, want only empty id", got, len(ch))
+	}
+
+	s.Lost()
+
+	if got := <-ch; got != "" {
+		t.Errorf("changed %q after lost, want empty id", got)
+	}
+}
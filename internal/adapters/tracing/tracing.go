@@ -0,0 +1,134 @@
+package tracing
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+	"io"
+	"os"
+	"service/internal/entities"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+	ExporterOTLP   = "otlp"
+
+	defaultServiceName = "balance"
+)
+
+type Options struct {
+	ServiceName string
+	// Exporter is one of none, stdout, file or otlp.
+	Exporter string
+	// File is a path traces are appended to with file exporter.
+	File string
+	// Endpoint is an OTLP gRPC collector address with otlp exporter.
+	Endpoint string
+	// SampleRatio of traces started by the service, 0 samples none of them.
+	// Traces continued from a sampled parent are always sampled.
+	SampleRatio float64
+}
+
+type Provider struct {
+	provider *sdktrace.TracerProvider
+	closer   io.Closer
+}
+
+// NewProvider registers global tracer provider and W3C trace context propagator.
+// With none exporter spans are not recorded at all.
+func NewProvider(ctx context.Context, opts Options) (*Provider, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	p := &Provider{}
+
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+
+	if opts.SampleRatio < 0 || opts.SampleRatio > 1 {
+		return nil, errors.WithMessagef(entities.ErrInvalidParam, "sample ratio %v out of 0..1", opts.SampleRatio)
+	}
+
+	switch opts.Exporter {
+	case "", ExporterNone:
+		return p, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case ExporterFile:
+		if opts.File == "" {
+			return nil, errors.WithMessage(entities.ErrInvalidParam, "empty tracing file")
+		}
+
+		var f *os.File
+
+		f, err = os.OpenFile(opts.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, err
+		}
+
+		p.closer = f
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	case ExporterOTLP:
+		if opts.Endpoint == "" {
+			return nil, errors.WithMessage(entities.ErrInvalidParam, "empty tracing endpoint")
+		}
+
+		exporter, err = otlptracegrpc.New(
+			ctx,
+			otlptracegrpc.WithEndpoint(opts.Endpoint),
+			otlptracegrpc.WithInsecure(),
+		)
+	default:
+		return nil, errors.WithMessagef(entities.ErrInvalidParam, "unknown tracing exporter %q", opts.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	serviceName := opts.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+
+	p.provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(serviceName),
+		)),
+	)
+
+	otel.SetTracerProvider(p.provider)
+
+	return p, nil
+}
+
+// Shutdown flushes buffered spans.
+func (p *Provider) Shutdown(ctx context.Context) error {
+	if p.provider == nil {
+		return nil
+	}
+
+	err := p.provider.Shutdown(ctx)
+
+	if p.closer != nil {
+		if closeErr := p.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
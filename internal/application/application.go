@@ -2,22 +2,67 @@ package application
 
 import (
 	"context"
+	"fmt"
 	"go.uber.org/zap"
 	"os"
 	"os/signal"
+	"service/internal/adapters/certs"
+	"service/internal/adapters/jwt"
+	"service/internal/adapters/metrics"
+	"service/internal/adapters/ratelimit"
+	"service/internal/adapters/storage/cache"
+	"service/internal/adapters/storage/memory"
 	"service/internal/adapters/storage/postgres"
+	"service/internal/adapters/storage/sharded"
+	"service/internal/adapters/storage/sqlite"
+	"service/internal/adapters/tracing"
 	"service/internal/cases"
 	"service/internal/config"
+	"service/internal/entities"
+	"service/internal/ports/grpc"
 	"service/internal/ports/http"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 )
 
+const (
+	storagePostgres = "postgres"
+	storageSharded  = "sharded"
+	storageSQLite   = "sqlite"
+	storageMemory   = "memory"
+
+	rateLimitMemory   = "memory"
+	rateLimitPostgres = "postgres"
+
+	defaultShutdownTimeout = 15 * time.Second
+	tracingShutdownTimeout = 5 * time.Second
+)
+
+// storage is implemented by every storage driver.
+type storage interface {
+	cases.Storage
+	cases.Notifier
+	cases.KeyStorage
+	http.HealthChecker
+	Close()
+}
+
 type Application struct {
-	cancel  context.CancelFunc
-	log     *zap.SugaredLogger
-	storage *postgres.Storage
-	cfg     *config.Config
-	server  *http.Server
+	cancel     context.CancelFunc
+	log        *zap.SugaredLogger
+	level      zap.AtomicLevel
+	storage    storage
+	cache      *cache.Storage
+	cfg        *config.Config
+	metrics    *metrics.Prometheus
+	tracing    *tracing.Provider
+	certs      *certs.Reloader
+	limits     *http.RateLimits
+	server     *http.Server
+	grpcServer *grpc.Server
 }
 
 func (a *Application) Build(configPath string) {
@@ -27,14 +72,44 @@ func (a *Application) Build(configPath string) {
 
 	a.cfg, err = config.NewConfig(configPath)
 	if err != nil {
-		a.log.Fatal("init config")
+		a.log.Fatal(err)
+	}
+
+	if err = a.level.UnmarshalText([]byte(a.cfg.LogLevel())); err != nil {
+		a.log.Fatal(err)
+	}
+
+	a.metrics = metrics.NewPrometheus()
+	a.tracing = a.buildTracing()
+
+	a.storage = a.buildStorage()
+
+	svc := a.buildService(a.buildCache(), a.storage)
+	authSvc := a.buildAuthService(a.storage)
+
+	a.limits = a.buildRateLimits()
+	a.server = a.buildServer(svc, authSvc, a.limits)
+
+	if err = a.server.SetSettings(a.serverSettings(a.cfg)); err != nil {
+		a.log.Fatal(err)
 	}
 
-	a.storage = a.buildPostgresStorage()
+	if a.cfg.TLSCertFile() != "" {
+		a.certs = a.buildCerts()
+		a.server.UseTLS(a.certs.TLSConfig(), a.tlsClientPermissions())
+	}
 
-	svc := a.buildService(a.storage)
+	if a.cfg.GRPCPort() != 0 {
+		a.grpcServer = a.buildGRPCServer(svc, authSvc)
 
-	a.server = a.buildServer(svc)
+		if err = a.grpcServer.SetSettings(a.grpcSettings(a.cfg)); err != nil {
+			a.log.Fatal(err)
+		}
+	}
+
+	if configPath != "" {
+		a.watchConfig()
+	}
 }
 
 func (a *Application) Run() {
@@ -49,26 +124,94 @@ func (a *Application) Run() {
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 
-	go func() {
-		select {
-		case <-sig:
-		case <-ctx.Done():
-		}
+	if a.grpcServer != nil {
+		go a.grpcServer.Run()
+	}
 
-		a.Stop()
-	}()
+	go a.server.Run()
 
-	a.server.Run(ctx)
+	select {
+	case <-sig:
+	case <-ctx.Done():
+	}
+
+	a.Stop()
 }
 
+// Stop shuts application down in dependency order: servers stop accepting and
+// drain in-flight requests in parallel within the shutdown timeout, then
+// background workers stop, then storage closes,
+// so no accepted request runs against a closed pool.
 func (a *Application) Stop() {
-	a.storage.Close()
+	timeout := a.cfg.ServerShutdownTimeout()
+	if timeout == 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	a.log.Infof("shutting down, timeout %s", timeout)
+
+	// servers drain in parallel, so gRPC calls get the whole timeout too and
+	// are not cut off while HTTP waits out its drain delay
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		if err := a.server.Shutdown(ctx); err != nil {
+			a.log.Error(err)
+		}
+	}()
+
+	if a.grpcServer != nil {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if err := a.grpcServer.Shutdown(ctx); err != nil {
+				a.log.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
 	a.cancel()
+
+	if a.certs != nil {
+		if err := a.certs.Close(); err != nil {
+			a.log.Error(err)
+		}
+	}
+
+	if a.cache != nil {
+		a.cache.Close()
+	}
+
+	a.storage.Close()
+
+	tracingCtx, tracingCancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer tracingCancel()
+
+	if err := a.tracing.Shutdown(tracingCtx); err != nil {
+		a.log.Error(err)
+	}
+
 	_ = a.log.Sync()
 }
 
 func (a *Application) initConfig() *zap.SugaredLogger {
-	logger, err := zap.NewProduction()
+	a.level = zap.NewAtomicLevel()
+
+	loggerConfig := zap.NewProductionConfig()
+	loggerConfig.Level = a.level
+
+	logger, err := loggerConfig.Build()
 	if err != nil {
 		a.log.Fatal(err)
 	}
@@ -76,8 +219,327 @@ func (a *Application) initConfig() *zap.SugaredLogger {
 	return logger.Sugar()
 }
 
-func (a *Application) buildPostgresStorage() *postgres.Storage {
-	st, err := postgres.NewStorage(a.log, a.cfg.PostgresDSN())
+// watchConfig applies reloadable settings on config file change: log level,
+// limits, rate limit rules and feature toggles. Invalid configs are rejected
+// and the running settings are kept, other keys take effect after restart.
+func (a *Application) watchConfig() {
+	current := a.cfg
+
+	err := a.cfg.Watch(func(next *config.Config, err error) {
+		if err != nil {
+			a.log.Errorf("config reload rejected: %s", err)
+			return
+		}
+
+		changes := current.Diff(next)
+		if len(changes) == 0 {
+			return
+		}
+
+		if err = a.applyConfig(next); err != nil {
+			a.log.Errorf("config reload rejected: %s", err)
+			return
+		}
+
+		for _, change := range changes {
+			if reloadable(change) {
+				a.log.Infof("config reloaded %s", change)
+			} else {
+				a.log.Warnf("config changed %s, restart to apply", change)
+			}
+		}
+
+		current = next
+	})
+	if err != nil {
+		a.log.Fatal(err)
+	}
+}
+
+func (a *Application) applyConfig(cfg *config.Config) error {
+	rules, err := cfg.RateLimitRules()
+	if err != nil {
+		return err
+	}
+
+	level := zap.NewAtomicLevel()
+	if err = level.UnmarshalText([]byte(cfg.LogLevel())); err != nil {
+		return err
+	}
+
+	if err = a.limits.SetRules(toRateLimitRules(rules)); err != nil {
+		return err
+	}
+
+	if a.grpcServer != nil {
+		if err = a.grpcServer.SetSettings(a.grpcSettings(cfg)); err != nil {
+			return err
+		}
+	}
+
+	if err = a.server.SetSettings(a.serverSettings(cfg)); err != nil {
+		return err
+	}
+
+	a.level.SetLevel(level.Level())
+
+	return nil
+}
+
+func reloadable(change string) bool {
+	for _, prefix := range []string{"log.", "limits.", "features.", "rate_limit.routes:"} {
+		if strings.HasPrefix(change, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *Application) serverSettings(cfg *config.Config) http.Settings {
+	return http.Settings{
+		PageSize:      cfg.OperationsPageSize(),
+		MaxPageSize:   cfg.OperationsMaxPageSize(),
+		BalanceEvents: cfg.Feature("balance_events"),
+		DrainDelay:    cfg.ServerDrainDelay(),
+	}
+}
+
+func (a *Application) grpcSettings(cfg *config.Config) grpc.Settings {
+	return grpc.Settings{
+		PageSize:    cfg.OperationsPageSize(),
+		MaxPageSize: cfg.OperationsMaxPageSize(),
+	}
+}
+
+// Migrate runs migrate subcommand: up, down [steps] or status.
+func (a *Application) Migrate(configPath string, args []string) {
+	var err error
+
+	a.log = a.initConfig()
+	defer func() { _ = a.log.Sync() }()
+
+	a.cfg, err = config.NewConfig(configPath)
+	if err != nil {
+		a.log.Fatal(err)
+	}
+
+	if len(args) == 0 {
+		a.log.Fatal("usage: migrate up|down [steps]|status")
+	}
+
+	if driver := a.cfg.StorageDriver(); driver != storagePostgres && driver != storageSharded {
+		a.log.Fatalf("storage driver %q has no migrations", driver)
+	}
+
+	switch command := args[0]; command {
+	case "up":
+		a.migrateUp()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil {
+				a.log.Fatalf("invalid steps %q", args[1])
+			}
+		}
+
+		a.withMigrator(func(ctx context.Context, migrator *postgres.Migrator) error {
+			reverted, err := migrator.Down(ctx, steps)
+			a.log.Infof("reverted migrations %v", reverted)
+			return err
+		})
+	case "status":
+		a.withMigrator(func(ctx context.Context, migrator *postgres.Migrator) error {
+			statuses, err := migrator.Status(ctx)
+			if err != nil {
+				return err
+			}
+
+			for _, status := range statuses {
+				state := "pending"
+				if status.AppliedAt != nil {
+					state = "applied " + status.AppliedAt.Format(time.RFC3339)
+				}
+
+				fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, state)
+			}
+
+			return nil
+		})
+	default:
+		a.log.Fatalf("unknown migrate command %q, expected up, down or status", command)
+	}
+}
+
+// Shards runs shards subcommand: status, rebalance [batch] or recover.
+func (a *Application) Shards(configPath string, args []string) {
+	var err error
+
+	a.log = a.initConfig()
+	defer func() { _ = a.log.Sync() }()
+
+	a.cfg, err = config.NewConfig(configPath)
+	if err != nil {
+		a.log.Fatal(err)
+	}
+
+	if len(args) == 0 {
+		a.log.Fatal("usage: shards status|rebalance [batch]|recover")
+	}
+
+	if driver := a.cfg.StorageDriver(); driver != storageSharded {
+		a.log.Fatalf("storage driver %q is not sharded", driver)
+	}
+
+	st := a.buildShardedStorage()
+	defer st.Close()
+
+	ctx := context.Background()
+
+	switch command := args[0]; command {
+	case "status":
+		statuses, err := st.Status(ctx)
+		if err != nil {
+			a.log.Fatal(err)
+		}
+
+		for _, status := range statuses {
+			fmt.Printf("%d\tusers %d\tmisplaced %d\n", status.Shard, status.Users, status.Misplaced)
+		}
+	case "rebalance":
+		batch := 0
+		if len(args) > 1 {
+			if batch, err = strconv.Atoi(args[1]); err != nil {
+				a.log.Fatalf("invalid batch %q", args[1])
+			}
+		}
+
+		moved, err := st.Rebalance(ctx, batch)
+		a.log.Infof("moved %d users", moved)
+
+		if err != nil {
+			a.log.Fatal(err)
+		}
+	case "recover":
+		committed, rolledBack, err := st.Recover(ctx)
+		a.log.Infof("committed %d and rolled back %d prepared moves", committed, rolledBack)
+
+		if err != nil {
+			a.log.Fatal(err)
+		}
+	default:
+		a.log.Fatalf("unknown shards command %q, expected status, rebalance or recover", command)
+	}
+}
+
+func (a *Application) migrateUp() {
+	a.withMigrator(func(ctx context.Context, migrator *postgres.Migrator) error {
+		applied, err := migrator.Up(ctx)
+		a.log.Infof("applied migrations %v", applied)
+		return err
+	})
+}
+
+// withMigrator runs fn for the postgres database or for every shard in turn.
+func (a *Application) withMigrator(fn func(ctx context.Context, migrator *postgres.Migrator) error) {
+	ctx := context.Background()
+
+	dsns := []string{a.cfg.PostgresDSN()}
+	if a.cfg.StorageDriver() == storageSharded {
+		dsns = a.cfg.StorageShards()
+	}
+
+	for i, dsn := range dsns {
+		if len(dsns) > 1 {
+			a.log.Infof("shard %d", i)
+		}
+
+		migrator, err := postgres.NewMigrator(ctx, a.log, dsn)
+		if err != nil {
+			a.log.Fatal(err)
+		}
+
+		err = fn(ctx, migrator)
+
+		if closeErr := migrator.Close(ctx); closeErr != nil {
+			a.log.Error(closeErr)
+		}
+
+		if err != nil {
+			a.log.Fatal(err)
+		}
+	}
+}
+
+func (a *Application) buildStorage() storage {
+	switch driver := a.cfg.StorageDriver(); driver {
+	case storagePostgres:
+		if a.cfg.AutoMigrate() {
+			a.migrateUp()
+		}
+
+		st := a.buildPostgresStorage(a.cfg.PostgresDSN(), a.cfg.StorageReplicas())
+
+		if err := a.metrics.Register(st.Collector()); err != nil {
+			a.log.Fatal(err)
+		}
+
+		return st
+	case storageSharded:
+		if a.cfg.AutoMigrate() {
+			a.migrateUp()
+		}
+
+		st := a.buildShardedStorage()
+
+		if err := st.Register(a.metrics.Registerer()); err != nil {
+			a.log.Fatal(err)
+		}
+
+		return st
+	case storageSQLite:
+		st, err := sqlite.NewStorage(a.log, a.cfg.SQLitePath())
+		if err != nil {
+			a.log.Fatal(err)
+		}
+
+		return st
+	case storageMemory:
+		a.log.Warn("memory storage is used, data is lost on restart")
+		return memory.NewStorage()
+	default:
+		a.log.Fatalf("unknown storage driver %q", driver)
+	}
+
+	return nil
+}
+
+func (a *Application) buildPostgresStorage(dsn string, replicas []string) *postgres.Storage {
+	st, err := postgres.NewStorage(a.log, dsn, postgres.Options{
+		Isolation: a.cfg.StorageIsolation(),
+		Retry: postgres.RetryOptions{
+			MaxAttempts: a.cfg.StorageRetryMaxAttempts(),
+			BaseDelay:   a.cfg.StorageRetryBaseDelay(),
+			MaxDelay:    a.cfg.StorageRetryMaxDelay(),
+		},
+		Replicas:      replicas,
+		ReplicaMaxLag: a.cfg.StorageReplicaMaxLag(),
+		Pool: postgres.PoolOptions{
+			MaxConns:          int32(a.cfg.StoragePoolMaxConns()),
+			MinConns:          int32(a.cfg.StoragePoolMinConns()),
+			MaxConnLifetime:   a.cfg.StoragePoolMaxConnLifetime(),
+			MaxConnIdleTime:   a.cfg.StoragePoolMaxConnIdleTime(),
+			HealthCheckPeriod: a.cfg.StoragePoolHealthCheckPeriod(),
+			ConnectTimeout:    a.cfg.StoragePoolConnectTimeout(),
+			StatementTimeout:  a.cfg.StorageStatementTimeout(),
+		},
+		QueryTimeout: a.cfg.StorageQueryTimeout(),
+		Partitions: postgres.PartitionOptions{
+			Premake:         a.cfg.StoragePartitionsPremake(),
+			RetentionMonths: a.cfg.StoragePartitionsRetentionMonths(),
+			CheckPeriod:     a.cfg.StoragePartitionsCheckPeriod(),
+		},
+	})
 	if err != nil {
 		a.log.Fatal(err)
 	}
@@ -85,8 +547,58 @@ func (a *Application) buildPostgresStorage() *postgres.Storage {
 	return st
 }
 
-func (a *Application) buildService(storage cases.Storage) *cases.BalanceService {
-	svc, err := cases.NewBalanceService(a.log, storage)
+// buildShardedStorage connects to every shard, shards have no read replicas.
+func (a *Application) buildShardedStorage() *sharded.Storage {
+	dsns := a.cfg.StorageShards()
+	shards := make([]*postgres.Storage, 0, len(dsns))
+
+	for _, dsn := range dsns {
+		shards = append(shards, a.buildPostgresStorage(dsn, nil))
+	}
+
+	st, err := sharded.NewStorage(a.log, shards, sharded.Options{
+		PreviousShards: a.cfg.StorageShardingPreviousShards(),
+		VirtualNodes:   a.cfg.StorageShardingVirtualNodes(),
+	})
+	if err != nil {
+		a.log.Fatal(err)
+	}
+
+	return st
+}
+
+// buildCache decorates storage with the balance cache unless it is disabled.
+func (a *Application) buildCache() cases.Storage {
+	size := a.cfg.StorageCacheSize()
+	if size == 0 {
+		return a.storage
+	}
+
+	lru, err := cache.NewLRU(size, a.cfg.StorageCacheTTL())
+	if err != nil {
+		a.log.Fatal(err)
+	}
+
+	// replicas may serve balances older than an invalidation by their lag
+	var readLag time.Duration
+	if a.cfg.StorageDriver() == storagePostgres && len(a.cfg.StorageReplicas()) != 0 {
+		readLag = a.cfg.StorageReplicaMaxLag()
+	}
+
+	a.cache, err = cache.NewStorage(a.log, a.storage, lru, cache.Options{ReadLag: readLag})
+	if err != nil {
+		a.log.Fatal(err)
+	}
+
+	if err = a.metrics.Register(a.cache.Collector()); err != nil {
+		a.log.Fatal(err)
+	}
+
+	return a.cache
+}
+
+func (a *Application) buildService(storage cases.Storage, notifier cases.Notifier) *cases.BalanceService {
+	svc, err := cases.NewBalanceService(a.log, storage, notifier, a.metrics)
 	if err != nil {
 		a.log.Fatal(err)
 	}
@@ -94,11 +606,142 @@ func (a *Application) buildService(storage cases.Storage) *cases.BalanceService
 	return svc
 }
 
-func (a *Application) buildServer(svc *cases.BalanceService) *http.Server {
-	srv, err := http.NewServer(a.log, svc, a.cfg.ServerPort())
+func (a *Application) buildAuthService(storage cases.KeyStorage) *cases.AuthService {
+	svc, err := cases.NewAuthService(a.log, storage, a.buildTokenVerifier(), a.cfg.AdminKey())
+	if err != nil {
+		a.log.Fatal(err)
+	}
+
+	return svc
+}
+
+// buildTokenVerifier returns nil when bearer tokens are not configured.
+func (a *Application) buildTokenVerifier() cases.TokenVerifier {
+	if a.cfg.JWTAlgorithm() == "" {
+		return nil
+	}
+
+	verifier, err := jwt.NewVerifier(jwt.Options{
+		Algorithm:     a.cfg.JWTAlgorithm(),
+		Secret:        a.cfg.JWTSecret(),
+		PublicKeyFile: a.cfg.JWTPublicKeyFile(),
+		JWKSFile:      a.cfg.JWTJWKSFile(),
+		Issuer:        a.cfg.JWTIssuer(),
+		Audience:      a.cfg.JWTAudience(),
+	})
+	if err != nil {
+		a.log.Fatal(err)
+	}
+
+	return verifier
+}
+
+func (a *Application) buildRateLimits() *http.RateLimits {
+	var limiter http.RateLimiter
+
+	switch backend := a.cfg.RateLimitBackend(); backend {
+	case "", rateLimitMemory:
+		limiter = ratelimit.NewMemory()
+	case rateLimitPostgres:
+		st, ok := a.storage.(http.RateLimiter)
+		if !ok {
+			a.log.Fatalf("rate limit backend %q requires postgres storage", backend)
+		}
+
+		limiter = st
+	default:
+		a.log.Fatalf("unknown rate limit backend %q", backend)
+	}
+
+	rules, err := a.cfg.RateLimitRules()
+	if err != nil {
+		a.log.Fatal(err)
+	}
+
+	limits, err := http.NewRateLimits(limiter, a.cfg.RateLimitOnError(), toRateLimitRules(rules))
+	if err != nil {
+		a.log.Fatal(err)
+	}
+
+	return limits
+}
+
+func (a *Application) buildCerts() *certs.Reloader {
+	reloader, err := certs.NewReloader(a.log, certs.Options{
+		CertFile:          a.cfg.TLSCertFile(),
+		KeyFile:           a.cfg.TLSKeyFile(),
+		ClientCAFile:      a.cfg.TLSClientCAFile(),
+		RequireClientCert: a.cfg.TLSRequireClientCert(),
+	})
+	if err != nil {
+		a.log.Fatal(err)
+	}
+
+	return reloader
+}
+
+func (a *Application) tlsClientPermissions() []entities.Permission {
+	permissions := make([]entities.Permission, 0)
+
+	for _, value := range a.cfg.TLSClientPermissions() {
+		permission, err := entities.ParsePermission(value)
+		if err != nil {
+			a.log.Fatal(err)
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	return permissions
+}
+
+func (a *Application) buildServer(
+	svc *cases.BalanceService,
+	authSvc *cases.AuthService,
+	limits *http.RateLimits,
+) *http.Server {
+	srv, err := http.NewServer(a.log, svc, authSvc, limits, a.storage, a.metrics, a.cfg.ServerPort())
 	if err != nil {
 		a.log.Fatal(err)
 	}
 
 	return srv
 }
+
+func (a *Application) buildGRPCServer(svc *cases.BalanceService, authSvc *cases.AuthService) *grpc.Server {
+	srv, err := grpc.NewServer(a.log, svc, authSvc, a.cfg.GRPCPort())
+	if err != nil {
+		a.log.Fatal(err)
+	}
+
+	return srv
+}
+
+func (a *Application) buildTracing() *tracing.Provider {
+	provider, err := tracing.NewProvider(context.Background(), tracing.Options{
+		Exporter:    a.cfg.TracingExporter(),
+		File:        a.cfg.TracingFile(),
+		Endpoint:    a.cfg.TracingEndpoint(),
+		SampleRatio: a.cfg.TracingSampleRatio(),
+	})
+	if err != nil {
+		a.log.Fatal(err)
+	}
+
+	return provider
+}
+
+func toRateLimitRules(rules []config.RateLimitRule) []http.RateLimitRule {
+	result := make([]http.RateLimitRule, 0, len(rules))
+
+	for _, rule := range rules {
+		result = append(result, http.RateLimitRule{
+			Route: rule.Route,
+			By:    rule.By,
+			Rate:  rule.Rate,
+			Burst: rule.Burst,
+		})
+	}
+
+	return result
+}
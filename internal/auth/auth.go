@@ -0,0 +1,42 @@
+// Package auth carries authenticated caller in context.
+package auth
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"service/internal/entities"
+)
+
+type apiKeyKey struct{}
+
+type subjectKey struct{}
+
+func WithAPIKey(ctx context.Context, key *entities.APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyKey{}, key)
+}
+
+// APIKey returns key the request was authenticated with, nil if there is none.
+func APIKey(ctx context.Context) *entities.APIKey {
+	key, _ := ctx.Value(apiKeyKey{}).(*entities.APIKey)
+	return key
+}
+
+// WithSubject stores id of end user authenticated with bearer token.
+func WithSubject(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, subjectKey{}, userID)
+}
+
+func Subject(ctx context.Context) string {
+	userID, _ := ctx.Value(subjectKey{}).(string)
+	return userID
+}
+
+// CheckServiceID forbids a service to act on behalf of another one.
+func CheckServiceID(ctx context.Context, serviceID string) error {
+	key := APIKey(ctx)
+	if key == nil || key.ServiceID() != serviceID {
+		return errors.WithMessagef(entities.ErrForbidden, "api key is not allowed to act as service %q", serviceID)
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package cases
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"service/internal/entities"
+	"service/internal/logging"
+	"time"
+)
+
+const (
+	apiKeyPrefix = "bk_"
+	apiKeyBytes  = 32
+)
+
+type AuthService struct {
+	log       *zap.SugaredLogger
+	storage   KeyStorage
+	verifier  TokenVerifier
+	adminHash []byte
+}
+
+// NewAuthService creates service managing API keys. Bearer tokens are rejected
+// when verifier is nil, admin API is disabled when adminKey is empty.
+func NewAuthService(
+	log *zap.SugaredLogger,
+	storage KeyStorage,
+	verifier TokenVerifier,
+	adminKey string,
+) (*AuthService, error) {
+	if log == nil {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty logger")
+	}
+
+	if storage == nil || storage == KeyStorage(nil) {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty key storage")
+	}
+
+	s := &AuthService{
+		log:      log,
+		storage:  storage,
+		verifier: verifier,
+	}
+
+	if adminKey != "" {
+		hash := sha256.Sum256([]byte(adminKey))
+		s.adminHash = hash[:]
+	}
+
+	return s, nil
+}
+
+// Authenticate returns API key matching secret.
+func (s *AuthService) Authenticate(ctx context.Context, secret string) (*entities.APIKey, error) {
+	if secret == "" {
+		return nil, errors.WithMessage(entities.ErrUnauthorized, "empty api key")
+	}
+
+	key, err := s.storage.GetAPIKeyByHash(ctx, hashKey(secret))
+	if errors.Is(err, entities.ErrNotFound) {
+		return nil, errors.WithMessage(entities.ErrUnauthorized, "invalid api key")
+	}
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// AuthenticateToken returns user id the bearer token was issued to.
+func (s *AuthService) AuthenticateToken(ctx context.Context, token string) (string, error) {
+	if s.verifier == nil {
+		return "", errors.WithMessage(entities.ErrUnauthorized, "bearer tokens are not accepted")
+	}
+
+	subject, err := s.verifier.Verify(token)
+	if err != nil {
+		s.logger(ctx).Info(err)
+		return "", err
+	}
+
+	return subject, nil
+}
+
+func (s *AuthService) AuthenticateAdmin(_ context.Context, secret string) error {
+	if s.adminHash == nil {
+		return errors.WithMessage(entities.ErrForbidden, "admin api is disabled")
+	}
+
+	hash := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare(hash[:], s.adminHash) != 1 {
+		return errors.WithMessage(entities.ErrUnauthorized, "invalid admin key")
+	}
+
+	return nil
+}
+
+// CreateAPIKey creates key bound to serviceID. The returned secret is not
+// stored anywhere and can't be shown again.
+func (s *AuthService) CreateAPIKey(
+	ctx context.Context,
+	serviceID string,
+	permissions []entities.Permission,
+) (*entities.APIKey, string, error) {
+	if serviceID == "" {
+		return nil, "", errors.WithMessage(entities.ErrInvalidParam, "empty service id")
+	}
+
+	if len(permissions) == 0 {
+		return nil, "", errors.WithMessage(entities.ErrInvalidParam, "empty permissions")
+	}
+
+	random := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(random); err != nil {
+		s.logger(ctx).Error(err)
+		return nil, "", errors.WithMessage(entities.ErrInternal, err.Error())
+	}
+
+	secret := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(random)
+	key := entities.NewAPIKey(uuid.New().String(), serviceID, permissions, time.Now().UTC())
+
+	if err := s.storage.CreateAPIKey(ctx, key, hashKey(secret)); err != nil {
+		s.logger(ctx).Error(err)
+		return nil, "", err
+	}
+
+	s.logger(ctx).With("service_id", serviceID).Infof("api key %s created", key.ID())
+
+	return key, secret, nil
+}
+
+func (s *AuthService) ListAPIKeys(ctx context.Context) ([]*entities.APIKey, error) {
+	keys, err := s.storage.ListAPIKeys(ctx)
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (s *AuthService) RevokeAPIKey(ctx context.Context, id string) error {
+	if id == "" {
+		return errors.WithMessage(entities.ErrInvalidParam, "empty api key id")
+	}
+
+	if err := s.storage.DeleteAPIKey(ctx, id); err != nil {
+		s.logger(ctx).Error(err)
+		return err
+	}
+
+	s.logger(ctx).Infof("api key %s revoked", id)
+
+	return nil
+}
+
+func (s *AuthService) logger(ctx context.Context) *zap.SugaredLogger {
+	return logging.FromContext(ctx, s.log)
+}
+
+// hashKey keys are random, so plain sha256 is enough to keep them unusable if
+// the table leaks.
+func hashKey(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
@@ -4,17 +4,35 @@ import (
 	"context"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"regexp"
+	"service/internal/consistency"
 	"service/internal/entities"
+	"service/internal/logging"
 	"time"
 )
 
+// idempotencyKeyPrefix namespaces order ids of credits made with an
+// idempotency key, generated order ids of other credits never have it.
+const idempotencyKeyPrefix = "idempotency:"
+
+// validIdempotencyKey limits client supplied keys, so the order id fits the storage.
+var validIdempotencyKey = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
 type BalanceService struct {
-	log     *zap.SugaredLogger
-	storage Storage
+	log      *zap.SugaredLogger
+	storage  Storage
+	notifier Notifier
+	metrics  Metrics
 }
 
-func NewBalanceService(log *zap.SugaredLogger, storage Storage) (*BalanceService, error) {
+func NewBalanceService(
+	log *zap.SugaredLogger,
+	storage Storage,
+	notifier Notifier,
+	metrics Metrics,
+) (*BalanceService, error) {
 	if log == nil {
 		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty logger")
 	}
@@ -23,40 +41,85 @@ func NewBalanceService(log *zap.SugaredLogger, storage Storage) (*BalanceService
 		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty storage")
 	}
 
+	if notifier == nil || notifier == Notifier(nil) {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty notifier")
+	}
+
+	if metrics == nil || metrics == Metrics(nil) {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty metrics")
+	}
+
 	return &BalanceService{
-		log:     log,
-		storage: storage,
+		log:      log,
+		storage:  storage,
+		notifier: notifier,
+		metrics:  metrics,
 	}, nil
 }
 
-func (s *BalanceService) CreditBalance(ctx context.Context, userID string, value entities.Currency) error {
+// CreditBalance credits value to user balance. A non-empty idempotencyKey is
+// used as the credit order id under idempotencyKeyPrefix, so repeating a credit
+// with the same key is a no-op.
+func (s *BalanceService) CreditBalance(
+	ctx context.Context,
+	userID string,
+	value entities.Currency,
+	idempotencyKey string,
+) (err error) {
+	ctx, span := tracer.Start(ctx, "BalanceService.CreditBalance", trace.WithAttributes(userIDAttr.String(userID)))
+	defer func() { endSpan(span, err) }()
+
+	orderID := uuid.New().String()
+	if idempotencyKey != "" {
+		if !validIdempotencyKey.MatchString(idempotencyKey) {
+			err = errors.WithMessage(entities.ErrInvalidParam, "invalid idempotency key")
+			s.metrics.OperationFailed(OperationCredit, err)
+			return err
+		}
+
+		orderID = idempotencyKeyPrefix + idempotencyKey
+	}
+
 	operation := entities.NewOperation(
 		userID,
 		entities.DefaultCreditServiceID,
-		uuid.New().String(),
+		orderID,
 		entities.Credit,
 		value,
 		time.Time{},
 	)
 
-	err := s.storage.CreateOrUpdateBalance(ctx, operation)
+	err = s.storage.CreateOrUpdateBalance(ctx, operation)
+	if idempotencyKey != "" && errors.Is(err, entities.ErrReserveAlreadyExists) {
+		s.logger(ctx).With("user_id", userID).Infof("credit %s already applied", idempotencyKey)
+		return nil
+	}
 	if err != nil {
-		s.log.Error(err)
+		s.logger(ctx).Error(err)
+		s.metrics.OperationFailed(OperationCredit, err)
 		return err
 	}
 
+	s.metrics.OperationSucceeded(OperationCredit, value)
+
 	return nil
 }
 
-func (s *BalanceService) GetUserBalance(ctx context.Context, userID string) (*entities.Balance, error) {
-	log := s.log.With("user_id", userID)
+func (s *BalanceService) GetUserBalance(ctx context.Context, userID string) (_ *entities.Balance, err error) {
+	ctx, span := tracer.Start(ctx, "BalanceService.GetUserBalance", trace.WithAttributes(userIDAttr.String(userID)))
+	defer func() { endSpan(span, err) }()
+
+	log := s.logger(ctx).With("user_id", userID)
 
 	balance, err := s.storage.GetBalance(ctx, userID)
 	if err != nil {
 		log.Error(err)
+		s.metrics.OperationFailed(OperationGetBalance, err)
 		return nil, err
 	}
 
+	s.metrics.OperationSucceeded(OperationGetBalance, 0)
+
 	return balance, nil
 }
 
@@ -66,15 +129,25 @@ func (s *BalanceService) ReserveFromBalance(
 	serviceID string,
 	orderID string,
 	value entities.Currency,
-) error {
+) (err error) {
+	ctx, span := tracer.Start(ctx, "BalanceService.ReserveFromBalance", trace.WithAttributes(
+		userIDAttr.String(userID),
+		serviceIDAttr.String(serviceID),
+		orderIDAttr.String(orderID),
+	))
+	defer func() { endSpan(span, err) }()
+
 	operation := entities.NewOperation(userID, serviceID, orderID, entities.Debit, value, time.Time{})
 
-	err := s.storage.CreateOperation(ctx, operation)
+	err = s.storage.CreateOperation(ctx, operation)
 	if err != nil {
-		s.log.Error(err)
+		s.logger(ctx).Error(err)
+		s.metrics.OperationFailed(OperationReserve, err)
 		return err
 	}
 
+	s.metrics.OperationSucceeded(OperationReserve, value)
+
 	return nil
 }
 
@@ -84,29 +157,44 @@ func (s *BalanceService) CommitReserve(
 	serviceID string,
 	orderID string,
 	value entities.Currency,
-) error {
-	op, err := s.storage.GetOperation(ctx, userID, orderID, serviceID)
+) (err error) {
+	ctx, span := tracer.Start(ctx, "BalanceService.CommitReserve", trace.WithAttributes(
+		userIDAttr.String(userID),
+		serviceIDAttr.String(serviceID),
+		orderIDAttr.String(orderID),
+	))
+	defer func() { endSpan(span, err) }()
+
+	// the reserve is checked on the primary, a replica may not have it yet
+	op, err := s.storage.GetOperation(consistency.WithPrimary(ctx), userID, orderID, serviceID)
 	if err != nil {
-		s.log.Error(err)
+		s.logger(ctx).Error(err)
+		s.metrics.OperationFailed(OperationCommit, err)
 		return err
 	}
 	if err == entities.ErrNotFound {
-		s.log.Error(err)
+		s.logger(ctx).Error(err)
+		s.metrics.OperationFailed(OperationCommit, err)
 		return errors.WithMessage(err, "operation not found")
 	}
 	if op.Value() == 0 {
-		s.log.Error(err)
-		return errors.WithMessage(entities.ErrInvalidParam, "operation already committed")
+		err = errors.WithMessage(entities.ErrInvalidParam, "operation already committed")
+		s.logger(ctx).Error(err)
+		s.metrics.OperationFailed(OperationCommit, err)
+		return err
 	}
 
 	operation := entities.NewOperation(userID, serviceID, orderID, entities.Debit, value, time.Time{})
 
 	err = s.storage.UpdateOperationReserve(ctx, operation)
 	if err != nil {
-		s.log.Error(err)
+		s.logger(ctx).Error(err)
+		s.metrics.OperationFailed(OperationCommit, err)
 		return err
 	}
 
+	s.metrics.OperationSucceeded(OperationCommit, value)
+
 	return nil
 }
 
@@ -115,12 +203,99 @@ func (s *BalanceService) ListOperations(
 	userID string,
 	limit, offset int,
 	sortBy string, desc bool,
-) ([]*entities.Operation, error) {
+) (_ []*entities.Operation, err error) {
+	ctx, span := tracer.Start(ctx, "BalanceService.ListOperations", trace.WithAttributes(userIDAttr.String(userID)))
+	defer func() { endSpan(span, err) }()
+
 	operations, err := s.storage.ListOperations(ctx, userID, limit, offset, sortBy, desc)
 	if err != nil {
-		s.log.Error(err)
+		s.logger(ctx).Error(err)
+		s.metrics.OperationFailed(OperationListOperations, err)
+		return nil, err
+	}
+
+	s.metrics.OperationSucceeded(OperationListOperations, 0)
+
+	return operations, nil
+}
+
+// ListOperationsInRange lists operations created in [from, to) including
+// archived ones, in storages which archive operations.
+func (s *BalanceService) ListOperationsInRange(
+	ctx context.Context,
+	userID string,
+	from, to time.Time,
+	limit, offset int,
+	sortBy string, desc bool,
+) (_ []*entities.Operation, err error) {
+	ctx, span := tracer.Start(ctx, "BalanceService.ListOperationsInRange", trace.WithAttributes(userIDAttr.String(userID)))
+	defer func() { endSpan(span, err) }()
+
+	archive, ok := s.storage.(OperationArchive)
+	if !ok {
+		err = errors.WithMessage(entities.ErrInvalidParam, "storage does not support operations range")
+		s.metrics.OperationFailed(OperationListOperations, err)
+		return nil, err
+	}
+
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		err = errors.WithMessage(entities.ErrInvalidParam, "from must be before to")
+		s.metrics.OperationFailed(OperationListOperations, err)
+		return nil, err
+	}
+
+	operations, err := archive.ListOperationsInRange(ctx, userID, from, to, limit, offset, sortBy, desc)
+	if err != nil {
+		s.logger(ctx).Error(err)
+		s.metrics.OperationFailed(OperationListOperations, err)
 		return nil, err
 	}
 
+	s.metrics.OperationSucceeded(OperationListOperations, 0)
+
 	return operations, nil
 }
+
+// SubscribeBalanceEvents streams user's operations together with the balance
+// observed right after each of them. The channel is closed when ctx is done.
+func (s *BalanceService) SubscribeBalanceEvents(
+	ctx context.Context,
+	userID string,
+) (<-chan *entities.BalanceEvent, error) {
+	log := s.logger(ctx).With("user_id", userID)
+
+	operations, err := s.notifier.Subscribe(ctx, userID)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	events := make(chan *entities.BalanceEvent)
+
+	// the balance is read on the primary past caches, which may not have
+	// seen the operation yet when it comes from another replica
+	readCtx := consistency.WithPrimary(ctx)
+
+	go func() {
+		defer close(events)
+
+		for operation := range operations {
+			balance, err := s.storage.GetBalance(readCtx, userID)
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+
+			select {
+			case events <- entities.NewBalanceEvent(balance, operation):
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+func (s *BalanceService) logger(ctx context.Context) *zap.SugaredLogger {
+	return logging.FromContext(ctx, s.log)
+}
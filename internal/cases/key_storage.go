@@ -0,0 +1,13 @@
+package cases
+
+import (
+	"context"
+	"service/internal/entities"
+)
+
+type KeyStorage interface {
+	CreateAPIKey(ctx context.Context, key *entities.APIKey, hash string) error
+	GetAPIKeyByHash(ctx context.Context, hash string) (*entities.APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]*entities.APIKey, error)
+	DeleteAPIKey(ctx context.Context, id string) error
+}
@@ -0,0 +1,17 @@
+package cases
+
+import "service/internal/entities"
+
+const (
+	OperationCredit         = "credit"
+	OperationReserve        = "reserve"
+	OperationCommit         = "commit"
+	OperationGetBalance     = "get_balance"
+	OperationListOperations = "list_operations"
+)
+
+type Metrics interface {
+	// OperationSucceeded value is money moved by operation, zero for reads.
+	OperationSucceeded(operation string, value entities.Currency)
+	OperationFailed(operation string, err error)
+}
@@ -0,0 +1,10 @@
+package cases
+
+import (
+	"context"
+	"service/internal/entities"
+)
+
+type Notifier interface {
+	Subscribe(ctx context.Context, userID string) (<-chan *entities.Operation, error)
+}
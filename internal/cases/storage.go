@@ -3,6 +3,7 @@ package cases
 import (
 	"context"
 	"service/internal/entities"
+	"time"
 )
 
 type Storage interface {
@@ -19,3 +20,16 @@ type Storage interface {
 		sortBy string, desc bool,
 	) ([]*entities.Operation, error)
 }
+
+// OperationArchive is implemented by storages which archive old operations,
+// ListOperationsInRange reads archived operations too. Zero from or to leaves
+// the range open.
+type OperationArchive interface {
+	ListOperationsInRange(
+		ctx context.Context,
+		userID string,
+		from, to time.Time,
+		limit, offset int,
+		sortBy string, desc bool,
+	) ([]*entities.Operation, error)
+}
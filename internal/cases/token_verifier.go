@@ -0,0 +1,6 @@
+package cases
+
+// TokenVerifier checks end-user bearer token and returns its subject.
+type TokenVerifier interface {
+	Verify(token string) (string, error)
+}
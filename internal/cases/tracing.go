@@ -0,0 +1,25 @@
+package cases
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("service/internal/cases")
+
+const (
+	userIDAttr    = attribute.Key("user_id")
+	serviceIDAttr = attribute.Key("service_id")
+	orderIDAttr   = attribute.Key("order_id")
+)
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
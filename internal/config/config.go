@@ -1,32 +1,587 @@
 package config
 
 import (
+	"fmt"
 	"github.com/knadh/koanf"
 	"github.com/knadh/koanf/parsers/yaml"
+	"github.com/knadh/koanf/providers/confmap"
 	"github.com/knadh/koanf/providers/file"
+	"github.com/pkg/errors"
+	"go.uber.org/zap/zapcore"
+	"os"
+	"service/internal/entities"
+	"sort"
+	"strings"
+	"time"
 )
 
+// RateLimitRule is one entry of rate_limit.routes.
+type RateLimitRule struct {
+	Route string  `koanf:"route"`
+	By    string  `koanf:"by"`
+	Rate  float64 `koanf:"rate"`
+	Burst int     `koanf:"burst"`
+}
+
 type Config struct {
-	cfg *koanf.Koanf
+	cfg    *koanf.Koanf
+	path   string
+	lookup func(string) (string, bool)
 }
 
+// NewConfig layers defaults, the YAML file (optional) and BALANCE_* environment
+// variables, then validates the result and reports every problem at once.
 func NewConfig(configPath string) (*Config, error) {
+	return newConfig(configPath, os.LookupEnv)
+}
+
+func newConfig(configPath string, lookup func(string) (string, bool)) (*Config, error) {
 	cfg := koanf.New(".")
 
-	err := cfg.Load(file.Provider(configPath), yaml.Parser())
-	if err != nil {
+	if err := cfg.Load(confmap.Provider(defaults(), "."), nil); err != nil {
+		return nil, err
+	}
+
+	if configPath != "" {
+		if err := cfg.Load(file.Provider(configPath), yaml.Parser()); err != nil {
+			return nil, err
+		}
+	}
+
+	overrides, problems := envOverrides(lookup)
+
+	if err := cfg.Load(confmap.Provider(overrides, "."), nil); err != nil {
 		return nil, err
 	}
 
-	return &Config{
-		cfg: cfg,
-	}, nil
+	c := &Config{
+		cfg:    cfg,
+		path:   configPath,
+		lookup: lookup,
+	}
+
+	problems = append(problems, c.validate()...)
+
+	if len(problems) != 0 {
+		return nil, errors.WithMessage(entities.ErrInvalidParam,
+			fmt.Sprintf("invalid config:\n  %s", strings.Join(problems, "\n  ")))
+	}
+
+	return c, nil
+}
+
+func (c *Config) validate() []string {
+	problems := make([]string, 0)
+
+	add := func(key, format string, args ...interface{}) {
+		problems = append(problems, key+": "+fmt.Sprintf(format, args...))
+	}
+
+	switch driver := c.StorageDriver(); driver {
+	case "postgres":
+		if c.PostgresDSN() == "" {
+			add("storage.postgres", "is required (set %s or %s)",
+				envName("storage.postgres"), envName("storage.postgres")+envFileSuffix)
+		}
+	case "sqlite":
+		if c.SQLitePath() == "" {
+			add("storage.sqlite", "is required for sqlite driver")
+		}
+	case "sharded":
+		shards := len(c.StorageShards())
+		if shards == 0 {
+			add("storage.shards", "is required for sharded driver")
+		}
+
+		if previous := c.StorageShardingPreviousShards(); previous < 0 || (shards > 0 && previous >= shards) {
+			add("storage.sharding.previous_shards", "must be in 0..%d, got %d", shards-1, previous)
+		}
+
+		if nodes := c.StorageShardingVirtualNodes(); nodes < 1 {
+			add("storage.sharding.virtual_nodes", "must be positive, got %d", nodes)
+		}
+	case "memory":
+	default:
+		add("storage.driver", "must be postgres, sharded, sqlite or memory, got %q", driver)
+	}
+
+	for operation, level := range c.StorageIsolation() {
+		switch level {
+		case "read_committed", "repeatable_read", "serializable":
+		default:
+			add("storage.isolation."+operation, "must be read_committed, repeatable_read or serializable, got %q", level)
+		}
+	}
+
+	if lag := c.StorageReplicaMaxLag(); lag <= 0 {
+		add("storage.replica_max_lag", "must be a positive duration, got %q", c.cfg.String("storage.replica_max_lag"))
+	}
+
+	if attempts := c.StorageRetryMaxAttempts(); attempts < 1 {
+		add("storage.retry.max_attempts", "must be positive, got %d", attempts)
+	}
+
+	if c.StorageRetryBaseDelay() < 0 || c.StorageRetryMaxDelay() < c.StorageRetryBaseDelay() {
+		add("storage.retry.max_delay", "must not be less than base_delay")
+	}
+
+	maxConns, minConns := c.StoragePoolMaxConns(), c.StoragePoolMinConns()
+	if maxConns < 0 {
+		add("storage.pool.max_conns", "must not be negative, got %d", maxConns)
+	}
+
+	if minConns < 0 || (maxConns > 0 && minConns > maxConns) {
+		add("storage.pool.min_conns", "must be in 0..max_conns, got %d", minConns)
+	}
+
+	for _, key := range []string{
+		"storage.pool.max_conn_lifetime",
+		"storage.pool.max_conn_idle_time",
+		"storage.pool.health_check_period",
+		"storage.pool.connect_timeout",
+		"storage.statement_timeout",
+		"storage.query_timeout",
+		"storage.cache.ttl",
+	} {
+		if c.cfg.Duration(key) < 0 {
+			add(key, "must not be negative, got %q", c.cfg.String(key))
+		}
+	}
+
+	// operations of months without partition can not be created
+	if period := c.StoragePartitionsCheckPeriod(); period <= 0 {
+		add("storage.partitions.check_period", "must be a positive duration, got %q",
+			c.cfg.String("storage.partitions.check_period"))
+	}
+
+	for _, key := range []string{
+		"storage.partitions.premake",
+		"storage.partitions.retention_months",
+		"storage.cache.size",
+	} {
+		if value := c.cfg.Int(key); value < 0 {
+			add(key, "must not be negative, got %d", value)
+		}
+	}
+
+	if driver := c.StorageDriver(); driver != "postgres" && driver != "sharded" && c.RateLimitBackend() == "postgres" {
+		add("rate_limit.backend", "postgres backend requires postgres or sharded storage driver")
+	}
+
+	if port := c.ServerPort(); port < 1 || port > 65535 {
+		add("server.port", "must be in 1..65535, got %d", port)
+	}
+
+	if port := c.GRPCPort(); port < 0 || port > 65535 {
+		add("grpc.port", "must be in 0..65535, got %d", port)
+	}
+
+	if _, err := zapcore.ParseLevel(c.LogLevel()); err != nil {
+		add("log.level", "%s", err)
+	}
+
+	if size := c.OperationsPageSize(); size < 1 {
+		add("limits.operations_page_size", "must be positive, got %d", size)
+	}
+
+	if size := c.OperationsMaxPageSize(); size < 0 || (size != 0 && size < c.OperationsPageSize()) {
+		add("limits.operations_max_page_size", "must be 0 or not less than operations_page_size, got %d", size)
+	}
+
+	if timeout := c.ServerShutdownTimeout(); timeout <= 0 {
+		add("server.shutdown_timeout", "must be a positive duration, got %q",
+			c.cfg.String("server.shutdown_timeout"))
+	}
+
+	if delay := c.ServerDrainDelay(); delay < 0 || delay >= c.ServerShutdownTimeout() {
+		add("server.drain_delay", "must be non-negative and less than shutdown_timeout, got %q",
+			c.cfg.String("server.drain_delay"))
+	}
+
+	if c.TLSCertFile() != "" && c.TLSKeyFile() == "" {
+		add("server.tls.key_file", "is required when cert_file is set")
+	}
+
+	if c.TLSCertFile() == "" && (c.TLSClientCAFile() != "" || c.TLSRequireClientCert()) {
+		add("server.tls.cert_file", "is required when client certificates are verified")
+	}
+
+	if c.TLSRequireClientCert() && c.TLSClientCAFile() == "" {
+		add("server.tls.client_ca_file", "is required when require_client_cert is set")
+	}
+
+	for _, p := range c.TLSClientPermissions() {
+		if _, err := entities.ParsePermission(p); err != nil {
+			add("server.tls.client_permissions", "unknown permission %q", p)
+		}
+	}
+
+	switch exporter := c.TracingExporter(); exporter {
+	case "none", "stdout", "otlp":
+	case "file":
+		if c.TracingFile() == "" {
+			add("tracing.file", "is required for file exporter")
+		}
+	default:
+		add("tracing.exporter", "must be one of none, stdout, file, otlp, got %q", exporter)
+	}
+
+	if ratio := c.TracingSampleRatio(); ratio < 0 || ratio > 1 {
+		add("tracing.sample_ratio", "must be in 0..1, got %v", ratio)
+	}
+
+	switch algorithm := c.JWTAlgorithm(); algorithm {
+	case "":
+	case "HS256":
+		if c.JWTSecret() == "" {
+			add("auth.jwt.secret", "is required for HS256")
+		}
+	case "RS256":
+		if c.JWTPublicKeyFile() == "" && c.JWTJWKSFile() == "" {
+			add("auth.jwt.public_key_file", "or auth.jwt.jwks_file is required for RS256")
+		}
+	default:
+		add("auth.jwt.algorithm", "must be empty, HS256 or RS256, got %q", algorithm)
+	}
+
+	switch backend := c.RateLimitBackend(); backend {
+	case "memory", "postgres":
+	default:
+		add("rate_limit.backend", "must be memory or postgres, got %q", backend)
+	}
+
+	switch onError := c.RateLimitOnError(); onError {
+	case "open", "closed":
+	default:
+		add("rate_limit.on_error", "must be open or closed, got %q", onError)
+	}
+
+	rules, err := c.RateLimitRules()
+	if err != nil {
+		add("rate_limit.routes", "%s", err)
+	}
+
+	for i, rule := range rules {
+		key := fmt.Sprintf("rate_limit.routes[%d]", i)
+
+		if rule.Route == "" {
+			add(key, "route is required")
+		}
+
+		if rule.By != "client" && rule.By != "user" {
+			add(key, "by must be client or user, got %q", rule.By)
+		}
+
+		if rule.Rate <= 0 {
+			add(key, "rate must be positive, got %v", rule.Rate)
+		}
+
+		if rule.Burst <= 0 {
+			add(key, "burst must be positive, got %d", rule.Burst)
+		}
+	}
+
+	return problems
+}
+
+// Watch reloads the config file on change and passes the validated config to cb,
+// or the error if the new config is invalid. The watch lives until the process exits.
+func (c *Config) Watch(cb func(next *Config, err error)) error {
+	if c.path == "" {
+		return errors.WithMessage(entities.ErrInvalidParam, "config is not loaded from file")
+	}
+
+	return file.Provider(c.path).Watch(func(_ interface{}, err error) {
+		if err != nil {
+			cb(nil, err)
+			return
+		}
+
+		cb(newConfig(c.path, c.lookup))
+	})
+}
+
+// Diff lists keys changed in next as "key: old -> new", secrets are masked.
+func (c *Config) Diff(next *Config) []string {
+	prev, curr := c.cfg.All(), next.cfg.All()
+
+	keys := make([]string, 0, len(curr))
+	for key := range curr {
+		keys = append(keys, key)
+	}
+	for key := range prev {
+		if _, ok := curr[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	changes := make([]string, 0)
+
+	for _, key := range keys {
+		old, ok := prev[key]
+		oldValue := "<unset>"
+		if ok {
+			oldValue = fmt.Sprint(old)
+		}
+
+		value, ok := curr[key]
+		newValue := "<unset>"
+		if ok {
+			newValue = fmt.Sprint(value)
+		}
+
+		if oldValue == newValue {
+			continue
+		}
+
+		if secretKeys[key] {
+			oldValue, newValue = "***", "***"
+		}
+
+		changes = append(changes, fmt.Sprintf("%s: %s -> %s", key, oldValue, newValue))
+	}
+
+	return changes
 }
 
 func (c *Config) PostgresDSN() string {
 	return c.cfg.String("storage.postgres")
 }
 
+// StorageDriver is postgres, sharded, sqlite or memory.
+func (c *Config) StorageDriver() string {
+	return c.cfg.String("storage.driver")
+}
+
+// SQLitePath is database file of sqlite driver.
+func (c *Config) SQLitePath() string {
+	return c.cfg.String("storage.sqlite")
+}
+
+// StorageReplicas are DSNs of postgres read replicas.
+func (c *Config) StorageReplicas() []string {
+	return c.cfg.Strings("storage.replicas")
+}
+
+// StorageShards are DSNs of postgres shards of sharded driver, in ring order.
+func (c *Config) StorageShards() []string {
+	return c.cfg.Strings("storage.shards")
+}
+
+// StorageShardingPreviousShards is the number of shards before the last
+// ones were added, zero when users are not being rebalanced.
+func (c *Config) StorageShardingPreviousShards() int {
+	return c.cfg.Int("storage.sharding.previous_shards")
+}
+
+// StorageShardingVirtualNodes is the number of ring nodes per shard.
+func (c *Config) StorageShardingVirtualNodes() int {
+	return c.cfg.Int("storage.sharding.virtual_nodes")
+}
+
+// StorageReplicaMaxLag excludes lagging replicas from reads.
+func (c *Config) StorageReplicaMaxLag() time.Duration {
+	return c.cfg.Duration("storage.replica_max_lag")
+}
+
+// StorageIsolation maps postgres operation (credit, reserve, commit, rate_limit) to isolation level.
+func (c *Config) StorageIsolation() map[string]string {
+	return c.cfg.StringMap("storage.isolation")
+}
+
+func (c *Config) StorageRetryMaxAttempts() int {
+	return c.cfg.Int("storage.retry.max_attempts")
+}
+
+func (c *Config) StorageRetryBaseDelay() time.Duration {
+	return c.cfg.Duration("storage.retry.base_delay")
+}
+
+func (c *Config) StorageRetryMaxDelay() time.Duration {
+	return c.cfg.Duration("storage.retry.max_delay")
+}
+
+// StoragePoolMaxConns and StoragePoolMinConns size postgres pools, zero keeps pgx defaults.
+func (c *Config) StoragePoolMaxConns() int {
+	return c.cfg.Int("storage.pool.max_conns")
+}
+
+func (c *Config) StoragePoolMinConns() int {
+	return c.cfg.Int("storage.pool.min_conns")
+}
+
+func (c *Config) StoragePoolMaxConnLifetime() time.Duration {
+	return c.cfg.Duration("storage.pool.max_conn_lifetime")
+}
+
+func (c *Config) StoragePoolMaxConnIdleTime() time.Duration {
+	return c.cfg.Duration("storage.pool.max_conn_idle_time")
+}
+
+func (c *Config) StoragePoolHealthCheckPeriod() time.Duration {
+	return c.cfg.Duration("storage.pool.health_check_period")
+}
+
+func (c *Config) StoragePoolConnectTimeout() time.Duration {
+	return c.cfg.Duration("storage.pool.connect_timeout")
+}
+
+// StorageStatementTimeout is postgres statement_timeout, zero disables it.
+func (c *Config) StorageStatementTimeout() time.Duration {
+	return c.cfg.Duration("storage.statement_timeout")
+}
+
+// StorageQueryTimeout is the default deadline of storage calls, zero disables it.
+func (c *Config) StorageQueryTimeout() time.Duration {
+	return c.cfg.Duration("storage.query_timeout")
+}
+
+// StoragePartitionsPremake is the number of months ahead to create operations partitions for.
+func (c *Config) StoragePartitionsPremake() int {
+	return c.cfg.Int("storage.partitions.premake")
+}
+
+// StoragePartitionsRetentionMonths is the number of closed months kept in
+// live operations, zero disables archival.
+func (c *Config) StoragePartitionsRetentionMonths() int {
+	return c.cfg.Int("storage.partitions.retention_months")
+}
+
+// StoragePartitionsCheckPeriod of partition maintenance.
+func (c *Config) StoragePartitionsCheckPeriod() time.Duration {
+	return c.cfg.Duration("storage.partitions.check_period")
+}
+
+// StorageCacheSize is the number of balances cached in process, zero disables the cache.
+func (c *Config) StorageCacheSize() int {
+	return c.cfg.Int("storage.cache.size")
+}
+
+// StorageCacheTTL bounds staleness of cached balances, zero keeps them until invalidated.
+func (c *Config) StorageCacheTTL() time.Duration {
+	return c.cfg.Duration("storage.cache.ttl")
+}
+
+// AutoMigrate applies pending migrations on startup.
+func (c *Config) AutoMigrate() bool {
+	return c.cfg.Bool("storage.auto_migrate")
+}
+
 func (c *Config) ServerPort() int {
 	return c.cfg.Int("server.port")
 }
+
+func (c *Config) ServerShutdownTimeout() time.Duration {
+	return c.cfg.Duration("server.shutdown_timeout")
+}
+
+// ServerDrainDelay is how long servers keep serving after readiness fails on shutdown.
+func (c *Config) ServerDrainDelay() time.Duration {
+	return c.cfg.Duration("server.drain_delay")
+}
+
+func (c *Config) AdminKey() string {
+	return c.cfg.String("auth.admin_key")
+}
+
+func (c *Config) JWTAlgorithm() string {
+	return c.cfg.String("auth.jwt.algorithm")
+}
+
+func (c *Config) JWTSecret() string {
+	return c.cfg.String("auth.jwt.secret")
+}
+
+func (c *Config) JWTPublicKeyFile() string {
+	return c.cfg.String("auth.jwt.public_key_file")
+}
+
+func (c *Config) JWTJWKSFile() string {
+	return c.cfg.String("auth.jwt.jwks_file")
+}
+
+func (c *Config) JWTIssuer() string {
+	return c.cfg.String("auth.jwt.issuer")
+}
+
+func (c *Config) JWTAudience() string {
+	return c.cfg.String("auth.jwt.audience")
+}
+
+func (c *Config) RateLimitBackend() string {
+	return c.cfg.String("rate_limit.backend")
+}
+
+// RateLimitOnError is open to let requests through when limiter fails, closed to reject them.
+func (c *Config) RateLimitOnError() string {
+	return c.cfg.String("rate_limit.on_error")
+}
+
+func (c *Config) RateLimitRules() ([]RateLimitRule, error) {
+	var rules []RateLimitRule
+
+	if err := c.cfg.Unmarshal("rate_limit.routes", &rules); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func (c *Config) TLSCertFile() string {
+	return c.cfg.String("server.tls.cert_file")
+}
+
+func (c *Config) TLSKeyFile() string {
+	return c.cfg.String("server.tls.key_file")
+}
+
+func (c *Config) TLSClientCAFile() string {
+	return c.cfg.String("server.tls.client_ca_file")
+}
+
+func (c *Config) TLSRequireClientCert() bool {
+	return c.cfg.Bool("server.tls.require_client_cert")
+}
+
+func (c *Config) TLSClientPermissions() []string {
+	return c.cfg.Strings("server.tls.client_permissions")
+}
+
+func (c *Config) GRPCPort() int {
+	return c.cfg.Int("grpc.port")
+}
+
+func (c *Config) TracingExporter() string {
+	return c.cfg.String("tracing.exporter")
+}
+
+func (c *Config) TracingFile() string {
+	return c.cfg.String("tracing.file")
+}
+
+func (c *Config) TracingEndpoint() string {
+	return c.cfg.String("tracing.endpoint")
+}
+
+func (c *Config) TracingSampleRatio() float64 {
+	return c.cfg.Float64("tracing.sample_ratio")
+}
+
+func (c *Config) LogLevel() string {
+	return c.cfg.String("log.level")
+}
+
+func (c *Config) OperationsPageSize() int {
+	return c.cfg.Int("limits.operations_page_size")
+}
+
+// OperationsMaxPageSize is the largest page of operations, 0 is unlimited.
+func (c *Config) OperationsMaxPageSize() int {
+	return c.cfg.Int("limits.operations_max_page_size")
+}
+
+// Feature reports whether features.<name> toggle is on.
+func (c *Config) Feature(name string) bool {
+	return c.cfg.Bool("features." + name)
+}
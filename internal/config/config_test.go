@@ -0,0 +1,272 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"service/internal/adapters/storage/postgres"
+	"strings"
+	"testing"
+	"time"
+)
+
+func env(values map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := values[name]
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestNewConfig_Defaults(t *testing.T) {
+	cfg, err := newConfig("", env(map[string]string{
+		"BALANCE_STORAGE_POSTGRES": "postgres://localhost/service",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.ServerPort() != 8080 {
+		t.Errorf("port = %d, want 8080", cfg.ServerPort())
+	}
+	if cfg.ServerShutdownTimeout() != 15*time.Second {
+		t.Errorf("shutdown timeout = %s, want 15s", cfg.ServerShutdownTimeout())
+	}
+	if cfg.ServerDrainDelay() != 5*time.Second {
+		t.Errorf("drain delay = %s, want 5s", cfg.ServerDrainDelay())
+	}
+	if cfg.RateLimitOnError() != "open" {
+		t.Errorf("rate limit on error = %q, want open", cfg.RateLimitOnError())
+	}
+	if cfg.TracingExporter() != "none" || cfg.RateLimitBackend() != "memory" {
+		t.Errorf("exporter = %q, backend = %q", cfg.TracingExporter(), cfg.RateLimitBackend())
+	}
+}
+
+func TestNewConfig_EnvOverridesFile(t *testing.T) {
+	path := writeFile(t, "service.yml", `
+storage:
+  postgres: postgres://file/service
+server:
+  port: 8081
+  tls:
+    client_permissions: [read]
+`)
+	secret := writeFile(t, "dsn", "postgres://secret/service\n")
+
+	cfg, err := newConfig(path, env(map[string]string{
+		"BALANCE_STORAGE_POSTGRES_FILE":         secret,
+		"BALANCE_SERVER_PORT":                   "9000",
+		"BALANCE_SERVER_TLS_CLIENT_PERMISSIONS": "credit, read",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.PostgresDSN() != "postgres://secret/service" {
+		t.Errorf("dsn = %q", cfg.PostgresDSN())
+	}
+	if cfg.ServerPort() != 9000 {
+		t.Errorf("port = %d, want 9000", cfg.ServerPort())
+	}
+	if got := strings.Join(cfg.TLSClientPermissions(), ","); got != "credit,read" {
+		t.Errorf("permissions = %q", got)
+	}
+}
+
+func TestNewConfig_ReportsAllProblems(t *testing.T) {
+	path := writeFile(t, "service.yml", `
+server:
+  tls:
+    cert_file: cert.pem
+tracing:
+  exporter: jaeger
+rate_limit:
+  routes:
+    - route: "*"
+      by: ip
+      rate: 1
+      burst: 1
+`)
+
+	_, err := newConfig(path, env(map[string]string{
+		"BALANCE_SERVER_PORT":          "http",
+		"BALANCE_AUTH_JWT_SECRET":      "a",
+		"BALANCE_AUTH_JWT_SECRET_FILE": "b",
+	}))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	for _, key := range []string{
+		"storage.postgres",
+		"server.port",
+		"server.tls.key_file",
+		"tracing.exporter",
+		"auth.jwt.secret",
+		"rate_limit.routes[0]",
+	} {
+		if !strings.Contains(err.Error(), key+":") {
+			t.Errorf("error does not mention %s:\n%s", key, err)
+		}
+	}
+}
+
+func TestConfig_Diff(t *testing.T) {
+	lookup := env(map[string]string{"BALANCE_STORAGE_POSTGRES": "postgres://old/service"})
+
+	prev, err := newConfig("", lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := newConfig("", env(map[string]string{
+		"BALANCE_STORAGE_POSTGRES": "postgres://new/service",
+		"BALANCE_LOG_LEVEL":        "debug",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := strings.Join(prev.Diff(next), "\n")
+	want := "log.level: info -> debug\nstorage.postgres: *** -> ***"
+
+	if got != want {
+		t.Errorf("diff =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestConfig_Watch(t *testing.T) {
+	path := writeFile(t, "service.yml", "storage:\n  postgres: postgres://localhost/service\n")
+
+	cfg, err := newConfig(path, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type reload struct {
+		cfg *Config
+		err error
+	}
+
+	reloads := make(chan reload, 10)
+
+	err = cfg.Watch(func(next *Config, err error) {
+		reloads <- reload{next, err}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := func() reload {
+		select {
+		case r := <-reloads:
+			return r
+		case <-time.After(5 * time.Second):
+			t.Fatal("config was not reloaded")
+			return reload{}
+		}
+	}
+
+	writeConfig := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeConfig("storage:\n  postgres: postgres://localhost/service\nlog:\n  level: loud\n")
+
+	for {
+		r := next()
+		if r.err == nil {
+			t.Fatal("invalid config was accepted")
+		}
+
+		if strings.Contains(r.err.Error(), "log.level") {
+			break
+		}
+	}
+
+	// koanf drops identical events within 5ms, a single write may also fire
+	// several events, so pause and then wait for the valid one
+	time.Sleep(20 * time.Millisecond)
+	writeConfig("storage:\n  postgres: postgres://localhost/service\nlog:\n  level: warn\n")
+
+	for {
+		r := next()
+		if r.err != nil {
+			continue
+		}
+
+		if r.cfg.LogLevel() != "warn" {
+			t.Fatalf("log level = %q, want warn", r.cfg.LogLevel())
+		}
+
+		break
+	}
+}
+
+func TestNewConfig_Sharded(t *testing.T) {
+	cfg, err := newConfig("", env(map[string]string{
+		"BALANCE_STORAGE_DRIVER":                   "sharded",
+		"BALANCE_STORAGE_SHARDS":                   "postgres://a/service,postgres://b/service",
+		"BALANCE_STORAGE_SHARDING_PREVIOUS_SHARDS": "1",
+		"BALANCE_RATE_LIMIT_BACKEND":               "postgres",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cfg.StorageShards()) != 2 || cfg.StorageShardingVirtualNodes() != 128 {
+		t.Errorf("shards = %v, virtual nodes = %d", cfg.StorageShards(), cfg.StorageShardingVirtualNodes())
+	}
+
+	_, err = newConfig("", env(map[string]string{
+		"BALANCE_STORAGE_DRIVER":                   "sharded",
+		"BALANCE_STORAGE_SHARDS":                   "postgres://a/service",
+		"BALANCE_STORAGE_SHARDING_PREVIOUS_SHARDS": "1",
+	}))
+	if err == nil || !strings.Contains(err.Error(), "storage.sharding.previous_shards:") {
+		t.Errorf("previous shards out of range: %v", err)
+	}
+}
+
+// TestNewConfig_StorageDefaults keeps defaults of the service and of callers
+// building postgres.Options directly the same.
+func TestNewConfig_StorageDefaults(t *testing.T) {
+	cfg, err := newConfig("", env(map[string]string{
+		"BALANCE_STORAGE_POSTGRES": "postgres://localhost/service",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := postgres.DefaultOptions()
+
+	for _, tc := range []struct {
+		key       string
+		got, want interface{}
+	}{
+		{"storage.query_timeout", cfg.StorageQueryTimeout(), opts.QueryTimeout},
+		{"storage.retry.max_attempts", cfg.StorageRetryMaxAttempts(), opts.Retry.MaxAttempts},
+		{"storage.retry.base_delay", cfg.StorageRetryBaseDelay(), opts.Retry.BaseDelay},
+		{"storage.retry.max_delay", cfg.StorageRetryMaxDelay(), opts.Retry.MaxDelay},
+		{"storage.pool.connect_timeout", cfg.StoragePoolConnectTimeout(), opts.Pool.ConnectTimeout},
+		{"storage.replica_max_lag", cfg.StorageReplicaMaxLag(), opts.ReplicaMaxLag},
+		{"storage.partitions.premake", cfg.StoragePartitionsPremake(), opts.Partitions.Premake},
+		{"storage.partitions.check_period", cfg.StoragePartitionsCheckPeriod(), opts.Partitions.CheckPeriod},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s = %v, postgres default %v", tc.key, tc.got, tc.want)
+		}
+	}
+}
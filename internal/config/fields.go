@@ -0,0 +1,188 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	envPrefix     = "BALANCE_"
+	envFileSuffix = "_FILE"
+)
+
+type kind int
+
+const (
+	kindString kind = iota
+	kindInt
+	kindBool
+	kindFloat
+	kindDuration
+	kindStrings
+)
+
+// field is a scalar config key which can be overridden from environment as
+// BALANCE_<KEY> with dots replaced by underscores, e.g. BALANCE_STORAGE_POSTGRES,
+// or read from file named by BALANCE_<KEY>_FILE.
+type field struct {
+	key  string
+	kind kind
+	def  interface{}
+}
+
+var fields = []field{
+	{key: "storage.driver", kind: kindString, def: "postgres"},
+	{key: "storage.postgres", kind: kindString},
+	{key: "storage.sqlite", kind: kindString, def: "balance.db"},
+	{key: "storage.replicas", kind: kindStrings},
+	{key: "storage.shards", kind: kindStrings},
+	{key: "storage.sharding.previous_shards", kind: kindInt},
+	{key: "storage.sharding.virtual_nodes", kind: kindInt, def: 128},
+	{key: "storage.replica_max_lag", kind: kindDuration, def: "5s"},
+	{key: "storage.isolation.credit", kind: kindString, def: "read_committed"},
+	{key: "storage.isolation.reserve", kind: kindString, def: "read_committed"},
+	{key: "storage.isolation.commit", kind: kindString, def: "read_committed"},
+	{key: "storage.isolation.rate_limit", kind: kindString, def: "read_committed"},
+	{key: "storage.retry.max_attempts", kind: kindInt, def: 3},
+	{key: "storage.retry.base_delay", kind: kindDuration, def: "10ms"},
+	{key: "storage.retry.max_delay", kind: kindDuration, def: "200ms"},
+	{key: "storage.auto_migrate", kind: kindBool},
+	{key: "storage.pool.max_conns", kind: kindInt},
+	{key: "storage.pool.min_conns", kind: kindInt},
+	{key: "storage.pool.max_conn_lifetime", kind: kindDuration, def: "1h"},
+	{key: "storage.pool.max_conn_idle_time", kind: kindDuration, def: "30m"},
+	{key: "storage.pool.health_check_period", kind: kindDuration, def: "1m"},
+	{key: "storage.pool.connect_timeout", kind: kindDuration, def: "5s"},
+	{key: "storage.statement_timeout", kind: kindDuration, def: "5s"},
+	{key: "storage.query_timeout", kind: kindDuration, def: "10s"},
+	{key: "storage.partitions.premake", kind: kindInt, def: 2},
+	{key: "storage.partitions.retention_months", kind: kindInt},
+	{key: "storage.partitions.check_period", kind: kindDuration, def: "1h"},
+	{key: "storage.cache.size", kind: kindInt, def: 10000},
+	{key: "storage.cache.ttl", kind: kindDuration, def: "1m"},
+	{key: "server.port", kind: kindInt, def: 8080},
+	{key: "server.shutdown_timeout", kind: kindDuration, def: "15s"},
+	{key: "server.drain_delay", kind: kindDuration, def: "5s"},
+	{key: "server.tls.cert_file", kind: kindString},
+	{key: "server.tls.key_file", kind: kindString},
+	{key: "server.tls.client_ca_file", kind: kindString},
+	{key: "server.tls.require_client_cert", kind: kindBool},
+	{key: "server.tls.client_permissions", kind: kindStrings, def: []string{"credit", "reserve", "commit", "read"}},
+	{key: "grpc.port", kind: kindInt},
+	{key: "tracing.exporter", kind: kindString, def: "none"},
+	{key: "tracing.file", kind: kindString},
+	{key: "tracing.endpoint", kind: kindString},
+	{key: "tracing.sample_ratio", kind: kindFloat, def: 1.0},
+	{key: "auth.admin_key", kind: kindString},
+	{key: "auth.jwt.algorithm", kind: kindString},
+	{key: "auth.jwt.secret", kind: kindString},
+	{key: "auth.jwt.public_key_file", kind: kindString},
+	{key: "auth.jwt.jwks_file", kind: kindString},
+	{key: "auth.jwt.issuer", kind: kindString},
+	{key: "auth.jwt.audience", kind: kindString},
+	{key: "rate_limit.backend", kind: kindString, def: "memory"},
+	{key: "rate_limit.on_error", kind: kindString, def: "open"},
+	{key: "log.level", kind: kindString, def: "info"},
+	{key: "limits.operations_page_size", kind: kindInt, def: 10},
+	{key: "limits.operations_max_page_size", kind: kindInt},
+	{key: "features.balance_events", kind: kindBool, def: true},
+}
+
+// secretKeys are masked in config diffs.
+var secretKeys = map[string]bool{
+	"storage.postgres": true,
+	"storage.replicas": true,
+	"storage.shards":   true,
+	"auth.admin_key":   true,
+	"auth.jwt.secret":  true,
+}
+
+func envName(key string) string {
+	return envPrefix + strings.ToUpper(strings.NewReplacer(".", "_").Replace(key))
+}
+
+func defaults() map[string]interface{} {
+	values := make(map[string]interface{})
+
+	for _, f := range fields {
+		if f.def != nil {
+			values[f.key] = f.def
+		}
+	}
+
+	return values
+}
+
+// envOverrides reads BALANCE_* variables of known keys. Unknown variables are
+// ignored, invalid values are reported.
+func envOverrides(lookup func(string) (string, bool)) (map[string]interface{}, []string) {
+	values := make(map[string]interface{})
+	errs := make([]string, 0)
+
+	for _, f := range fields {
+		name := envName(f.key)
+
+		raw, ok := lookup(name)
+
+		if path, fromFile := lookup(name + envFileSuffix); fromFile {
+			if ok {
+				errs = append(errs, fmt.Sprintf("%s: both %s and %s are set", f.key, name, name+envFileSuffix))
+				continue
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", f.key, err))
+				continue
+			}
+
+			raw, ok = strings.TrimRight(string(data), "\r\n"), true
+		}
+
+		if !ok {
+			continue
+		}
+
+		value, err := parse(f.kind, raw)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: invalid value from %s: %s", f.key, name, err))
+			continue
+		}
+
+		values[f.key] = value
+	}
+
+	return values, errs
+}
+
+func parse(k kind, raw string) (interface{}, error) {
+	switch k {
+	case kindInt:
+		return strconv.Atoi(raw)
+	case kindBool:
+		return strconv.ParseBool(raw)
+	case kindFloat:
+		return strconv.ParseFloat(raw, 64)
+	case kindDuration:
+		if _, err := time.ParseDuration(raw); err != nil {
+			return nil, err
+		}
+
+		return raw, nil
+	case kindStrings:
+		values := make([]string, 0)
+
+		for _, v := range strings.Split(raw, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+
+		return values, nil
+	}
+
+	return raw, nil
+}
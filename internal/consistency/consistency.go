@@ -0,0 +1,75 @@
+// Package consistency carries read-your-writes tokens in context. Storage
+// records a token after a mutation, the token passed back with a read makes
+// storage serve it from a replica that has caught up or from the primary.
+package consistency
+
+import (
+	"context"
+	"sync"
+)
+
+type tokenKey struct{}
+
+type recorderKey struct{}
+
+type primaryKey struct{}
+
+// Recorder keeps the token of the last mutation of a request.
+type Recorder struct {
+	mu    sync.Mutex
+	token string
+}
+
+func (r *Recorder) Token() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.token
+}
+
+// WithToken stores token a read must be consistent with.
+func WithToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, tokenKey{}, token)
+}
+
+func Token(ctx context.Context) string {
+	token, _ := ctx.Value(tokenKey{}).(string)
+	return token
+}
+
+// WithPrimary marks reads of ctx as part of a mutation, storage serves them
+// from the primary as a replica may not have the data the mutation checks yet.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// Primary reports whether reads of ctx must be served from the primary.
+func Primary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryKey{}).(bool)
+	return primary
+}
+
+// WithRecorder returns ctx in which storage records tokens of mutations.
+func WithRecorder(ctx context.Context) (context.Context, *Recorder) {
+	recorder := &Recorder{}
+	return context.WithValue(ctx, recorderKey{}, recorder), recorder
+}
+
+// Recording reports whether someone waits for a token, so storage can skip computing it.
+func Recording(ctx context.Context) bool {
+	_, ok := ctx.Value(recorderKey{}).(*Recorder)
+	return ok
+}
+
+// Record stores token of a mutation, it is a no-op without recorder.
+func Record(ctx context.Context, token string) {
+	recorder, ok := ctx.Value(recorderKey{}).(*Recorder)
+	if !ok {
+		return
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	recorder.token = token
+}
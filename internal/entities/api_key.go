@@ -0,0 +1,69 @@
+package entities
+
+import (
+	"github.com/pkg/errors"
+	"time"
+)
+
+// Permission is an operation API key is allowed to call.
+type Permission string
+
+const (
+	PermissionCredit  Permission = "credit"
+	PermissionReserve Permission = "reserve"
+	PermissionCommit  Permission = "commit"
+	PermissionRead    Permission = "read"
+)
+
+func ParsePermission(value string) (Permission, error) {
+	switch p := Permission(value); p {
+	case PermissionCredit, PermissionReserve, PermissionCommit, PermissionRead:
+		return p, nil
+	}
+
+	return "", errors.WithMessagef(ErrInvalidParam, "unknown permission %q", value)
+}
+
+func NewAPIKey(id string, serviceID string, permissions []Permission, createdAt time.Time) *APIKey {
+	return &APIKey{
+		id:          id,
+		serviceID:   serviceID,
+		permissions: permissions,
+		createdAt:   createdAt,
+	}
+}
+
+// APIKey authenticates a service. Only hash of the secret is stored, so the
+// key itself holds no secret.
+type APIKey struct {
+	id          string
+	serviceID   string
+	permissions []Permission
+	createdAt   time.Time
+}
+
+func (k *APIKey) ID() string {
+	return k.id
+}
+
+func (k *APIKey) ServiceID() string {
+	return k.serviceID
+}
+
+func (k *APIKey) Permissions() []Permission {
+	return k.permissions
+}
+
+func (k *APIKey) CreatedAt() time.Time {
+	return k.createdAt
+}
+
+func (k *APIKey) Allows(permission Permission) bool {
+	for _, p := range k.permissions {
+		if p == permission {
+			return true
+		}
+	}
+
+	return false
+}
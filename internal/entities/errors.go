@@ -1,6 +1,9 @@
 package entities
 
-import "github.com/pkg/errors"
+import (
+	"github.com/pkg/errors"
+	"service/pkg/errcodes"
+)
 
 var (
 	ErrInvalidParam         = errors.New("invalid param")
@@ -8,5 +11,46 @@ var (
 	ErrReserveInvalidValue  = errors.New("reserve invalid value")
 	ErrCommitInvalidValue   = errors.New("commit invalid value")
 	ErrNotFound             = errors.New("not found")
+	ErrUnauthorized         = errors.New("unauthorized")
+	ErrForbidden            = errors.New("forbidden")
+	ErrRateLimited          = errors.New("rate limited")
 	ErrInternal             = errors.New("internal")
 )
+
+// Stable error codes returned to clients, one per error above.
+const (
+	CodeInvalidParam        = errcodes.InvalidParam
+	CodeReserveExists       = errcodes.ReserveExists
+	CodeInsufficientFunds   = errcodes.InsufficientFunds
+	CodeInsufficientReserve = errcodes.InsufficientReserve
+	CodeNotFound            = errcodes.NotFound
+	CodeUnauthorized        = errcodes.Unauthorized
+	CodeForbidden           = errcodes.Forbidden
+	CodeRateLimited         = errcodes.RateLimited
+	CodeInternal            = errcodes.Internal
+)
+
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrInvalidParam, CodeInvalidParam},
+	{ErrReserveAlreadyExists, CodeReserveExists},
+	{ErrReserveInvalidValue, CodeInsufficientFunds},
+	{ErrCommitInvalidValue, CodeInsufficientReserve},
+	{ErrNotFound, CodeNotFound},
+	{ErrUnauthorized, CodeUnauthorized},
+	{ErrForbidden, CodeForbidden},
+	{ErrRateLimited, CodeRateLimited},
+}
+
+// ErrorCode returns code of the error above wrapped by err, CodeInternal otherwise.
+func ErrorCode(err error) string {
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+
+	return CodeInternal
+}
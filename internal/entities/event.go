@@ -0,0 +1,21 @@
+package entities
+
+func NewBalanceEvent(balance *Balance, operation *Operation) *BalanceEvent {
+	return &BalanceEvent{
+		balance:   balance,
+		operation: operation,
+	}
+}
+
+type BalanceEvent struct {
+	balance   *Balance
+	operation *Operation
+}
+
+func (e *BalanceEvent) Balance() *Balance {
+	return e.balance
+}
+
+func (e *BalanceEvent) Operation() *Operation {
+	return e.operation
+}
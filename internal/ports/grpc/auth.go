@@ -0,0 +1,50 @@
+package grpc
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"service/internal/auth"
+	"service/internal/entities"
+	"service/pkg/pb"
+)
+
+const apiKeyMetadata = "x-api-key"
+
+var methodPermissions = map[string]entities.Permission{
+	pb.BalanceService_GetUserBalance_FullMethodName:     entities.PermissionRead,
+	pb.BalanceService_ListOperations_FullMethodName:     entities.PermissionRead,
+	pb.BalanceService_CreditBalance_FullMethodName:      entities.PermissionCredit,
+	pb.BalanceService_ReserveFromBalance_FullMethodName: entities.PermissionReserve,
+	pb.BalanceService_CommitReserve_FullMethodName:      entities.PermissionCommit,
+}
+
+// authenticate resolves x-api-key metadata to the calling service and checks
+// it is allowed to call the method.
+func (s *Server) authenticate(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	var secret string
+	if values := metadata.ValueFromIncomingContext(ctx, apiKeyMetadata); len(values) > 0 {
+		secret = values[0]
+	}
+
+	key, err := s.auth.Authenticate(ctx, secret)
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return nil, toStatus(err)
+	}
+
+	permission, ok := methodPermissions[info.FullMethod]
+	if !ok || !key.Allows(permission) {
+		err = errors.WithMessagef(entities.ErrForbidden, "%s is not allowed", info.FullMethod)
+		s.logger(ctx).Error(err)
+		return nil, toStatus(err)
+	}
+
+	return handler(auth.WithAPIKey(ctx, key), req)
+}
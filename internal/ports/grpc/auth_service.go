@@ -0,0 +1,10 @@
+package grpc
+
+import (
+	"context"
+	"service/internal/entities"
+)
+
+type AuthService interface {
+	Authenticate(ctx context.Context, secret string) (*entities.APIKey, error)
+}
@@ -0,0 +1,42 @@
+package grpc
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"service/internal/consistency"
+	"service/internal/entities"
+)
+
+// consistencyTokenMetadata is returned in header of mutations and passed
+// with reads which must observe them when reads are served by replicas.
+const consistencyTokenMetadata = "x-consistency-token"
+
+// consistency passes client's consistency token of reads to storage and
+// returns token of mutations in response header.
+func (s *Server) consistency(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if methodPermissions[info.FullMethod] == entities.PermissionRead {
+		if values := metadata.ValueFromIncomingContext(ctx, consistencyTokenMetadata); len(values) > 0 && values[0] != "" {
+			ctx = consistency.WithToken(ctx, values[0])
+		}
+
+		return handler(ctx, req)
+	}
+
+	ctx, recorder := consistency.WithRecorder(ctx)
+
+	resp, err := handler(ctx, req)
+
+	if token := recorder.Token(); token != "" {
+		if headerErr := grpc.SetHeader(ctx, metadata.Pairs(consistencyTokenMetadata, token)); headerErr != nil {
+			s.logger(ctx).Error(headerErr)
+		}
+	}
+
+	return resp, err
+}
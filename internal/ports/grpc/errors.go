@@ -0,0 +1,44 @@
+package grpc
+
+import (
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"service/internal/entities"
+)
+
+const errorDomain = "balance.v1"
+
+var statusCodes = map[string]codes.Code{
+	entities.CodeInvalidParam:        codes.InvalidArgument,
+	entities.CodeReserveExists:       codes.AlreadyExists,
+	entities.CodeInsufficientFunds:   codes.FailedPrecondition,
+	entities.CodeInsufficientReserve: codes.FailedPrecondition,
+	entities.CodeNotFound:            codes.NotFound,
+	entities.CodeUnauthorized:        codes.Unauthenticated,
+	entities.CodeForbidden:           codes.PermissionDenied,
+	entities.CodeRateLimited:         codes.ResourceExhausted,
+	entities.CodeInternal:            codes.Internal,
+}
+
+// toStatus carries entities error code in ErrorInfo.Reason.
+func toStatus(err error) error {
+	code := entities.ErrorCode(err)
+
+	message := err.Error()
+	if code == entities.CodeInternal {
+		message = entities.ErrInternal.Error()
+	}
+
+	st := status.New(statusCodes[code], message)
+
+	withDetails, detailsErr := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: code,
+		Domain: errorDomain,
+	})
+	if detailsErr != nil {
+		return st.Err()
+	}
+
+	return withDetails.Err()
+}
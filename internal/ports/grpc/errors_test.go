@@ -0,0 +1,46 @@
+package grpc
+
+import (
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"service/internal/entities"
+	"testing"
+)
+
+func TestToStatus(t *testing.T) {
+	for _, tc := range []struct {
+		err     error
+		code    codes.Code
+		reason  string
+		message string
+	}{
+		{errors.WithMessage(entities.ErrInvalidParam, "empty order id"), codes.InvalidArgument, entities.CodeInvalidParam, "empty order id: invalid param"},
+		{entities.ErrReserveAlreadyExists, codes.AlreadyExists, entities.CodeReserveExists, "order already exists"},
+		{entities.ErrReserveInvalidValue, codes.FailedPrecondition, entities.CodeInsufficientFunds, "reserve invalid value"},
+		{entities.ErrCommitInvalidValue, codes.FailedPrecondition, entities.CodeInsufficientReserve, "commit invalid value"},
+		{entities.ErrNotFound, codes.NotFound, entities.CodeNotFound, "not found"},
+		{entities.ErrUnauthorized, codes.Unauthenticated, entities.CodeUnauthorized, "unauthorized"},
+		{entities.ErrForbidden, codes.PermissionDenied, entities.CodeForbidden, "forbidden"},
+		{entities.ErrRateLimited, codes.ResourceExhausted, entities.CodeRateLimited, "rate limited"},
+		// messages of unknown errors are not leaked to clients
+		{errors.New("connection refused"), codes.Internal, entities.CodeInternal, "internal"},
+	} {
+		st := status.Convert(toStatus(tc.err))
+
+		if st.Code() != tc.code || st.Message() != tc.message {
+			t.Errorf("%v: status %s %q, want %s %q", tc.err, st.Code(), st.Message(), tc.code, tc.message)
+		}
+
+		details := st.Details()
+		if len(details) != 1 {
+			t.Fatalf("%v: details %v, want ErrorInfo", tc.err, details)
+		}
+
+		info, ok := details[0].(*errdetails.ErrorInfo)
+		if !ok || info.GetReason() != tc.reason || info.GetDomain() != errorDomain {
+			t.Errorf("%v: details %v, want reason %s", tc.err, details[0], tc.reason)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package grpc
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"regexp"
+	"service/internal/logging"
+)
+
+const requestIDMetadata = "x-request-id"
+
+// validRequestID limits client supplied ids, so they are safe to log and echo back.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestID takes x-request-id metadata or generates a new one, returns it in
+// response header and puts it with call-scoped logger into context.
+func (s *Server) requestID(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	var id string
+	if values := metadata.ValueFromIncomingContext(ctx, requestIDMetadata); len(values) > 0 {
+		id = values[0]
+	}
+
+	if !validRequestID.MatchString(id) {
+		id = uuid.New().String()
+	}
+
+	log := s.log.With("request_id", id, "method", info.FullMethod)
+	if spanCtx := trace.SpanContextFromContext(ctx); spanCtx.HasTraceID() {
+		log = log.With("trace_id", spanCtx.TraceID().String())
+	}
+
+	if err := grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadata, id)); err != nil {
+		log.Error(err)
+	}
+
+	ctx = logging.WithRequestID(ctx, id)
+	ctx = logging.WithLogger(ctx, log)
+
+	return handler(ctx, req)
+}
+
+func (s *Server) logger(ctx context.Context) *zap.SugaredLogger {
+	return logging.FromContext(ctx, s.log)
+}
@@ -0,0 +1,309 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net"
+	"service/internal/auth"
+	"service/internal/entities"
+	"service/pkg/pb"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	_ pb.BalanceServiceServer = (*Server)(nil)
+)
+
+type Server struct {
+	pb.UnimplementedBalanceServiceServer
+
+	svc      BalanceService
+	auth     AuthService
+	port     int
+	log      *zap.SugaredLogger
+	server   *grpc.Server
+	settings atomic.Pointer[Settings]
+}
+
+func NewServer(log *zap.SugaredLogger, svc BalanceService, auth AuthService, port int) (*Server, error) {
+	if log == nil {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty logger")
+	}
+
+	if svc == nil || svc == BalanceService(nil) {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty service")
+	}
+
+	if auth == nil || auth == AuthService(nil) {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty auth service")
+	}
+
+	if port == 0 {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty port")
+	}
+
+	server := &Server{
+		svc:  svc,
+		auth: auth,
+		port: port,
+		log:  log,
+	}
+
+	settings := DefaultSettings()
+	server.settings.Store(&settings)
+
+	server.server = grpc.NewServer(grpc.ChainUnaryInterceptor(server.requestID, server.authenticate, server.consistency))
+
+	pb.RegisterBalanceServiceServer(server.server, server)
+
+	return server, nil
+}
+
+func (s *Server) Run() {
+	addr := fmt.Sprintf("0.0.0.0:%d", s.port)
+	s.log.Infof("grpc server listen %s", addr)
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		s.log.Fatal(err)
+	}
+
+	if err = s.Serve(lis); err != nil {
+		s.log.Fatal(err)
+	}
+}
+
+// Serve accepts connections on lis until server is shut down.
+func (s *Server) Serve(lis net.Listener) error {
+	err := s.server.Serve(lis)
+	if err != nil && err != grpc.ErrServerStopped {
+		return err
+	}
+
+	return nil
+}
+
+// Shutdown stops accepting connections and waits for in-flight calls until
+// ctx is done, then cancels the rest.
+func (s *Server) Shutdown(ctx context.Context) error {
+	stopped := make(chan struct{})
+
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		return ctx.Err()
+	}
+}
+
+func (s *Server) GetUserBalance(ctx context.Context, req *pb.GetUserBalanceRequest) (*pb.Balance, error) {
+	if req.GetUserId() == "" {
+		err := errors.WithMessage(entities.ErrInvalidParam, "empty balance id")
+		s.logger(ctx).Error(err)
+		return nil, toStatus(err)
+	}
+
+	balance, err := s.svc.GetUserBalance(ctx, req.GetUserId())
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return nil, toStatus(err)
+	}
+
+	return &pb.Balance{
+		UserId:   balance.UserID(),
+		Currency: int64(balance.Value()),
+	}, nil
+}
+
+func (s *Server) CreditBalance(ctx context.Context, req *pb.CreditBalanceRequest) (*emptypb.Empty, error) {
+	if req.GetUserId() == "" {
+		err := errors.WithMessage(entities.ErrInvalidParam, "empty balance id")
+		s.logger(ctx).Error(err)
+		return nil, toStatus(err)
+	}
+
+	if req.GetCurrency() <= 0 {
+		err := errors.WithMessage(entities.ErrInvalidParam, "invalid currency")
+		s.logger(ctx).Error(err)
+		return nil, toStatus(err)
+	}
+
+	err := s.svc.CreditBalance(ctx, req.GetUserId(), entities.Currency(req.GetCurrency()), req.GetIdempotencyKey())
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return nil, toStatus(err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) ReserveFromBalance(ctx context.Context, req *pb.ReserveRequest) (*emptypb.Empty, error) {
+	err := validateOrder(req.GetUserId(), req.GetServiceId(), req.GetOrderId(), req.GetCurrency())
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return nil, toStatus(err)
+	}
+
+	if err = auth.CheckServiceID(ctx, req.GetServiceId()); err != nil {
+		s.logger(ctx).Error(err)
+		return nil, toStatus(err)
+	}
+
+	err = s.svc.ReserveFromBalance(
+		ctx,
+		req.GetUserId(),
+		req.GetServiceId(),
+		req.GetOrderId(),
+		entities.Currency(req.GetCurrency()),
+	)
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return nil, toStatus(err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) CommitReserve(ctx context.Context, req *pb.CommitReserveRequest) (*emptypb.Empty, error) {
+	err := validateOrder(req.GetUserId(), req.GetServiceId(), req.GetOrderId(), req.GetCurrency())
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return nil, toStatus(err)
+	}
+
+	if err = auth.CheckServiceID(ctx, req.GetServiceId()); err != nil {
+		s.logger(ctx).Error(err)
+		return nil, toStatus(err)
+	}
+
+	err = s.svc.CommitReserve(
+		ctx,
+		req.GetUserId(),
+		req.GetServiceId(),
+		req.GetOrderId(),
+		entities.Currency(req.GetCurrency()),
+	)
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return nil, toStatus(err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) ListOperations(
+	ctx context.Context,
+	req *pb.ListOperationsRequest,
+) (*pb.ListOperationsResponse, error) {
+	if req.GetUserId() == "" {
+		err := errors.WithMessage(entities.ErrInvalidParam, "empty operation id")
+		s.logger(ctx).Error(err)
+		return nil, toStatus(err)
+	}
+
+	if req.GetLimit() < 0 || req.GetOffset() < 0 {
+		err := errors.WithMessage(entities.ErrInvalidParam, "invalid limit or offset param")
+		s.logger(ctx).Error(err)
+		return nil, toStatus(err)
+	}
+
+	limit := s.currentSettings().pageSize(int(req.GetLimit()))
+
+	var orderBy string
+	switch req.GetOrderBy() {
+	case pb.OrderBy_ORDER_BY_UNSPECIFIED, pb.OrderBy_ORDER_BY_DATE:
+		orderBy = entities.Date
+	case pb.OrderBy_ORDER_BY_VALUE:
+		orderBy = entities.Value
+	default:
+		err := errors.WithMessage(entities.ErrInvalidParam, "invalid order by param")
+		s.logger(ctx).Error(err)
+		return nil, toStatus(err)
+	}
+
+	desc := true
+	if req.Desc != nil {
+		desc = req.GetDesc()
+	}
+
+	var operations []*entities.Operation
+	var err error
+	if req.From == nil && req.To == nil {
+		operations, err = s.svc.ListOperations(ctx, req.GetUserId(), limit, int(req.GetOffset()), orderBy, desc)
+	} else {
+		var from, to time.Time
+		if req.From != nil {
+			from = req.GetFrom().AsTime()
+		}
+		if req.To != nil {
+			to = req.GetTo().AsTime()
+		}
+
+		operations, err = s.svc.ListOperationsInRange(
+			ctx, req.GetUserId(), from, to, limit, int(req.GetOffset()), orderBy, desc)
+	}
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return nil, toStatus(err)
+	}
+
+	response := &pb.ListOperationsResponse{
+		Operations: make([]*pb.Operation, 0, len(operations)),
+	}
+
+	for _, operation := range operations {
+		response.Operations = append(response.Operations, toOperation(operation))
+	}
+
+	return response, nil
+}
+
+func validateOrder(userID, serviceID, orderID string, currency int64) error {
+	if userID == "" {
+		return errors.WithMessage(entities.ErrInvalidParam, "empty operation id")
+	}
+
+	if currency <= 0 {
+		return errors.WithMessage(entities.ErrInvalidParam, "invalid currency")
+	}
+
+	if serviceID == "" {
+		return errors.WithMessage(entities.ErrInvalidParam, "empty service id")
+	}
+
+	if orderID == "" {
+		return errors.WithMessage(entities.ErrInvalidParam, "empty order id")
+	}
+
+	return nil
+}
+
+func toOperation(operation *entities.Operation) *pb.Operation {
+	var opType pb.OperationType
+	switch operation.OperationType() {
+	case entities.Credit:
+		opType = pb.OperationType_OPERATION_TYPE_CREDIT
+	case entities.Debit:
+		opType = pb.OperationType_OPERATION_TYPE_DEBIT
+	}
+
+	return &pb.Operation{
+		ServiceId:     operation.ServiceID(),
+		OrderId:       operation.OrderID(),
+		OperationType: opType,
+		Value:         int64(operation.Value()),
+		CreatedAt:     timestamppb.New(operation.CreatedAt()),
+	}
+}
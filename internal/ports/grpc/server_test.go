@@ -0,0 +1,322 @@
+package grpc_test
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"service/internal/entities"
+	grpcport "service/internal/ports/grpc"
+	"service/pkg/pb"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeService answers every call and records the last operations limit.
+// With non-nil started and release balance reads signal start and wait for
+// release or the call to be cancelled, as storage calls do.
+type fakeService struct {
+	mu    sync.Mutex
+	limit int
+
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *fakeService) GetUserBalance(ctx context.Context, userID string) (*entities.Balance, error) {
+	if s.started != nil {
+		s.started <- struct{}{}
+
+		select {
+		case <-s.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return entities.NewBalance(userID, 100), nil
+}
+
+func (s *fakeService) CreditBalance(context.Context, string, entities.Currency, string) error {
+	return nil
+}
+
+func (s *fakeService) ReserveFromBalance(context.Context, string, string, string, entities.Currency) error {
+	return nil
+}
+
+func (s *fakeService) CommitReserve(context.Context, string, string, string, entities.Currency) error {
+	return nil
+}
+
+func (s *fakeService) ListOperations(_ context.Context, _ string, limit, _ int, _ string, _ bool) ([]*entities.Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limit = limit
+
+	return nil, nil
+}
+
+func (s *fakeService) ListOperationsInRange(
+	context.Context, string, time.Time, time.Time, int, int, string, bool,
+) ([]*entities.Operation, error) {
+	return nil, entities.ErrInternal
+}
+
+func (s *fakeService) lastLimit() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.limit
+}
+
+// keys authenticates secrets named after the key's permissions, all of service shop.
+type keys struct{}
+
+func (keys) Authenticate(_ context.Context, secret string) (*entities.APIKey, error) {
+	switch secret {
+	case "reader":
+		return entities.NewAPIKey("reader", "shop", []entities.Permission{entities.PermissionRead}, time.Now()), nil
+	case "writer":
+		return entities.NewAPIKey("writer", "shop", []entities.Permission{
+			entities.PermissionCredit, entities.PermissionReserve, entities.PermissionCommit,
+		}, time.Now()), nil
+	default:
+		return nil, entities.ErrUnauthorized
+	}
+}
+
+func newClient(t *testing.T, svc *fakeService) (pb.BalanceServiceClient, *grpcport.Server) {
+	t.Helper()
+
+	srv, err := grpcport.NewServer(zap.NewNop().Sugar(), svc, keys{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lis := bufconn.Listen(1 << 20)
+
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			t.Error(err)
+		}
+	}()
+	t.Cleanup(func() { _ = srv.Shutdown(context.Background()) })
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewBalanceServiceClient(conn), srv
+}
+
+func withKey(secret string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "x-api-key", secret)
+}
+
+func TestServer_GetUserBalance(t *testing.T) {
+	client, _ := newClient(t, &fakeService{})
+
+	ctx := metadata.AppendToOutgoingContext(withKey("reader"), "x-request-id", "req-1")
+
+	var header metadata.MD
+
+	balance, err := client.GetUserBalance(ctx, &pb.GetUserBalanceRequest{UserId: "user"}, grpc.Header(&header))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if balance.GetUserId() != "user" || balance.GetCurrency() != 100 {
+		t.Errorf("balance %v, want 100 of user", balance)
+	}
+
+	if ids := header.Get("x-request-id"); len(ids) != 1 || ids[0] != "req-1" {
+		t.Errorf("request id header %v, want req-1", ids)
+	}
+}
+
+func TestServer_Authenticate(t *testing.T) {
+	client, _ := newClient(t, &fakeService{})
+
+	for _, tc := range []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{"missing key", func() error {
+			_, err := client.GetUserBalance(context.Background(), &pb.GetUserBalanceRequest{UserId: "user"})
+			return err
+		}, codes.Unauthenticated},
+		{"unknown key", func() error {
+			_, err := client.GetUserBalance(withKey("unknown"), &pb.GetUserBalanceRequest{UserId: "user"})
+			return err
+		}, codes.Unauthenticated},
+		{"key without permission", func() error {
+			_, err := client.CreditBalance(withKey("reader"), &pb.CreditBalanceRequest{UserId: "user", Currency: 10})
+			return err
+		}, codes.PermissionDenied},
+		{"key with permission", func() error {
+			_, err := client.CreditBalance(withKey("writer"), &pb.CreditBalanceRequest{UserId: "user", Currency: 10})
+			return err
+		}, codes.OK},
+	} {
+		if code := status.Code(tc.call()); code != tc.code {
+			t.Errorf("%s: code %s, want %s", tc.name, code, tc.code)
+		}
+	}
+}
+
+func TestServer_CheckServiceID(t *testing.T) {
+	client, _ := newClient(t, &fakeService{})
+
+	for _, tc := range []struct {
+		serviceID string
+		code      codes.Code
+	}{
+		{"shop", codes.OK},
+		{"other", codes.PermissionDenied},
+	} {
+		_, err := client.ReserveFromBalance(withKey("writer"), &pb.ReserveRequest{
+			UserId: "user", ServiceId: tc.serviceID, OrderId: "order", Currency: 10,
+		})
+		if code := status.Code(err); code != tc.code {
+			t.Errorf("reserve as %s: code %s, want %s", tc.serviceID, code, tc.code)
+		}
+
+		_, err = client.CommitReserve(withKey("writer"), &pb.CommitReserveRequest{
+			UserId: "user", ServiceId: tc.serviceID, OrderId: "order", Currency: 10,
+		})
+		if code := status.Code(err); code != tc.code {
+			t.Errorf("commit as %s: code %s, want %s", tc.serviceID, code, tc.code)
+		}
+	}
+}
+
+func TestServer_ListOperationsPageSize(t *testing.T) {
+	svc := &fakeService{}
+	client, srv := newClient(t, svc)
+
+	list := func(limit int32) int {
+		t.Helper()
+
+		if _, err := client.ListOperations(withKey("reader"), &pb.ListOperationsRequest{UserId: "user", Limit: limit}); err != nil {
+			t.Fatal(err)
+		}
+
+		return svc.lastLimit()
+	}
+
+	if limit := list(0); limit != 10 {
+		t.Errorf("default limit %d, want 10", limit)
+	}
+
+	if err := srv.SetSettings(grpcport.Settings{PageSize: 5, MaxPageSize: 20}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct{ requested, want int }{
+		{0, 5},
+		{7, 7},
+		{1000, 20},
+	} {
+		if limit := list(int32(tc.requested)); limit != tc.want {
+			t.Errorf("limit %d applied as %d, want %d", tc.requested, limit, tc.want)
+		}
+	}
+
+	if err := srv.SetSettings(grpcport.Settings{PageSize: 10, MaxPageSize: 5}); err == nil {
+		t.Error("expected max page size below page size to be rejected")
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	const calls = 5
+
+	svc := &fakeService{started: make(chan struct{}), release: make(chan struct{})}
+	client, srv := newClient(t, svc)
+
+	errs := make(chan error, calls)
+
+	for i := 0; i < calls; i++ {
+		go func() {
+			_, err := client.GetUserBalance(withKey("reader"), &pb.GetUserBalanceRequest{UserId: "user"})
+			errs <- err
+		}()
+	}
+
+	for i := 0; i < calls; i++ {
+		<-svc.started
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown(ctx) }()
+
+	// calls in flight keep running while the server drains
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shutdown returned with calls in flight: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(svc.release)
+
+	for i := 0; i < calls; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("in-flight call failed: %v", err)
+		}
+	}
+
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.GetUserBalance(withKey("reader"), &pb.GetUserBalanceRequest{UserId: "user"}); err == nil {
+		t.Error("expected calls after shutdown to fail")
+	}
+}
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	svc := &fakeService{started: make(chan struct{}), release: make(chan struct{})}
+	defer close(svc.release)
+
+	client, srv := newClient(t, svc)
+
+	errs := make(chan error, 1)
+
+	go func() {
+		_, err := client.GetUserBalance(withKey("reader"), &pb.GetUserBalanceRequest{UserId: "user"})
+		errs <- err
+	}()
+
+	<-svc.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// calls still running when ctx is done are cut off
+	if err := srv.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("shutdown: %v, want deadline exceeded", err)
+	}
+
+	if err := <-errs; err == nil {
+		t.Error("expected call cut off by shutdown to fail")
+	}
+}
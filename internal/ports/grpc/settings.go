@@ -0,0 +1,53 @@
+package grpc
+
+import (
+	"github.com/pkg/errors"
+	"service/internal/entities"
+)
+
+const defaultPageSize = 10
+
+// Settings are tunables which may be replaced while server is running.
+type Settings struct {
+	// PageSize is used when limit is not given.
+	PageSize int
+	// MaxPageSize caps limit, 0 is unlimited.
+	MaxPageSize int
+}
+
+// DefaultSettings keeps behaviour of server without configured tunables.
+func DefaultSettings() Settings {
+	return Settings{PageSize: defaultPageSize}
+}
+
+// SetSettings atomically replaces settings, calls in flight keep the old ones.
+func (s *Server) SetSettings(settings Settings) error {
+	if settings.PageSize < 1 {
+		return errors.WithMessagef(entities.ErrInvalidParam, "page size %d", settings.PageSize)
+	}
+
+	if settings.MaxPageSize < 0 || (settings.MaxPageSize != 0 && settings.MaxPageSize < settings.PageSize) {
+		return errors.WithMessagef(entities.ErrInvalidParam, "max page size %d", settings.MaxPageSize)
+	}
+
+	s.settings.Store(&settings)
+
+	return nil
+}
+
+func (s *Server) currentSettings() Settings {
+	return *s.settings.Load()
+}
+
+// pageSize applies default to zero limit, which is not given in proto3, and caps it.
+func (settings Settings) pageSize(limit int) int {
+	if limit == 0 {
+		limit = settings.PageSize
+	}
+
+	if settings.MaxPageSize != 0 && limit > settings.MaxPageSize {
+		limit = settings.MaxPageSize
+	}
+
+	return limit
+}
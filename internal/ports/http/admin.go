@@ -0,0 +1,203 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"service/internal/entities"
+	"service/pkg/dto"
+)
+
+// CreateAPIKey creates API key for a service
+// swagger:operation POST /admin/api-keys admin CreateAPIKey
+//
+// # CreateAPIKey creates API key for a service. Key secret is returned only once
+//
+// ---
+// consumes:
+// - application/json
+// produces:
+// - application/json
+// parameters:
+//   - name: X-API-Key
+//     in: header
+//     description: "Admin key"
+//     required: true
+//     type: string
+//   - name: body
+//     in: body
+//     required: true
+//     schema:
+//     $ref: '#/definitions/CreateAPIKeyRequest'
+//
+// responses:
+//
+//	'201':
+//	 description: Created key with secret
+//	 schema:
+//	  "$ref": "#/definitions/APIKey"
+//	'400':
+//	 description: Bad response
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
+//	'401':
+//	 description: Unauthorized
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
+//	'500':
+//	 description: Internal error
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
+func (s *Server) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	request := &dto.CreateAPIKeyRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		s.writeError(w, r, invalidParam("body", "encode body"))
+		return
+	}
+
+	if request.ServiceID == "" {
+		s.writeError(w, r, invalidParam("service_id", "empty service id"))
+		return
+	}
+
+	if len(request.Permissions) == 0 {
+		s.writeError(w, r, invalidParam("permissions", "empty permissions"))
+		return
+	}
+
+	permissions := make([]entities.Permission, 0, len(request.Permissions))
+	for _, p := range request.Permissions {
+		permission, err := entities.ParsePermission(p)
+		if err != nil {
+			s.writeError(w, r, invalidParam("permissions", fmt.Sprintf("unknown permission %q", p)))
+			return
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	key, secret, err := s.auth.CreateAPIKey(ctx, request.ServiceID, permissions)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	response := dto.ToAPIKey(key)
+	response.Key = secret
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	if err = json.NewEncoder(w).Encode(&response); err != nil {
+		s.logger(ctx).Info(err)
+	}
+}
+
+// ListAPIKeys lists API keys
+// swagger:operation GET /admin/api-keys admin ListAPIKeys
+//
+// # ListAPIKeys lists API keys without secrets
+//
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: X-API-Key
+//     in: header
+//     description: "Admin key"
+//     required: true
+//     type: string
+//
+// responses:
+//
+//	'200':
+//	 description: Success response
+//	 schema:
+//	  type: array
+//	  items:
+//	   "$ref": "#/definitions/APIKey"
+//	'401':
+//	 description: Unauthorized
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
+//	'500':
+//	 description: Internal error
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
+func (s *Server) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	keys, err := s.auth.ListAPIKeys(ctx)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	response := make([]dto.APIKey, 0, len(keys))
+	for _, key := range keys {
+		response = append(response, dto.ToAPIKey(key))
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		s.logger(ctx).Info(err)
+	}
+}
+
+// RevokeAPIKey revokes API key
+// swagger:operation DELETE /admin/api-keys/{key_id} admin RevokeAPIKey
+//
+// # RevokeAPIKey revokes API key
+//
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: X-API-Key
+//     in: header
+//     description: "Admin key"
+//     required: true
+//     type: string
+//   - name: key_id
+//     in: path
+//     description: "API key id"
+//     required: true
+//     type: string
+//
+// responses:
+//
+//	'204':
+//	 description: Revoked
+//	'401':
+//	 description: Unauthorized
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
+//	'404':
+//	 description: Not found
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
+//	'500':
+//	 description: Internal error
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
+func (s *Server) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, apiKeyIDURLParam)
+	if id == "" {
+		s.writeError(w, r, invalidParam(apiKeyIDURLParam, "empty api key id"))
+		return
+	}
+
+	if err := s.auth.RevokeAPIKey(r.Context(), id); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
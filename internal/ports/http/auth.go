@@ -0,0 +1,93 @@
+package http
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+	"net/http"
+	"service/internal/auth"
+	"service/internal/entities"
+	"service/internal/logging"
+	"strings"
+)
+
+const (
+	apiKeyHeader        = "X-API-Key"
+	authorizationHeader = "Authorization"
+	bearerPrefix        = "Bearer "
+)
+
+// authenticate resolves bearer token to end user, X-API-Key or verified client
+// certificate to the calling service.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if header := r.Header.Get(authorizationHeader); len(header) > len(bearerPrefix) &&
+			strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+			userID, err := s.auth.AuthenticateToken(r.Context(), header[len(bearerPrefix):])
+			if err != nil {
+				s.writeError(w, r, err)
+				return
+			}
+
+			ctx := auth.WithSubject(r.Context(), userID)
+			ctx = logging.WithLogger(ctx, s.logger(ctx).With("subject", userID))
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		secret := r.Header.Get(apiKeyHeader)
+
+		key := s.certificateKey(r)
+		if key == nil || secret != "" {
+			var err error
+
+			key, err = s.auth.Authenticate(r.Context(), secret)
+			if err != nil {
+				s.writeError(w, r, err)
+				return
+			}
+		}
+
+		ctx := auth.WithAPIKey(r.Context(), key)
+		ctx = logging.WithLogger(ctx, s.logger(ctx).With("service_id", key.ServiceID()))
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// allow lets request through only if its key has the permission. End users
+// may only read their own balance.
+func (s *Server) allow(permission entities.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !allowed(r, permission) {
+				s.writeError(w, r, errors.WithMessagef(entities.ErrForbidden, "%s is not allowed", permission))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func allowed(r *http.Request, permission entities.Permission) bool {
+	if key := auth.APIKey(r.Context()); key != nil {
+		return key.Allows(permission)
+	}
+
+	subject := auth.Subject(r.Context())
+
+	return permission == entities.PermissionRead && subject != "" && subject == chi.URLParam(r, userIDURLParam)
+}
+
+// authenticateAdmin guards API keys management with admin key from config.
+func (s *Server) authenticateAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.auth.AuthenticateAdmin(r.Context(), r.Header.Get(apiKeyHeader)); err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
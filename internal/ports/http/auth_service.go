@@ -0,0 +1,19 @@
+package http
+
+import (
+	"context"
+	"service/internal/entities"
+)
+
+type AuthService interface {
+	Authenticate(ctx context.Context, secret string) (*entities.APIKey, error)
+	AuthenticateToken(ctx context.Context, token string) (string, error)
+	AuthenticateAdmin(ctx context.Context, secret string) error
+	CreateAPIKey(
+		ctx context.Context,
+		serviceID string,
+		permissions []entities.Permission,
+	) (*entities.APIKey, string, error)
+	ListAPIKeys(ctx context.Context) ([]*entities.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+}
@@ -0,0 +1,56 @@
+package http
+
+import (
+	"net/http"
+	"service/internal/consistency"
+)
+
+// consistencyTokenHeader is returned by mutations and passed with reads
+// which must observe them when reads are served by replicas.
+const consistencyTokenHeader = "X-Consistency-Token"
+
+// readConsistency passes client's consistency token to storage.
+func (s *Server) readConsistency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := r.Header.Get(consistencyTokenHeader); token != "" {
+			r = r.WithContext(consistency.WithToken(r.Context(), token))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recordConsistency returns token of the mutation made by request in response header.
+func (s *Server) recordConsistency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, recorder := consistency.WithRecorder(r.Context())
+
+		next.ServeHTTP(&tokenWriter{ResponseWriter: w, recorder: recorder}, r.WithContext(ctx))
+	})
+}
+
+type tokenWriter struct {
+	http.ResponseWriter
+	recorder    *consistency.Recorder
+	wroteHeader bool
+}
+
+func (w *tokenWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+
+		if token := w.recorder.Token(); token != "" {
+			w.Header().Set(consistencyTokenHeader, token)
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *tokenWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
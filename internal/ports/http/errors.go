@@ -0,0 +1,83 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"net/http"
+	"service/internal/entities"
+	"service/internal/logging"
+	"service/pkg/dto"
+)
+
+const (
+	problemContentType = "application/problem+json"
+	problemType        = "about:blank"
+	requestIDHeader    = "X-Request-ID"
+)
+
+var statusCodes = map[string]int{
+	entities.CodeInvalidParam:        http.StatusBadRequest,
+	entities.CodeReserveExists:       http.StatusConflict,
+	entities.CodeInsufficientFunds:   http.StatusBadRequest,
+	entities.CodeInsufficientReserve: http.StatusBadRequest,
+	entities.CodeNotFound:            http.StatusNotFound,
+	entities.CodeUnauthorized:        http.StatusUnauthorized,
+	entities.CodeForbidden:           http.StatusForbidden,
+	entities.CodeRateLimited:         http.StatusTooManyRequests,
+	entities.CodeInternal:            http.StatusInternalServerError,
+}
+
+type paramError struct {
+	param string
+	err   error
+}
+
+func (e *paramError) Error() string {
+	return e.err.Error()
+}
+
+func (e *paramError) Unwrap() error {
+	return e.err
+}
+
+func invalidParam(param, message string) error {
+	return &paramError{
+		param: param,
+		err:   errors.WithMessage(entities.ErrInvalidParam, message),
+	}
+}
+
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	log := s.logger(r.Context())
+	log.Error(err)
+
+	code := entities.ErrorCode(err)
+	status := statusCodes[code]
+
+	detail := err.Error()
+	if code == entities.CodeInternal {
+		detail = entities.ErrInternal.Error()
+	}
+
+	resp := &dto.ErrResponse{
+		Type:      problemType,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Code:      code,
+		RequestID: logging.RequestID(r.Context()),
+		Message:   detail,
+	}
+
+	var pe *paramError
+	if errors.As(err, &pe) {
+		resp.Details = map[string]string{"param": pe.param}
+	}
+
+	w.Header().Add("Content-Type", problemContentType)
+	w.WriteHeader(status)
+
+	if err = json.NewEncoder(w).Encode(resp); err != nil {
+		log.Info(err)
+	}
+}
@@ -0,0 +1,70 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const readinessTimeout = 2 * time.Second
+
+const (
+	statusOK          = "ok"
+	statusUnavailable = "unavailable"
+)
+
+// HealthChecker reports whether dependencies are able to serve traffic.
+type HealthChecker interface {
+	Ready(ctx context.Context) error
+}
+
+type healthResponse struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Drain marks server as not ready, so load balancers stop sending new
+// requests. Server keeps serving until it is stopped.
+func (s *Server) Drain() {
+	s.draining.Store(true)
+}
+
+// Healthz reports that process is alive.
+func (s *Server) Healthz(w http.ResponseWriter, _ *http.Request) {
+	writeHealth(w, http.StatusOK, &healthResponse{Status: statusOK})
+}
+
+// Readyz reports that server is able to serve requests: storage is reachable,
+// schema is migrated and server is not shutting down.
+func (s *Server) Readyz(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		writeHealth(w, http.StatusServiceUnavailable, &healthResponse{
+			Status: statusUnavailable,
+			Reason: "shutting down",
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	if err := s.health.Ready(ctx); err != nil {
+		s.logger(ctx).Warnw("not ready", "error", err)
+		writeHealth(w, http.StatusServiceUnavailable, &healthResponse{
+			Status: statusUnavailable,
+			Reason: err.Error(),
+		})
+		return
+	}
+
+	writeHealth(w, http.StatusOK, &healthResponse{Status: statusOK})
+}
+
+func writeHealth(w http.ResponseWriter, status int, response *healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,13 @@
+package http
+
+import (
+	"net/http"
+	"time"
+)
+
+type Metrics interface {
+	ObserveRequest(method, route string, status int, duration time.Duration)
+	// RateLimiterFailed counts limiter errors, allowed tells whether the request was let through.
+	RateLimiterFailed(route string, allowed bool)
+	Handler() http.Handler
+}
@@ -0,0 +1,160 @@
+package http
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+	"math"
+	"net/http"
+	"service/internal/auth"
+	"service/internal/entities"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	RateLimitByClient = "client"
+	RateLimitByUser   = "user"
+
+	// RateLimitAnyRoute applies rule to every authenticated route.
+	RateLimitAnyRoute = "*"
+
+	// RateLimitFailOpen lets requests through when limiter fails,
+	// RateLimitFailClosed rejects them.
+	RateLimitFailOpen   = "open"
+	RateLimitFailClosed = "closed"
+
+	retryAfterHeader = "Retry-After"
+)
+
+// RateLimiter takes a token from bucket key, refilled with rate tokens per
+// second up to burst. It returns how long to wait when bucket is empty.
+type RateLimiter interface {
+	Take(ctx context.Context, key string, rate float64, burst int) (time.Duration, error)
+}
+
+// RateLimitRule limits route, given as "METHOD pattern" like
+// "POST /api/v1/balances/{user_id}/credit", per client or per user_id.
+type RateLimitRule struct {
+	Route string
+	By    string
+	Rate  float64
+	Burst int
+}
+
+// RateLimits holds limiter and rules, rules may be replaced at runtime.
+type RateLimits struct {
+	limiter  RateLimiter
+	failOpen bool
+	rules    atomic.Pointer[map[string][]RateLimitRule]
+}
+
+// NewRateLimits takes onError, RateLimitFailOpen or RateLimitFailClosed,
+// deciding requests when limiter fails.
+func NewRateLimits(limiter RateLimiter, onError string, rules []RateLimitRule) (*RateLimits, error) {
+	if limiter == nil || limiter == RateLimiter(nil) {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty rate limiter")
+	}
+
+	if onError != RateLimitFailOpen && onError != RateLimitFailClosed {
+		return nil, errors.WithMessagef(entities.ErrInvalidParam, "rate limit: unknown on error %q", onError)
+	}
+
+	l := &RateLimits{limiter: limiter, failOpen: onError == RateLimitFailOpen}
+
+	if err := l.SetRules(rules); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *RateLimits) SetRules(rules []RateLimitRule) error {
+	byRoute := make(map[string][]RateLimitRule)
+
+	for _, rule := range rules {
+		if rule.Route == "" {
+			return errors.WithMessage(entities.ErrInvalidParam, "rate limit: empty route")
+		}
+
+		if rule.By != RateLimitByClient && rule.By != RateLimitByUser {
+			return errors.WithMessagef(entities.ErrInvalidParam, "rate limit %s: unknown key %q", rule.Route, rule.By)
+		}
+
+		if rule.Rate <= 0 || rule.Burst <= 0 {
+			return errors.WithMessagef(entities.ErrInvalidParam, "rate limit %s: rate and burst must be positive", rule.Route)
+		}
+
+		byRoute[rule.Route] = append(byRoute[rule.Route], rule)
+	}
+
+	l.rules.Store(&byRoute)
+
+	return nil
+}
+
+func (l *RateLimits) match(route string) []RateLimitRule {
+	rules := *l.rules.Load()
+
+	matched := make([]RateLimitRule, 0, len(rules[route])+len(rules[RateLimitAnyRoute]))
+	matched = append(matched, rules[route]...)
+
+	return append(matched, rules[RateLimitAnyRoute]...)
+}
+
+// rateLimit must run after allow, as clients are told apart by key and
+// requests without permission must not take tokens of the client or user.
+// Limiter failures are counted and decided by fail open setting.
+func (s *Server) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		route := r.Method + " " + chi.RouteContext(ctx).RoutePattern()
+
+		for _, rule := range s.limits.match(route) {
+			key, ok := rateLimitKey(r, rule)
+			if !ok {
+				continue
+			}
+
+			wait, err := s.limits.limiter.Take(ctx, rule.Route+"|"+key, rule.Rate, rule.Burst)
+			if err != nil {
+				s.logger(ctx).Error(err)
+				s.metrics.RateLimiterFailed(route, s.limits.failOpen)
+
+				if s.limits.failOpen {
+					continue
+				}
+
+				s.writeError(w, r, errors.WithMessagef(entities.ErrInternal, "rate limiter: %s", err))
+				return
+			}
+
+			if wait > 0 {
+				w.Header().Set(retryAfterHeader, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				s.writeError(w, r, errors.WithMessagef(entities.ErrRateLimited, "too many requests to %s", route))
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func rateLimitKey(r *http.Request, rule RateLimitRule) (string, bool) {
+	if rule.By == RateLimitByUser {
+		userID := chi.URLParam(r, userIDURLParam)
+		return "user:" + userID, userID != ""
+	}
+
+	if key := auth.APIKey(r.Context()); key != nil {
+		return "service:" + key.ServiceID(), true
+	}
+
+	if subject := auth.Subject(r.Context()); subject != "" {
+		return "subject:" + subject, true
+	}
+
+	return "", false
+}
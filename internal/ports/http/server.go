@@ -2,6 +2,7 @@ package http
 
 import (
 	"context"
+	"crypto/tls"
 	_ "embed"
 	"encoding/json"
 	"fmt"
@@ -9,30 +10,57 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"net"
 	"net/http"
+	"service/internal/auth"
 	"service/internal/entities"
+	"service/internal/logging"
 	"service/pkg/dto"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	userIDURLParam = "user_id"
-	stopTimeout    = 5 * time.Second
+	userIDURLParam       = "user_id"
+	apiKeyIDURLParam     = "key_id"
+	idempotencyKeyHeader = "Idempotency-Key"
+	eventsPingPeriod     = 15 * time.Second
+	balanceEventName     = "balance"
+	operationEventName   = "operation"
 )
 
 //go:embed doc/swagger.json
 var spec []byte
 
 type Server struct {
-	router *chi.Mux
-	svc    BalanceService
-	port   int
-	log    *zap.SugaredLogger
-	server *http.Server
+	router   *chi.Mux
+	svc      BalanceService
+	auth     AuthService
+	limits   *RateLimits
+	health   HealthChecker
+	metrics  Metrics
+	port     int
+	log      *zap.SugaredLogger
+	server   *http.Server
+	settings atomic.Pointer[Settings]
+	draining atomic.Bool
+	stopping chan struct{}
+	stopOnce sync.Once
+
+	certPermissions []entities.Permission
 }
 
-func NewServer(log *zap.SugaredLogger, svc BalanceService, port int) (*Server, error) {
+func NewServer(
+	log *zap.SugaredLogger,
+	svc BalanceService,
+	auth AuthService,
+	limits *RateLimits,
+	health HealthChecker,
+	metrics Metrics,
+	port int,
+) (*Server, error) {
 	if log == nil {
 		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty logger")
 	}
@@ -41,6 +69,22 @@ func NewServer(log *zap.SugaredLogger, svc BalanceService, port int) (*Server, e
 		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty service")
 	}
 
+	if auth == nil || auth == AuthService(nil) {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty auth service")
+	}
+
+	if limits == nil {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty rate limits")
+	}
+
+	if health == nil || health == HealthChecker(nil) {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty health checker")
+	}
+
+	if metrics == nil || metrics == Metrics(nil) {
+		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty metrics")
+	}
+
 	if port == 0 {
 		return nil, errors.WithMessage(entities.ErrInvalidParam, "empty port")
 	}
@@ -48,50 +92,114 @@ func NewServer(log *zap.SugaredLogger, svc BalanceService, port int) (*Server, e
 	router := chi.NewRouter()
 
 	server := &Server{
-		router: router,
-		svc:    svc,
-		port:   port,
-		log:    log,
-	}
+		router:  router,
+		svc:     svc,
+		auth:    auth,
+		limits:  limits,
+		health:  health,
+		metrics: metrics,
+		port:    port,
+		log:     log,
+		server: &http.Server{
+			Addr:    fmt.Sprintf("0.0.0.0:%d", port),
+			Handler: router,
+		},
+		stopping: make(chan struct{}),
+	}
+
+	settings := DefaultSettings()
+	server.settings.Store(&settings)
+
+	router.Use(server.trace, server.requestID, server.accessLog, server.observe)
 
 	basePath := "/api/v1"
 
 	router.Route(basePath, func(r chi.Router) {
-		r.Get(fmt.Sprintf("/balances/{%s}", userIDURLParam), server.GetUserBalance)
-		r.Post(fmt.Sprintf("/balances/{%s}/credit", userIDURLParam), server.CreditBalance)
-		r.Post(fmt.Sprintf("/balances/{%s}/reserve", userIDURLParam), server.ReserveFromBalance)
-		r.Post(fmt.Sprintf("/balances/{%s}/commit", userIDURLParam), server.CommitReserve)
-		r.Get(fmt.Sprintf("/balances/{%s}/operations", userIDURLParam), server.ListOperations)
+		r.Group(func(r chi.Router) {
+			r.Use(server.authenticate)
+
+			read := r.With(server.allow(entities.PermissionRead), server.rateLimit, server.readConsistency)
+			read.Get(fmt.Sprintf("/balances/{%s}", userIDURLParam), server.GetUserBalance)
+			read.Get(fmt.Sprintf("/balances/{%s}/operations", userIDURLParam), server.ListOperations)
+			read.Get(fmt.Sprintf("/balances/{%s}/events", userIDURLParam), server.BalanceEvents)
+
+			r.With(server.allow(entities.PermissionCredit), server.rateLimit, server.recordConsistency).
+				Post(fmt.Sprintf("/balances/{%s}/credit", userIDURLParam), server.CreditBalance)
+			r.With(server.allow(entities.PermissionReserve), server.rateLimit, server.recordConsistency).
+				Post(fmt.Sprintf("/balances/{%s}/reserve", userIDURLParam), server.ReserveFromBalance)
+			r.With(server.allow(entities.PermissionCommit), server.rateLimit, server.recordConsistency).
+				Post(fmt.Sprintf("/balances/{%s}/commit", userIDURLParam), server.CommitReserve)
+		})
+
+		r.Route("/admin/api-keys", func(r chi.Router) {
+			r.Use(server.authenticateAdmin)
+
+			r.Post("/", server.CreateAPIKey)
+			r.Get("/", server.ListAPIKeys)
+			r.Delete(fmt.Sprintf("/{%s}", apiKeyIDURLParam), server.RevokeAPIKey)
+		})
 	})
 
 	router.Mount("/swagger/", server.SwaggerHandler(spec))
+	router.Handle("/metrics", metrics.Handler())
+	router.Get("/healthz", server.Healthz)
+	router.Get("/readyz", server.Readyz)
 
 	return server, nil
 }
 
-func (s *Server) Run(ctx context.Context) {
-	addr := fmt.Sprintf("0.0.0.0:%d", s.port)
-	s.log.Infof("server listen %s", addr)
+func (s *Server) Run() {
+	s.log.Infof("server listen %s", s.server.Addr)
+
+	lis, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		s.log.Fatal(err)
+	}
 
-	go s.stopProcess(ctx)
+	if err = s.Serve(lis); err != nil {
+		s.log.Fatal(err)
+	}
+}
 
-	s.server = &http.Server{Addr: addr, Handler: s.router}
+// Serve accepts connections on lis until server is shut down.
+func (s *Server) Serve(lis net.Listener) error {
+	if s.server.TLSConfig != nil {
+		lis = tls.NewListener(lis, s.server.TLSConfig)
+	}
 
-	err := s.server.ListenAndServe()
+	err := s.server.Serve(lis)
 	if err != nil && err != http.ErrServerClosed {
-		s.log.Fatal(err)
+		return err
 	}
+
+	return nil
 }
 
-func (s *Server) stopProcess(ctx context.Context) {
-	<-ctx.Done()
+// Shutdown marks server as not ready and keeps serving for the drain delay,
+// then stops accepting connections and waits for in-flight requests until ctx
+// is done. Event streams are closed right after the delay, as they never finish
+// on their own. Requests still running when ctx is done are cut off.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.Drain()
+
+	select {
+	case <-time.After(s.currentSettings().DrainDelay):
+	case <-ctx.Done():
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
-	defer cancel()
+	s.stopOnce.Do(func() { close(s.stopping) })
 
-	if err := s.server.Shutdown(ctx); err != nil {
-		s.log.Error(err)
+	err := s.server.Shutdown(ctx)
+	if err != nil {
+		_ = s.server.Close()
+		return err
 	}
+
+	return nil
+}
+
+func (s *Server) Handler() http.Handler {
+	return s.router
 }
 
 func (s *Server) SwaggerHandler(spec []byte) http.Handler {
@@ -109,11 +217,26 @@ func (s *Server) SwaggerHandler(spec []byte) http.Handler {
 // produces:
 // - application/json
 // parameters:
+//   - name: X-API-Key
+//     in: header
+//     description: "Service API key, required unless Authorization is set"
+//     required: false
+//     type: string
+//   - name: Authorization
+//     in: header
+//     description: "Bearer token of the user, allows reading only own balance"
+//     required: false
+//     type: string
 //   - name: user_id
 //     in: path
 //     description: "User id"
 //     required: true
 //     type: string
+//   - name: X-Consistency-Token
+//     in: header
+//     description: "Token from a mutation response, the read observes that mutation"
+//     required: false
+//     type: string
 //
 // responses:
 //
@@ -125,10 +248,22 @@ func (s *Server) SwaggerHandler(spec []byte) http.Handler {
 //	 description: Bad response
 //	 schema:
 //	  "$ref": "#/definitions/ErrResponse"
+//	'401':
+//	 description: Unauthorized
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
+//	'403':
+//	 description: Forbidden
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
 //	'404':
 //	 description: Not found
 //	 schema:
 //	  "$ref": "#/definitions/ErrResponse"
+//	'429':
+//	 description: Too many requests, retry after Retry-After seconds
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
 //	'500':
 //	 description: Internal error
 //	 schema:
@@ -138,21 +273,13 @@ func (s *Server) GetUserBalance(w http.ResponseWriter, r *http.Request) {
 
 	userID := chi.URLParam(r, userIDURLParam)
 	if userID == "" {
-		err := errors.WithMessage(entities.ErrInvalidParam, "empty balance id")
-		s.log.Error(err)
-		s.writeError(w, err, http.StatusBadRequest)
+		s.writeError(w, r, invalidParam(userIDURLParam, "empty balance id"))
 		return
 	}
 
 	balance, err := s.svc.GetUserBalance(ctx, userID)
-	if errors.Is(err, entities.ErrNotFound) {
-		s.log.Error(err)
-		s.writeError(w, err, http.StatusNotFound)
-		return
-	}
 	if err != nil {
-		s.log.Error(err)
-		s.writeError(w, entities.ErrInternal, http.StatusInternalServerError)
+		s.writeError(w, r, err)
 		return
 	}
 
@@ -165,7 +292,7 @@ func (s *Server) GetUserBalance(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 
 	if err = json.NewEncoder(w).Encode(response); err != nil {
-		s.log.Info(err)
+		s.logger(ctx).Info(err)
 	}
 }
 
@@ -180,11 +307,21 @@ func (s *Server) GetUserBalance(w http.ResponseWriter, r *http.Request) {
 // produces:
 // - application/json
 // parameters:
+//   - name: X-API-Key
+//     in: header
+//     description: "Service API key"
+//     required: true
+//     type: string
 //   - name: user_id
 //     in: path
 //     description: "User id"
 //     required: true
 //     type: string
+//   - name: Idempotency-Key
+//     in: header
+//     description: "Repeating a credit with the same key is a no-op, up to 128 of A-Z a-z 0-9 . _ : -"
+//     required: false
+//     type: string
 //   - name: body
 //     in: body
 //     required: true
@@ -195,14 +332,30 @@ func (s *Server) GetUserBalance(w http.ResponseWriter, r *http.Request) {
 //
 //	'200':
 //	 description: Success response
+//	 headers:
+//	  X-Consistency-Token:
+//	   type: string
+//	   description: "Pass with reads which must observe this mutation"
 //	'400':
 //	 description: Bad response
 //	 schema:
 //	  "$ref": "#/definitions/ErrResponse"
+//	'401':
+//	 description: Unauthorized
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
+//	'403':
+//	 description: Forbidden
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
 //	'404':
 //	 description: Not found
 //	 schema:
 //	  "$ref": "#/definitions/ErrResponse"
+//	'429':
+//	 description: Too many requests, retry after Retry-After seconds
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
 //	'500':
 //	 description: Internal error
 //	 schema:
@@ -212,9 +365,7 @@ func (s *Server) CreditBalance(w http.ResponseWriter, r *http.Request) {
 
 	userID := chi.URLParam(r, userIDURLParam)
 	if userID == "" {
-		err := errors.WithMessage(entities.ErrInvalidParam, "empty balance id")
-		s.log.Error(err)
-		s.writeError(w, err, http.StatusBadRequest)
+		s.writeError(w, r, invalidParam(userIDURLParam, "empty balance id"))
 		return
 	}
 
@@ -222,28 +373,18 @@ func (s *Server) CreditBalance(w http.ResponseWriter, r *http.Request) {
 
 	err := json.NewDecoder(r.Body).Decode(request)
 	if err != nil {
-		err = errors.WithMessage(entities.ErrInvalidParam, "encode body")
-		s.log.Error(err)
-		s.writeError(w, err, http.StatusBadRequest)
+		s.writeError(w, r, invalidParam("body", "encode body"))
 		return
 	}
 
 	if request.Currency <= 0 {
-		err := errors.WithMessage(entities.ErrInvalidParam, "invalid currency")
-		s.log.Error(err)
-		s.writeError(w, err, http.StatusBadRequest)
+		s.writeError(w, r, invalidParam("currency", "invalid currency"))
 		return
 	}
 
-	err = s.svc.CreditBalance(ctx, userID, entities.Currency(request.Currency))
-	if errors.Is(err, entities.ErrNotFound) {
-		s.log.Error(err)
-		s.writeError(w, err, http.StatusNotFound)
-		return
-	}
+	err = s.svc.CreditBalance(ctx, userID, entities.Currency(request.Currency), r.Header.Get(idempotencyKeyHeader))
 	if err != nil {
-		s.log.Error(err)
-		s.writeError(w, entities.ErrInternal, http.StatusInternalServerError)
+		s.writeError(w, r, err)
 		return
 	}
 
@@ -251,7 +392,7 @@ func (s *Server) CreditBalance(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 
 	if err = json.NewEncoder(w).Encode(struct{}{}); err != nil {
-		s.log.Info(err)
+		s.logger(ctx).Info(err)
 	}
 }
 
@@ -266,6 +407,11 @@ func (s *Server) CreditBalance(w http.ResponseWriter, r *http.Request) {
 // produces:
 // - application/json
 // parameters:
+//   - name: X-API-Key
+//     in: header
+//     description: "Service API key"
+//     required: true
+//     type: string
 //   - name: user_id
 //     in: path
 //     description: "User id"
@@ -281,10 +427,22 @@ func (s *Server) CreditBalance(w http.ResponseWriter, r *http.Request) {
 //
 //	'200':
 //	 description: Success response
+//	 headers:
+//	  X-Consistency-Token:
+//	   type: string
+//	   description: "Pass with reads which must observe this mutation"
 //	'400':
 //	 description: Bad response
 //	 schema:
 //	  "$ref": "#/definitions/ErrResponse"
+//	'401':
+//	 description: Unauthorized
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
+//	'403':
+//	 description: Forbidden
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
 //	'404':
 //	 description: Not found
 //	 schema:
@@ -293,6 +451,10 @@ func (s *Server) CreditBalance(w http.ResponseWriter, r *http.Request) {
 //	 description: Conflict
 //	 schema:
 //	  "$ref": "#/definitions/ErrResponse"
+//	'429':
+//	 description: Too many requests, retry after Retry-After seconds
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
 //	'500':
 //	 description: Internal error
 //	 schema:
@@ -302,9 +464,7 @@ func (s *Server) ReserveFromBalance(w http.ResponseWriter, r *http.Request) {
 
 	userID := chi.URLParam(r, userIDURLParam)
 	if userID == "" {
-		err := errors.WithMessage(entities.ErrInvalidParam, "empty operation id")
-		s.log.Error(err)
-		s.writeError(w, err, http.StatusBadRequest)
+		s.writeError(w, r, invalidParam(userIDURLParam, "empty operation id"))
 		return
 	}
 
@@ -312,52 +472,33 @@ func (s *Server) ReserveFromBalance(w http.ResponseWriter, r *http.Request) {
 
 	err := json.NewDecoder(r.Body).Decode(request)
 	if err != nil {
-		err = errors.WithMessage(entities.ErrInvalidParam, "encode body")
-		s.log.Error(err)
-		s.writeError(w, err, http.StatusBadRequest)
+		s.writeError(w, r, invalidParam("body", "encode body"))
 		return
 	}
 
 	if request.Currency <= 0 {
-		err := errors.WithMessage(entities.ErrInvalidParam, "invalid currency")
-		s.log.Error(err)
-		s.writeError(w, err, http.StatusBadRequest)
+		s.writeError(w, r, invalidParam("currency", "invalid currency"))
 		return
 	}
 
 	if request.ServiceID == "" {
-		err := errors.WithMessage(entities.ErrInvalidParam, "empty service id")
-		s.log.Error(err)
-		s.writeError(w, err, http.StatusBadRequest)
+		s.writeError(w, r, invalidParam("service_id", "empty service id"))
 		return
 	}
 
 	if request.OrderID == "" {
-		err := errors.WithMessage(entities.ErrInvalidParam, "empty order id")
-		s.log.Error(err)
-		s.writeError(w, err, http.StatusBadRequest)
+		s.writeError(w, r, invalidParam("order_id", "empty order id"))
 		return
 	}
 
-	err = s.svc.ReserveFromBalance(ctx, userID, request.ServiceID, request.OrderID, entities.Currency(request.Currency))
-	if errors.Is(err, entities.ErrNotFound) {
-		s.log.Error(err)
-		s.writeError(w, err, http.StatusNotFound)
-		return
-	}
-	if errors.Is(err, entities.ErrReserveAlreadyExists) {
-		s.log.Error(err)
-		s.writeError(w, err, http.StatusConflict)
-		return
-	}
-	if errors.Is(err, entities.ErrReserveInvalidValue) {
-		s.log.Error(err)
-		s.writeError(w, err, http.StatusBadRequest)
+	if err = auth.CheckServiceID(ctx, request.ServiceID); err != nil {
+		s.writeError(w, r, err)
 		return
 	}
+
+	err = s.svc.ReserveFromBalance(ctx, userID, request.ServiceID, request.OrderID, entities.Currency(request.Currency))
 	if err != nil {
-		s.log.Error(err)
-		s.writeError(w, entities.ErrInternal, http.StatusInternalServerError)
+		s.writeError(w, r, err)
 		return
 	}
 
@@ -365,7 +506,7 @@ func (s *Server) ReserveFromBalance(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 
 	if err = json.NewEncoder(w).Encode(struct{}{}); err != nil {
-		s.log.Info(err)
+		s.logger(ctx).Info(err)
 	}
 }
 
@@ -380,6 +521,11 @@ func (s *Server) ReserveFromBalance(w http.ResponseWriter, r *http.Request) {
 // produces:
 // - application/json
 // parameters:
+//   - name: X-API-Key
+//     in: header
+//     description: "Service API key"
+//     required: true
+//     type: string
 //   - name: user_id
 //     in: path
 //     description: "User id"
@@ -395,10 +541,22 @@ func (s *Server) ReserveFromBalance(w http.ResponseWriter, r *http.Request) {
 //
 //	'200':
 //	 description: Success response
+//	 headers:
+//	  X-Consistency-Token:
+//	   type: string
+//	   description: "Pass with reads which must observe this mutation"
 //	'400':
 //	 description: Bad response
 //	 schema:
 //	  "$ref": "#/definitions/ErrResponse"
+//	'401':
+//	 description: Unauthorized
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
+//	'403':
+//	 description: Forbidden
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
 //	'404':
 //	 description: Not found
 //	 schema:
@@ -407,6 +565,10 @@ func (s *Server) ReserveFromBalance(w http.ResponseWriter, r *http.Request) {
 //	 description: Conflict
 //	 schema:
 //	  "$ref": "#/definitions/ErrResponse"
+//	'429':
+//	 description: Too many requests, retry after Retry-After seconds
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
 //	'500':
 //	 description: Internal error
 //	 schema:
@@ -416,9 +578,7 @@ func (s *Server) CommitReserve(w http.ResponseWriter, r *http.Request) {
 
 	userID := chi.URLParam(r, userIDURLParam)
 	if userID == "" {
-		err := errors.WithMessage(entities.ErrInvalidParam, "empty operation id")
-		s.log.Error(err)
-		s.writeError(w, err, http.StatusBadRequest)
+		s.writeError(w, r, invalidParam(userIDURLParam, "empty operation id"))
 		return
 	}
 
@@ -426,52 +586,33 @@ func (s *Server) CommitReserve(w http.ResponseWriter, r *http.Request) {
 
 	err := json.NewDecoder(r.Body).Decode(request)
 	if err != nil {
-		err = errors.WithMessage(entities.ErrInvalidParam, "encode body")
-		s.log.Error(err)
-		s.writeError(w, err, http.StatusBadRequest)
+		s.writeError(w, r, invalidParam("body", "encode body"))
 		return
 	}
 
 	if request.Currency <= 0 {
-		err := errors.WithMessage(entities.ErrInvalidParam, "invalid currency")
-		s.log.Error(err)
-		s.writeError(w, err, http.StatusBadRequest)
+		s.writeError(w, r, invalidParam("currency", "invalid currency"))
 		return
 	}
 
 	if request.ServiceID == "" {
-		err := errors.WithMessage(entities.ErrInvalidParam, "empty service id")
-		s.log.Error(err)
-		s.writeError(w, err, http.StatusBadRequest)
+		s.writeError(w, r, invalidParam("service_id", "empty service id"))
 		return
 	}
 
 	if request.OrderID == "" {
-		err := errors.WithMessage(entities.ErrInvalidParam, "empty order id")
-		s.log.Error(err)
-		s.writeError(w, err, http.StatusBadRequest)
+		s.writeError(w, r, invalidParam("order_id", "empty order id"))
 		return
 	}
 
-	err = s.svc.CommitReserve(ctx, userID, request.ServiceID, request.OrderID, entities.Currency(request.Currency))
-	if errors.Is(err, entities.ErrInvalidParam) {
-		s.log.Error(err)
-		s.writeError(w, err, http.StatusBadRequest)
-		return
-	}
-	if errors.Is(err, entities.ErrNotFound) {
-		s.log.Error(err)
-		s.writeError(w, err, http.StatusNotFound)
-		return
-	}
-	if errors.Is(err, entities.ErrCommitInvalidValue) {
-		s.log.Error(err)
-		s.writeError(w, err, http.StatusBadRequest)
+	if err = auth.CheckServiceID(ctx, request.ServiceID); err != nil {
+		s.writeError(w, r, err)
 		return
 	}
+
+	err = s.svc.CommitReserve(ctx, userID, request.ServiceID, request.OrderID, entities.Currency(request.Currency))
 	if err != nil {
-		s.log.Error(err)
-		s.writeError(w, entities.ErrInternal, http.StatusInternalServerError)
+		s.writeError(w, r, err)
 		return
 	}
 
@@ -479,7 +620,7 @@ func (s *Server) CommitReserve(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 
 	if err = json.NewEncoder(w).Encode(struct{}{}); err != nil {
-		s.log.Info(err)
+		s.logger(ctx).Info(err)
 	}
 }
 
@@ -494,14 +635,29 @@ func (s *Server) CommitReserve(w http.ResponseWriter, r *http.Request) {
 // produces:
 // - application/json
 // parameters:
+//   - name: X-API-Key
+//     in: header
+//     description: "Service API key, required unless Authorization is set"
+//     required: false
+//     type: string
+//   - name: Authorization
+//     in: header
+//     description: "Bearer token of the user, allows reading only own balance"
+//     required: false
+//     type: string
 //   - name: user_id
 //     in: path
 //     description: "User id"
 //     required: true
 //     type: string
+//   - name: X-Consistency-Token
+//     in: header
+//     description: "Token from a mutation response, the read observes that mutation"
+//     required: false
+//     type: string
 //   - name: limit
 //     in: query
-//     description: "Limit"
+//     description: "Limit, server default and maximum apply"
 //     required: false
 //     type: integer
 //   - name: offset
@@ -519,6 +675,18 @@ func (s *Server) CommitReserve(w http.ResponseWriter, r *http.Request) {
 //     description: "Response in desc order"
 //     required: false
 //     type: boolean
+//   - name: from
+//     in: query
+//     description: "Operations created at or after, RFC 3339. With from or to archived operations are listed too"
+//     required: false
+//     type: string
+//     format: date-time
+//   - name: to
+//     in: query
+//     description: "Operations created before, RFC 3339"
+//     required: false
+//     type: string
+//     format: date-time
 //
 // responses:
 //
@@ -528,6 +696,14 @@ func (s *Server) CommitReserve(w http.ResponseWriter, r *http.Request) {
 //	 description: Bad response
 //	 schema:
 //	  "$ref": "#/definitions/ErrResponse"
+//	'401':
+//	 description: Unauthorized
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
+//	'403':
+//	 description: Forbidden
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
 //	'404':
 //	 description: Not found
 //	 schema:
@@ -536,6 +712,10 @@ func (s *Server) CommitReserve(w http.ResponseWriter, r *http.Request) {
 //	 description: Conflict
 //	 schema:
 //	  "$ref": "#/definitions/ErrResponse"
+//	'429':
+//	 description: Too many requests, retry after Retry-After seconds
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
 //	'500':
 //	 description: Internal error
 //	 schema:
@@ -547,9 +727,7 @@ func (s *Server) ListOperations(w http.ResponseWriter, r *http.Request) {
 
 	userID := chi.URLParam(r, userIDURLParam)
 	if userID == "" {
-		err = errors.WithMessage(entities.ErrInvalidParam, "empty operation id")
-		s.log.Error(err)
-		s.writeError(w, err, http.StatusBadRequest)
+		s.writeError(w, r, invalidParam(userIDURLParam, "empty operation id"))
 		return
 	}
 
@@ -558,32 +736,27 @@ func (s *Server) ListOperations(w http.ResponseWriter, r *http.Request) {
 	if offsetParam != "" {
 		offset, err = strconv.Atoi(offsetParam)
 		if err != nil {
-			err = errors.WithMessage(entities.ErrInvalidParam, "invalid offset param")
-			s.log.Error(err)
-			s.writeError(w, err, http.StatusBadRequest)
+			s.writeError(w, r, invalidParam("offset", "invalid offset param"))
 			return
 		}
 	}
 
-	limit := 10
+	var limit int
 	limitParam := r.URL.Query().Get("limit")
 	if limitParam != "" {
 		limit, err = strconv.Atoi(limitParam)
 		if err != nil {
-			err = errors.WithMessage(entities.ErrInvalidParam, "invalid limit param")
-			s.log.Error(err)
-			s.writeError(w, err, http.StatusBadRequest)
+			s.writeError(w, r, invalidParam("limit", "invalid limit param"))
 			return
 		}
 	}
+	limit = s.currentSettings().pageSize(limit, limitParam != "")
 
 	orderBy := entities.Date
 	orderByParam := r.URL.Query().Get("order_by")
 	if orderByParam != "" {
 		if orderByParam != entities.Date && orderByParam != entities.Value {
-			err = errors.WithMessage(entities.ErrInvalidParam, "invalid order by param")
-			s.log.Error(err)
-			s.writeError(w, err, http.StatusBadRequest)
+			s.writeError(w, r, invalidParam("order_by", "invalid order by param"))
 			return
 		}
 		orderBy = orderByParam
@@ -594,17 +767,31 @@ func (s *Server) ListOperations(w http.ResponseWriter, r *http.Request) {
 	if descParam != "" {
 		desc, err = strconv.ParseBool(descParam)
 		if err != nil {
-			err = errors.WithMessage(entities.ErrInvalidParam, "invalid desc by param")
-			s.log.Error(err)
-			s.writeError(w, err, http.StatusBadRequest)
+			s.writeError(w, r, invalidParam("desc", "invalid desc by param"))
 			return
 		}
 	}
 
-	operations, err := s.svc.ListOperations(ctx, userID, limit, offset, orderBy, desc)
+	from, err := timeParam(r, "from")
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	to, err := timeParam(r, "to")
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	var operations []*entities.Operation
+	if from.IsZero() && to.IsZero() {
+		operations, err = s.svc.ListOperations(ctx, userID, limit, offset, orderBy, desc)
+	} else {
+		operations, err = s.svc.ListOperationsInRange(ctx, userID, from, to, limit, offset, orderBy, desc)
+	}
 	if err != nil {
-		s.log.Error(err)
-		s.writeError(w, entities.ErrInternal, http.StatusInternalServerError)
+		s.writeError(w, r, err)
 		return
 	}
 
@@ -618,19 +805,166 @@ func (s *Server) ListOperations(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 
 	if err = json.NewEncoder(w).Encode(operationDtos); err != nil {
-		s.log.Info(err)
+		s.logger(ctx).Info(err)
 	}
 }
 
-func (s *Server) writeError(w http.ResponseWriter, err error, code int) {
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(code)
+// timeParam parses optional RFC 3339 query param, zero when it is absent.
+func timeParam(r *http.Request, name string) (time.Time, error) {
+	param := r.URL.Query().Get(name)
+	if param == "" {
+		return time.Time{}, nil
+	}
 
-	resp := &dto.ErrResponse{
-		Message: err.Error(),
+	value, err := time.Parse(time.RFC3339, param)
+	if err != nil {
+		return time.Time{}, invalidParam(name, fmt.Sprintf("invalid %s param, expected RFC 3339 time", name))
 	}
 
-	if err = json.NewEncoder(w).Encode(resp); err != nil {
-		s.log.Info(err)
+	return value, nil
+}
+
+// BalanceEvents stream of user balance changes
+// swagger:operation GET /balances/{user_id}/events public BalanceEvents
+//
+// # BalanceEvents stream of user balance changes
+//
+// Server-Sent Events stream. Current balance is sent right after connect,
+// then every operation is followed by "operation" and "balance" events.
+//
+// ---
+// produces:
+// - text/event-stream
+// parameters:
+//   - name: X-API-Key
+//     in: header
+//     description: "Service API key, required unless Authorization is set"
+//     required: false
+//     type: string
+//   - name: Authorization
+//     in: header
+//     description: "Bearer token of the user, allows reading only own balance"
+//     required: false
+//     type: string
+//   - name: user_id
+//     in: path
+//     description: "User id"
+//     required: true
+//     type: string
+//
+// responses:
+//
+//	'200':
+//	 description: Success response
+//	'400':
+//	 description: Bad response
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
+//	'401':
+//	 description: Unauthorized
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
+//	'403':
+//	 description: Forbidden
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
+//	'429':
+//	 description: Too many requests, retry after Retry-After seconds
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
+//	'500':
+//	 description: Internal error
+//	 schema:
+//	  "$ref": "#/definitions/ErrResponse"
+func (s *Server) BalanceEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if !s.currentSettings().BalanceEvents {
+		s.writeError(w, r, errors.WithMessage(entities.ErrNotFound, "balance events are disabled"))
+		return
+	}
+
+	userID := chi.URLParam(r, userIDURLParam)
+	if userID == "" {
+		s.writeError(w, r, invalidParam(userIDURLParam, "empty balance id"))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		err := errors.WithMessage(entities.ErrInternal, "streaming unsupported")
+		s.writeError(w, r, err)
+		return
+	}
+
+	events, err := s.svc.SubscribeBalanceEvents(ctx, userID)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	balance, err := s.svc.GetUserBalance(ctx, userID)
+	if err != nil && !errors.Is(err, entities.ErrNotFound) {
+		s.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/event-stream")
+	w.Header().Add("Cache-Control", "no-cache")
+	w.Header().Add("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if balance != nil {
+		s.writeEvent(ctx, w, balanceEventName, &dto.Balance{
+			UserID:   balance.UserID(),
+			Currency: int(balance.Value()),
+		})
 	}
+	flusher.Flush()
+
+	ping := time.NewTicker(eventsPingPeriod)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stopping:
+			return
+		case <-ping.C:
+			if _, err = fmt.Fprint(w, ": ping\n\n"); err != nil {
+				s.logger(ctx).Info(err)
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			operation := dto.ToOperation(event.Operation())
+			s.writeEvent(ctx, w, operationEventName, &operation)
+			s.writeEvent(ctx, w, balanceEventName, &dto.Balance{
+				UserID:   event.Balance().UserID(),
+				Currency: int(event.Balance().Value()),
+			})
+		}
+
+		flusher.Flush()
+	}
+}
+
+func (s *Server) writeEvent(ctx context.Context, w http.ResponseWriter, event string, data any) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		s.logger(ctx).Error(err)
+		return
+	}
+
+	if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		s.logger(ctx).Info(err)
+	}
+}
+
+func (s *Server) logger(ctx context.Context) *zap.SugaredLogger {
+	return logging.FromContext(ctx, s.log)
 }
@@ -0,0 +1,839 @@
+package http_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	jwtadapter "service/internal/adapters/jwt"
+	"service/internal/adapters/metrics"
+	"service/internal/adapters/ratelimit"
+	"service/internal/cases"
+	"service/internal/entities"
+	httpport "service/internal/ports/http"
+	"service/pkg/errcodes"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const requestDuration = 200 * time.Millisecond
+
+// slowService answers slowly and fails every request that finishes after
+// storage was closed, as the real service would.
+type slowService struct {
+	started sync.WaitGroup
+	closed  atomic.Bool
+}
+
+func (s *slowService) GetUserBalance(_ context.Context, userID string) (*entities.Balance, error) {
+	s.started.Done()
+	time.Sleep(requestDuration)
+
+	if s.closed.Load() {
+		return nil, entities.ErrInternal
+	}
+
+	return entities.NewBalance(userID, 100), nil
+}
+
+func (s *slowService) CreditBalance(context.Context, string, entities.Currency, string) error {
+	return entities.ErrInternal
+}
+
+func (s *slowService) ReserveFromBalance(context.Context, string, string, string, entities.Currency) error {
+	return entities.ErrInternal
+}
+
+func (s *slowService) CommitReserve(context.Context, string, string, string, entities.Currency) error {
+	return entities.ErrInternal
+}
+
+func (s *slowService) ListOperations(context.Context, string, int, int, string, bool) ([]*entities.Operation, error) {
+	return nil, entities.ErrInternal
+}
+
+func (s *slowService) ListOperationsInRange(
+	context.Context, string, time.Time, time.Time, int, int, string, bool,
+) ([]*entities.Operation, error) {
+	return nil, entities.ErrInternal
+}
+
+func (s *slowService) SubscribeBalanceEvents(context.Context, string) (<-chan *entities.BalanceEvent, error) {
+	return make(chan *entities.BalanceEvent), nil
+}
+
+type readerAuth struct{}
+
+func (readerAuth) Authenticate(context.Context, string) (*entities.APIKey, error) {
+	return entities.NewAPIKey("key", "shop", []entities.Permission{entities.PermissionRead}, time.Now()), nil
+}
+
+func (readerAuth) AuthenticateToken(context.Context, string) (string, error) {
+	return "", entities.ErrUnauthorized
+}
+
+func (readerAuth) AuthenticateAdmin(context.Context, string) error {
+	return entities.ErrForbidden
+}
+
+func (readerAuth) CreateAPIKey(context.Context, string, []entities.Permission) (*entities.APIKey, string, error) {
+	return nil, "", entities.ErrForbidden
+}
+
+func (readerAuth) ListAPIKeys(context.Context) ([]*entities.APIKey, error) {
+	return nil, entities.ErrForbidden
+}
+
+func (readerAuth) RevokeAPIKey(context.Context, string) error {
+	return entities.ErrForbidden
+}
+
+type noKeys struct{}
+
+func (noKeys) CreateAPIKey(context.Context, *entities.APIKey, string) error {
+	return entities.ErrInternal
+}
+
+func (noKeys) GetAPIKeyByHash(context.Context, string) (*entities.APIKey, error) {
+	return nil, entities.ErrNotFound
+}
+
+func (noKeys) ListAPIKeys(context.Context) ([]*entities.APIKey, error) {
+	return nil, entities.ErrInternal
+}
+
+func (noKeys) DeleteAPIKey(context.Context, string) error {
+	return entities.ErrInternal
+}
+
+func newRateLimits(t *testing.T) *httpport.RateLimits {
+	t.Helper()
+
+	limits, err := httpport.NewRateLimits(ratelimit.NewMemory(), httpport.RateLimitFailOpen, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return limits
+}
+
+type readyHealth struct{}
+
+func (readyHealth) Ready(context.Context) error {
+	return nil
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	const requests = 20
+
+	svc := &slowService{}
+	srv, err := httpport.NewServer(zap.NewNop().Sugar(), svc, readerAuth{}, newRateLimits(t), readyHealth{}, metrics.NewPrometheus(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	baseURL := "http://" + lis.Addr().String()
+
+	// event stream reads balance before subscribing
+	svc.started.Add(requests + 1)
+
+	// event stream never ends on its own and must not hold shutdown
+	stream, err := http.Get(baseURL + "/api/v1/balances/user/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+
+	statuses := make(chan int, requests)
+
+	for i := 0; i < requests; i++ {
+		go func(i int) {
+			resp, err := http.Get(fmt.Sprintf("%s/api/v1/balances/user%d", baseURL, i))
+			if err != nil {
+				t.Error(err)
+				statuses <- 0
+				return
+			}
+			defer resp.Body.Close()
+
+			statuses <- resp.StatusCode
+		}(i)
+	}
+
+	svc.started.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+
+	if err = srv.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed > requestDuration*5 {
+		t.Fatalf("shutdown took %s", elapsed)
+	}
+
+	svc.closed.Store(true)
+
+	for i := 0; i < requests; i++ {
+		if status := <-statuses; status != http.StatusOK {
+			t.Fatalf("expected in-flight request to succeed, got status %d", status)
+		}
+	}
+
+	if _, err = http.Get(baseURL + "/healthz"); err == nil {
+		t.Fatal("expected new connections to be refused after shutdown")
+	}
+}
+
+func TestServer_ShutdownDrainDelay(t *testing.T) {
+	const drainDelay = 200 * time.Millisecond
+
+	srv, err := httpport.NewServer(zap.NewNop().Sugar(), &slowService{}, readerAuth{}, newRateLimits(t), readyHealth{}, metrics.NewPrometheus(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = srv.SetSettings(httpport.Settings{PageSize: 10, DrainDelay: drainDelay}); err != nil {
+		t.Fatal(err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	baseURL := "http://" + lis.Addr().String()
+
+	done := make(chan error, 1)
+	start := time.Now()
+
+	go func() { done <- srv.Shutdown(context.Background()) }()
+
+	// readiness fails while the server keeps accepting connections
+	resp, err := http.Get(baseURL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("readyz during drain = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < drainDelay {
+		t.Errorf("shutdown took %s, want at least drain delay %s", elapsed, drainDelay)
+	}
+
+	if _, err = http.Get(baseURL + "/healthz"); err == nil {
+		t.Fatal("expected new connections to be refused after shutdown")
+	}
+}
+
+func TestServer_BearerToken(t *testing.T) {
+	log := zap.NewNop().Sugar()
+
+	verifier, err := jwtadapter.NewVerifier(jwtadapter.Options{Algorithm: jwtadapter.AlgorithmHS256, Secret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authSvc, err := cases.NewAuthService(log, noKeys{}, verifier, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := &slowService{}
+	srv, err := httpport.NewServer(log, svc, authSvc, newRateLimits(t), readyHealth{}, metrics.NewPrometheus(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   "user",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the first request reaches GetUserBalance
+	svc.started.Add(1)
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{http.MethodGet, "/api/v1/balances/user", token, http.StatusOK},
+		{http.MethodGet, "/api/v1/balances/other", token, http.StatusForbidden},
+		{http.MethodGet, "/api/v1/balances/user/operations", token, http.StatusInternalServerError},
+		{http.MethodPost, "/api/v1/balances/user/credit", token, http.StatusForbidden},
+		{http.MethodGet, "/api/v1/balances/user", token + "x", http.StatusUnauthorized},
+		{http.MethodGet, "/api/v1/balances/user", "", http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest(tc.method, tc.path, strings.NewReader(`{"currency": 10}`))
+		if tc.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, r)
+
+		if w.Code != tc.status {
+			t.Fatalf("%s %s: expected %d, got %d %s", tc.method, tc.path, tc.status, w.Code, w.Body)
+		}
+	}
+}
+
+func TestServer_ErrorResponse(t *testing.T) {
+	srv, err := httpport.NewServer(zap.NewNop().Sugar(), &slowService{}, readerAuth{}, newRateLimits(t), readyHealth{}, metrics.NewPrometheus(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/balances/user/credit", strings.NewReader(`{"currency": 10}`))
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, r)
+
+	var body map[string]any
+	if err = json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	// clients of the old ErrResponse read Message
+	if body["code"] != errcodes.Forbidden || body["Message"] == "" || body["Message"] != body["detail"] {
+		t.Fatalf("unexpected error response %s", w.Body)
+	}
+}
+
+func TestServer_RateLimitAfterAllow(t *testing.T) {
+	limits, err := httpport.NewRateLimits(ratelimit.NewMemory(), httpport.RateLimitFailOpen, []httpport.RateLimitRule{
+		{Route: httpport.RateLimitAnyRoute, By: httpport.RateLimitByClient, Rate: 0.001, Burst: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := httpport.NewServer(zap.NewNop().Sugar(), &slowService{}, readerAuth{}, limits, readyHealth{}, metrics.NewPrometheus(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// forbidden credits do not take the only token of the client
+	for _, tc := range []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodPost, "/api/v1/balances/user/credit", http.StatusForbidden},
+		{http.MethodPost, "/api/v1/balances/user/credit", http.StatusForbidden},
+		{http.MethodGet, "/api/v1/balances/user/operations", http.StatusInternalServerError},
+		{http.MethodGet, "/api/v1/balances/user/operations", http.StatusTooManyRequests},
+	} {
+		r := httptest.NewRequest(tc.method, tc.path, strings.NewReader(`{"currency": 10}`))
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, r)
+
+		if w.Code != tc.status {
+			t.Fatalf("%s %s: expected %d, got %d %s", tc.method, tc.path, tc.status, w.Code, w.Body)
+		}
+	}
+}
+
+type failingLimiter struct{}
+
+func (failingLimiter) Take(context.Context, string, float64, int) (time.Duration, error) {
+	return 0, entities.ErrInternal
+}
+
+func TestServer_RateLimiterFailure(t *testing.T) {
+	for _, tc := range []struct {
+		onError string
+		status  int
+	}{
+		{httpport.RateLimitFailOpen, http.StatusOK},
+		{httpport.RateLimitFailClosed, http.StatusInternalServerError},
+	} {
+		limits, err := httpport.NewRateLimits(failingLimiter{}, tc.onError, []httpport.RateLimitRule{
+			{Route: httpport.RateLimitAnyRoute, By: httpport.RateLimitByUser, Rate: 1, Burst: 1},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		svc := &slowService{}
+		svc.started.Add(1)
+
+		srv, err := httpport.NewServer(zap.NewNop().Sugar(), svc, readerAuth{}, limits, readyHealth{}, metrics.NewPrometheus(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/balances/user", nil))
+
+		if w.Code != tc.status {
+			t.Errorf("on error %s: expected %d, got %d %s", tc.onError, tc.status, w.Code, w.Body)
+		}
+	}
+
+	if _, err := httpport.NewRateLimits(failingLimiter{}, "", nil); err == nil {
+		t.Error("expected empty on error to be rejected")
+	}
+}
+
+// eventService streams events pushed to events, the subscription ends when
+// ctx of the request is done or events is closed, as the real service does.
+type eventService struct {
+	slowService
+	events       chan *entities.BalanceEvent
+	unsubscribed chan struct{}
+}
+
+func newEventService() *eventService {
+	return &eventService{
+		events:       make(chan *entities.BalanceEvent),
+		unsubscribed: make(chan struct{}),
+	}
+}
+
+func (s *eventService) GetUserBalance(_ context.Context, userID string) (*entities.Balance, error) {
+	return entities.NewBalance(userID, 100), nil
+}
+
+func (s *eventService) SubscribeBalanceEvents(ctx context.Context, _ string) (<-chan *entities.BalanceEvent, error) {
+	go func() {
+		<-ctx.Done()
+		close(s.unsubscribed)
+	}()
+
+	return s.events, nil
+}
+
+// sseEvent is an event of text/event-stream, comments are skipped.
+type sseEvent struct {
+	name string
+	data string
+}
+
+func readEvent(t *testing.T, r *bufio.Reader) (sseEvent, error) {
+	t.Helper()
+
+	var event sseEvent
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return event, err
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case line == "" && event.name != "":
+			return event, nil
+		case strings.HasPrefix(line, "event: "):
+			event.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func openEvents(t *testing.T, svc *eventService) (*http.Response, *bufio.Reader) {
+	t.Helper()
+
+	srv, err := httpport.NewServer(zap.NewNop().Sugar(), svc, readerAuth{}, newRateLimits(t), readyHealth{}, metrics.NewPrometheus(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+
+	resp, err := http.Get(ts.URL + "/api/v1/balances/user/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("events: status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	body := bufio.NewReader(resp.Body)
+
+	// the stream starts with the current balance
+	event, err := readEvent(t, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if event.name != "balance" || event.data != `{"user_id":"user","currency":100}` {
+		t.Fatalf("first event %+v, want current balance", event)
+	}
+
+	return resp, body
+}
+
+func TestServer_BalanceEvents(t *testing.T) {
+	svc := newEventService()
+	_, body := openEvents(t, svc)
+
+	operation := entities.NewOperation("user", "shop", "order", entities.Debit, 30, time.Now())
+	svc.events <- entities.NewBalanceEvent(entities.NewBalance("user", 70), operation)
+
+	event, err := readEvent(t, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if event.name != "operation" || !strings.Contains(event.data, `"order_id":"order"`) {
+		t.Errorf("operation event %+v", event)
+	}
+
+	event, err = readEvent(t, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if event.name != "balance" || event.data != `{"user_id":"user","currency":70}` {
+		t.Errorf("balance event %+v, want balance after the operation", event)
+	}
+}
+
+func TestServer_BalanceEventsClientDisconnect(t *testing.T) {
+	svc := newEventService()
+	resp, _ := openEvents(t, svc)
+
+	resp.Body.Close()
+
+	select {
+	case <-svc.unsubscribed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription is not cancelled after client disconnected")
+	}
+}
+
+func TestServer_BalanceEventsUnsubscribed(t *testing.T) {
+	svc := newEventService()
+	_, body := openEvents(t, svc)
+
+	// the service ends the subscription, e.g. the storage is closed
+	close(svc.events)
+
+	if event, err := readEvent(t, body); !errors.Is(err, io.EOF) {
+		t.Fatalf("read %+v, %v, want stream closed", event, err)
+	}
+
+	select {
+	case <-svc.unsubscribed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request is not finished after subscription ended")
+	}
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) issue(t *testing.T, cn string, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestServer_ClientCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	log := zap.NewNop().Sugar()
+
+	authSvc, err := cases.NewAuthService(log, noKeys{}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := &slowService{}
+	srv, err := httpport.NewServer(log, svc, authSvc, newRateLimits(t), readyHealth{}, metrics.NewPrometheus(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.UseTLS(&tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{ca.issue(t, "server", x509.ExtKeyUsageServerAuth)},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}, []entities.Permission{entities.PermissionRead, entities.PermissionReserve})
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			t.Error(err)
+		}
+	}()
+	t.Cleanup(func() { _ = srv.Shutdown(context.Background()) })
+
+	baseURL := "https://" + lis.Addr().String()
+
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			RootCAs:      pool,
+			Certificates: certs,
+		}}}
+	}
+
+	shop := newClient(ca.issue(t, "shop", x509.ExtKeyUsageClientAuth))
+
+	// only the first request reaches GetUserBalance
+	svc.started.Add(1)
+
+	for _, tc := range []struct {
+		client *http.Client
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{shop, http.MethodGet, "/api/v1/balances/user", "", http.StatusOK},
+		{shop, http.MethodPost, "/api/v1/balances/user/reserve", `{"service_id":"other","order_id":"1","currency":1}`,
+			http.StatusForbidden},
+		{shop, http.MethodPost, "/api/v1/balances/user/credit", `{"currency":1}`, http.StatusForbidden},
+		{newClient(), http.MethodGet, "/api/v1/balances/user", "", http.StatusUnauthorized},
+	} {
+		req, err := http.NewRequest(tc.method, baseURL+tc.path, strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := tc.client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.status {
+			t.Fatalf("%s %s: expected %d, got %d", tc.method, tc.path, tc.status, resp.StatusCode)
+		}
+	}
+}
+
+// pageService records requested page size.
+type pageService struct {
+	slowService
+	limit atomic.Int64
+}
+
+func (s *pageService) ListOperations(_ context.Context, _ string, limit, _ int, _ string, _ bool) ([]*entities.Operation, error) {
+	s.limit.Store(int64(limit))
+	return nil, nil
+}
+
+func TestServer_SetSettings(t *testing.T) {
+	log := zap.NewNop().Sugar()
+
+	svc := &pageService{}
+	srv, err := httpport.NewServer(log, svc, readerAuth{}, newRateLimits(t), readyHealth{}, metrics.NewPrometheus(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = srv.SetSettings(httpport.Settings{PageSize: 10, DrainDelay: -time.Second}); err == nil {
+		t.Fatal("expected negative drain delay to be rejected")
+	}
+
+	if err = srv.SetSettings(httpport.Settings{PageSize: 10, MaxPageSize: 5}); err == nil {
+		t.Fatal("expected max page size below page size to be rejected")
+	}
+
+	if err = srv.SetSettings(httpport.Settings{PageSize: 20, MaxPageSize: 50, BalanceEvents: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string) int {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("X-API-Key", "key")
+
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, r)
+
+		return w.Code
+	}
+
+	for _, tc := range []struct {
+		query string
+		limit int64
+	}{
+		{"", 20},
+		{"?limit=30", 30},
+		{"?limit=100", 50},
+		{"?limit=0", 50},
+	} {
+		if code := get("/api/v1/balances/user/operations" + tc.query); code != http.StatusOK {
+			t.Fatalf("%q: expected 200, got %d", tc.query, code)
+		}
+
+		if limit := svc.limit.Load(); limit != tc.limit {
+			t.Errorf("%q: limit = %d, want %d", tc.query, limit, tc.limit)
+		}
+	}
+
+	if err = srv.SetSettings(httpport.Settings{PageSize: 20}); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := get("/api/v1/balances/user/events"); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for disabled balance events, got %d", code)
+	}
+}
+
+// rangeService records requested range.
+type rangeService struct {
+	slowService
+	from, to time.Time
+}
+
+func (s *rangeService) ListOperationsInRange(
+	_ context.Context, _ string, from, to time.Time, _, _ int, _ string, _ bool,
+) ([]*entities.Operation, error) {
+	s.from, s.to = from, to
+	return nil, nil
+}
+
+func TestServer_ListOperationsRange(t *testing.T) {
+	log := zap.NewNop().Sugar()
+
+	svc := &rangeService{}
+	srv, err := httpport.NewServer(log, svc, readerAuth{}, newRateLimits(t), readyHealth{}, metrics.NewPrometheus(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(query string) int {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/balances/user/operations"+query, nil)
+		r.Header.Set("X-API-Key", "key")
+
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, r)
+
+		return w.Code
+	}
+
+	if code := get("?from=2022-01-01T00:00:00Z&to=2022-02-01T00:00:00Z"); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+
+	from := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	if !svc.from.Equal(from) || !svc.to.Equal(from.AddDate(0, 1, 0)) {
+		t.Errorf("range = [%s, %s), want January 2022", svc.from, svc.to)
+	}
+
+	if code := get("?from=yesterday"); code != http.StatusBadRequest {
+		t.Errorf("invalid from: expected 400, got %d", code)
+	}
+
+	// without range live operations are listed, which slowService fails
+	if code := get(""); code != http.StatusInternalServerError {
+		t.Errorf("without range: expected 500, got %d", code)
+	}
+}
@@ -0,0 +1,66 @@
+package http
+
+import (
+	"github.com/pkg/errors"
+	"service/internal/entities"
+	"time"
+)
+
+const defaultPageSize = 10
+
+// Settings are tunables which may be replaced while server is running.
+type Settings struct {
+	// PageSize is used when limit param is not given.
+	PageSize int
+	// MaxPageSize caps limit param, 0 is unlimited.
+	MaxPageSize int
+	// BalanceEvents enables SSE stream of balance changes.
+	BalanceEvents bool
+	// DrainDelay is how long Shutdown keeps serving after /readyz starts
+	// failing, so load balancers stop routing before connections close.
+	DrainDelay time.Duration
+}
+
+// DefaultSettings keeps behaviour of server without configured tunables.
+func DefaultSettings() Settings {
+	return Settings{
+		PageSize:      defaultPageSize,
+		BalanceEvents: true,
+	}
+}
+
+// SetSettings atomically replaces settings, requests in flight keep the old ones.
+func (s *Server) SetSettings(settings Settings) error {
+	if settings.PageSize < 1 {
+		return errors.WithMessagef(entities.ErrInvalidParam, "page size %d", settings.PageSize)
+	}
+
+	if settings.MaxPageSize < 0 || (settings.MaxPageSize != 0 && settings.MaxPageSize < settings.PageSize) {
+		return errors.WithMessagef(entities.ErrInvalidParam, "max page size %d", settings.MaxPageSize)
+	}
+
+	if settings.DrainDelay < 0 {
+		return errors.WithMessagef(entities.ErrInvalidParam, "negative drain delay %s", settings.DrainDelay)
+	}
+
+	s.settings.Store(&settings)
+
+	return nil
+}
+
+func (s *Server) currentSettings() Settings {
+	return *s.settings.Load()
+}
+
+// pageSize applies defaults and cap to requested limit.
+func (settings Settings) pageSize(limit int, given bool) int {
+	if !given {
+		limit = settings.PageSize
+	}
+
+	if settings.MaxPageSize != 0 && (limit == 0 || limit > settings.MaxPageSize) {
+		limit = settings.MaxPageSize
+	}
+
+	return limit
+}
@@ -0,0 +1,35 @@
+package http
+
+import (
+	"crypto/tls"
+	"net/http"
+	"service/internal/entities"
+)
+
+// UseTLS makes server accept only TLS connections. When config verifies client
+// certificates, a verified certificate authenticates the service named by its
+// CN with clientPermissions, as an API key would.
+func (s *Server) UseTLS(config *tls.Config, clientPermissions []entities.Permission) {
+	s.server.TLSConfig = config
+	s.certPermissions = clientPermissions
+}
+
+// certificateKey returns principal of verified client certificate, nil if
+// there is none.
+func (s *Server) certificateKey(r *http.Request) *entities.APIKey {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	if cert.Subject.CommonName == "" {
+		return nil
+	}
+
+	return entities.NewAPIKey(
+		"cert:"+cert.SerialNumber.String(),
+		cert.Subject.CommonName,
+		s.certPermissions,
+		cert.NotBefore,
+	)
+}
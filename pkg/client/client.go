@@ -0,0 +1,335 @@
+// Package client is a Go client for the balance service HTTP API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"net/url"
+	"service/pkg/dto"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	OrderByDate  = "date"
+	OrderByValue = "value"
+
+	idempotencyKeyHeader = "Idempotency-Key"
+	apiKeyHeader         = "X-API-Key"
+	consistencyHeader    = "X-Consistency-Token"
+	basePath             = "/api/v1"
+
+	defaultTimeout = 10 * time.Second
+	defaultRetries = 2
+	defaultBackoff = 100 * time.Millisecond
+)
+
+type Option func(c *Client)
+
+// WithHTTPClient replaces http.DefaultClient.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTimeout bounds every attempt unless ctx has an earlier deadline. Zero disables it.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithRetries sets how many times a failed request is repeated, waiting
+// backoff, 2*backoff, 4*backoff... between attempts.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// WithAPIKey authenticates requests with service API key.
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// Client reads its own writes: token of the last mutation is passed with
+// reads, so server does not serve them from a replica lagging behind it.
+type Client struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+	timeout    time.Duration
+	retries    int
+	backoff    time.Duration
+	token      atomic.Value
+}
+
+func New(baseURL string, opts ...Option) (*Client, error) {
+	if baseURL == "" {
+		return nil, errors.WithMessage(ErrInvalidParam, "empty base url")
+	}
+
+	if _, err := url.Parse(baseURL); err != nil {
+		return nil, errors.WithMessage(ErrInvalidParam, err.Error())
+	}
+
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/") + basePath,
+		httpClient: http.DefaultClient,
+		timeout:    defaultTimeout,
+		retries:    defaultRetries,
+		backoff:    defaultBackoff,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c, nil
+}
+
+func (c *Client) GetUserBalance(ctx context.Context, userID string) (*dto.Balance, error) {
+	balance := &dto.Balance{}
+
+	err := c.do(ctx, &call{
+		method: http.MethodGet,
+		path:   balancePath(userID, ""),
+		out:    balance,
+		retry:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return balance, nil
+}
+
+// CreditBalance is retried with the same idempotency key, so the value is credited once.
+func (c *Client) CreditBalance(ctx context.Context, userID string, request *dto.CreditRequest) error {
+	return c.do(ctx, &call{
+		method:         http.MethodPost,
+		path:           balancePath(userID, "credit"),
+		in:             request,
+		idempotencyKey: uuid.New().String(),
+		retry:          true,
+	})
+}
+
+// ReserveFromBalance is retried as order id makes it idempotent: ErrReserveAlreadyExists
+// after a failed attempt means that attempt has reserved the value.
+func (c *Client) ReserveFromBalance(ctx context.Context, userID string, request *dto.ReserveRequest) error {
+	return c.do(ctx, &call{
+		method:            http.MethodPost,
+		path:              balancePath(userID, "reserve"),
+		in:                request,
+		retry:             true,
+		conflictOnRetryOK: true,
+	})
+}
+
+// CommitReserve is never retried: commit may be partial, so repeating it could commit twice.
+func (c *Client) CommitReserve(ctx context.Context, userID string, request *dto.CommitReserveRequest) error {
+	return c.do(ctx, &call{
+		method: http.MethodPost,
+		path:   balancePath(userID, "commit"),
+		in:     request,
+	})
+}
+
+type ListParams struct {
+	Limit   int
+	Offset  int
+	OrderBy string
+	Desc    *bool
+	// From and To bound creation time of operations, archived operations
+	// are listed when any of them is set.
+	From time.Time
+	To   time.Time
+}
+
+func (c *Client) ListOperations(ctx context.Context, userID string, params *ListParams) ([]dto.Operation, error) {
+	query := url.Values{}
+	if params != nil {
+		if params.Limit != 0 {
+			query.Set("limit", strconv.Itoa(params.Limit))
+		}
+		if params.Offset != 0 {
+			query.Set("offset", strconv.Itoa(params.Offset))
+		}
+		if params.OrderBy != "" {
+			query.Set("order_by", params.OrderBy)
+		}
+		if params.Desc != nil {
+			query.Set("desc", strconv.FormatBool(*params.Desc))
+		}
+		if !params.From.IsZero() {
+			query.Set("from", params.From.Format(time.RFC3339))
+		}
+		if !params.To.IsZero() {
+			query.Set("to", params.To.Format(time.RFC3339))
+		}
+	}
+
+	path := balancePath(userID, "operations")
+	if len(query) != 0 {
+		path += "?" + query.Encode()
+	}
+
+	operations := make([]dto.Operation, 0)
+
+	err := c.do(ctx, &call{
+		method: http.MethodGet,
+		path:   path,
+		out:    &operations,
+		retry:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return operations, nil
+}
+
+type call struct {
+	method            string
+	path              string
+	in                any
+	out               any
+	idempotencyKey    string
+	retry             bool
+	conflictOnRetryOK bool
+}
+
+func (c *Client) do(ctx context.Context, call *call) error {
+	var body []byte
+
+	if call.in != nil {
+		var err error
+
+		body, err = json.Marshal(call.in)
+		if err != nil {
+			return errors.WithMessage(ErrInvalidParam, err.Error())
+		}
+	}
+
+	attempts := 1
+	if call.retry {
+		attempts += c.retries
+	}
+
+	var err error
+
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(c.backoff << (attempt - 1)):
+			}
+		}
+
+		var retryable bool
+
+		retryable, err = c.attempt(ctx, call, body)
+		if attempt > 0 && call.conflictOnRetryOK && errors.Is(err, ErrReserveAlreadyExists) {
+			return nil
+		}
+		if err == nil || !retryable || ctx.Err() != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// nolint:errcheck // safety in library
+func (c *Client) attempt(ctx context.Context, call *call, body []byte) (bool, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, call.method, c.baseURL+call.path, bytes.NewReader(body))
+	if err != nil {
+		return false, errors.WithMessage(ErrInvalidParam, err.Error())
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if call.idempotencyKey != "" {
+		req.Header.Set(idempotencyKeyHeader, call.idempotencyKey)
+	}
+	if c.apiKey != "" {
+		req.Header.Set(apiKeyHeader, c.apiKey)
+	}
+	if token, _ := c.token.Load().(string); token != "" && call.method == http.MethodGet {
+		req.Header.Set(consistencyHeader, token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if token := resp.Header.Get(consistencyHeader); token != "" {
+		c.token.Store(token)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		errResp := &dto.ErrResponse{}
+		if err = json.NewDecoder(resp.Body).Decode(errResp); err != nil {
+			errResp.Message = http.StatusText(resp.StatusCode)
+		}
+
+		return retryableStatus(resp.StatusCode), newResponseError(resp.StatusCode, errResp)
+	}
+
+	if call.out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(call.out); err != nil {
+		return true, errors.WithMessage(ErrInternal, err.Error())
+	}
+
+	return false, nil
+}
+
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+func balancePath(userID, action string) string {
+	path := fmt.Sprintf("/balances/%s", url.PathEscape(userID))
+	if action != "" {
+		path += "/" + action
+	}
+
+	return path
+}
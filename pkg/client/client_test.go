@@ -0,0 +1,541 @@
+package client_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"service/internal/adapters/metrics"
+	"service/internal/adapters/ratelimit"
+	"service/internal/cases"
+	"service/internal/consistency"
+	"service/internal/entities"
+	httpport "service/internal/ports/http"
+	"service/pkg/client"
+	"service/pkg/dto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeService struct {
+	mu        sync.Mutex
+	balances  map[string]entities.Currency
+	credits   map[string]struct{}
+	reserves  map[string]struct{}
+	readToken string
+}
+
+func newFakeService() *fakeService {
+	return &fakeService{
+		balances: make(map[string]entities.Currency),
+		credits:  make(map[string]struct{}),
+		reserves: make(map[string]struct{}),
+	}
+}
+
+func (f *fakeService) GetUserBalance(ctx context.Context, userID string) (*entities.Balance, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.readToken = consistency.Token(ctx)
+
+	value, ok := f.balances[userID]
+	if !ok {
+		return nil, errors.WithMessage(entities.ErrNotFound, "balance not found")
+	}
+
+	return entities.NewBalance(userID, value), nil
+}
+
+func (f *fakeService) CreditBalance(ctx context.Context, userID string, value entities.Currency, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.credits[key]; ok && key != "" {
+		return nil
+	}
+
+	f.credits[key] = struct{}{}
+	f.balances[userID] += value
+
+	consistency.Record(ctx, fmt.Sprintf("0/%X", len(f.credits)))
+
+	return nil
+}
+
+func (f *fakeService) ReserveFromBalance(
+	_ context.Context,
+	userID string,
+	serviceID string,
+	orderID string,
+	value entities.Currency,
+) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := userID + "/" + serviceID + "/" + orderID
+	if _, ok := f.reserves[key]; ok {
+		return entities.ErrReserveAlreadyExists
+	}
+
+	if f.balances[userID] < value {
+		return entities.ErrReserveInvalidValue
+	}
+
+	f.reserves[key] = struct{}{}
+	f.balances[userID] -= value
+
+	return nil
+}
+
+func (f *fakeService) CommitReserve(context.Context, string, string, string, entities.Currency) error {
+	return errors.WithMessage(entities.ErrCommitInvalidValue, "commit")
+}
+
+func (f *fakeService) ListOperations(
+	context.Context,
+	string,
+	int, int,
+	string, bool,
+) ([]*entities.Operation, error) {
+	return []*entities.Operation{
+		entities.NewOperation("user", "shop", "1", entities.Debit, 10, time.Now()),
+	}, nil
+}
+
+func (f *fakeService) ListOperationsInRange(
+	ctx context.Context,
+	userID string,
+	_, _ time.Time,
+	limit, offset int,
+	sortBy string, desc bool,
+) ([]*entities.Operation, error) {
+	return f.ListOperations(ctx, userID, limit, offset, sortBy, desc)
+}
+
+func (f *fakeService) SubscribeBalanceEvents(context.Context, string) (<-chan *entities.BalanceEvent, error) {
+	return nil, entities.ErrInternal
+}
+
+type keyStorage struct {
+	mu     sync.Mutex
+	hashes map[string]*entities.APIKey
+}
+
+func (k *keyStorage) CreateAPIKey(_ context.Context, key *entities.APIKey, hash string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.hashes[hash] = key
+
+	return nil
+}
+
+func (k *keyStorage) GetAPIKeyByHash(_ context.Context, hash string) (*entities.APIKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key, ok := k.hashes[hash]
+	if !ok {
+		return nil, entities.ErrNotFound
+	}
+
+	return key, nil
+}
+
+func (k *keyStorage) ListAPIKeys(context.Context) ([]*entities.APIKey, error) {
+	return nil, entities.ErrInternal
+}
+
+func (k *keyStorage) DeleteAPIKey(context.Context, string) error {
+	return entities.ErrInternal
+}
+
+type readyHealth struct{}
+
+func (readyHealth) Ready(context.Context) error {
+	return nil
+}
+
+// flaky fails first n requests with 503 after passing them to the real handler,
+// as if the response was lost on the way back.
+type flaky struct {
+	mu      sync.Mutex
+	n       int
+	keys    []string
+	handler http.Handler
+}
+
+func (f *flaky) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.keys = append(f.keys, r.Header.Get("Idempotency-Key"))
+	fail := f.n > 0
+	f.n--
+	f.mu.Unlock()
+
+	if !fail {
+		f.handler.ServeHTTP(w, r)
+		return
+	}
+
+	f.handler.ServeHTTP(httptest.NewRecorder(), r)
+	w.WriteHeader(http.StatusServiceUnavailable)
+}
+
+type testServer struct {
+	url     string
+	auth    *cases.AuthService
+	handler *flaky
+}
+
+func newTestServer(t *testing.T, svc *fakeService, failures int, rules ...httpport.RateLimitRule) *testServer {
+	t.Helper()
+
+	log := zap.NewNop().Sugar()
+
+	limits, err := httpport.NewRateLimits(ratelimit.NewMemory(), httpport.RateLimitFailOpen, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authSvc, err := cases.NewAuthService(log, &keyStorage{hashes: make(map[string]*entities.APIKey)}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := httpport.NewServer(log, svc, authSvc, limits, readyHealth{}, metrics.NewPrometheus(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := &flaky{n: failures, handler: srv.Handler()}
+
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	return &testServer{url: ts.URL, auth: authSvc, handler: handler}
+}
+
+func (s *testServer) client(t *testing.T, serviceID string, permissions ...entities.Permission) *client.Client {
+	t.Helper()
+
+	_, secret, err := s.auth.CreateAPIKey(context.Background(), serviceID, permissions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := client.New(s.url, client.WithRetries(3, time.Millisecond), client.WithAPIKey(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func newTestClient(t *testing.T, svc *fakeService, failures int) (*client.Client, *flaky) {
+	t.Helper()
+
+	srv := newTestServer(t, svc, failures)
+
+	c := srv.client(t, "shop",
+		entities.PermissionCredit,
+		entities.PermissionReserve,
+		entities.PermissionCommit,
+		entities.PermissionRead,
+	)
+
+	return c, srv.handler
+}
+
+func TestClient_Balance(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestClient(t, newFakeService(), 0)
+
+	_, err := c.GetUserBalance(ctx, "user")
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if err = c.CreditBalance(ctx, "user", &dto.CreditRequest{Currency: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	balance, err := c.GetUserBalance(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if balance.Currency != 100 {
+		t.Fatalf("expected 100, got %d", balance.Currency)
+	}
+}
+
+func TestClient_ReadYourWrites(t *testing.T) {
+	ctx := context.Background()
+	svc := newFakeService()
+	c, _ := newTestClient(t, svc, 0)
+
+	readToken := func() string {
+		svc.mu.Lock()
+		defer svc.mu.Unlock()
+
+		return svc.readToken
+	}
+
+	if _, err := c.GetUserBalance(ctx, "user"); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if token := readToken(); token != "" {
+		t.Fatalf("read before any write has token %q", token)
+	}
+
+	for _, want := range []string{"0/1", "0/2"} {
+		if err := c.CreditBalance(ctx, "user", &dto.CreditRequest{Currency: 100}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := c.GetUserBalance(ctx, "user"); err != nil {
+			t.Fatal(err)
+		}
+
+		if token := readToken(); token != want {
+			t.Fatalf("read token = %q, want %q", token, want)
+		}
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	ctx := context.Background()
+	svc := newFakeService()
+	svc.balances["user"] = 10
+	c, _ := newTestClient(t, svc, 0)
+
+	reserve := &dto.ReserveRequest{ServiceID: "shop", OrderID: "1", Currency: 5}
+
+	if err := c.ReserveFromBalance(ctx, "user", reserve); err != nil {
+		t.Fatal(err)
+	}
+
+	err := c.ReserveFromBalance(ctx, "user", reserve)
+	if !errors.Is(err, client.ErrReserveAlreadyExists) {
+		t.Fatalf("expected ErrReserveAlreadyExists, got %v", err)
+	}
+
+	err = c.ReserveFromBalance(ctx, "user", &dto.ReserveRequest{ServiceID: "shop", OrderID: "2", Currency: 50})
+	if !errors.Is(err, client.ErrReserveInvalidValue) {
+		t.Fatalf("expected ErrReserveInvalidValue, got %v", err)
+	}
+
+	err = c.CommitReserve(ctx, "user", &dto.CommitReserveRequest{ServiceID: "shop", OrderID: "1", Currency: 50})
+	if !errors.Is(err, client.ErrCommitInvalidValue) {
+		t.Fatalf("expected ErrCommitInvalidValue, got %v", err)
+	}
+
+	err = c.CreditBalance(ctx, "user", &dto.CreditRequest{Currency: -1})
+	if !errors.Is(err, client.ErrInvalidParam) {
+		t.Fatalf("expected ErrInvalidParam, got %v", err)
+	}
+
+	var respErr *client.ResponseError
+	if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected bad request response error, got %v", err)
+	}
+
+	if respErr.Code != dto.ErrCodeInvalidParam || respErr.Details["param"] != "currency" {
+		t.Fatalf("unexpected response error %+v", respErr)
+	}
+}
+
+func TestClient_RetryCreditOnce(t *testing.T) {
+	ctx := context.Background()
+	svc := newFakeService()
+	c, handler := newTestClient(t, svc, 2)
+
+	if err := c.CreditBalance(ctx, "user", &dto.CreditRequest{Currency: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	if svc.balances["user"] != 100 {
+		t.Fatalf("expected credit applied once, balance %d", svc.balances["user"])
+	}
+
+	if len(handler.keys) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(handler.keys))
+	}
+
+	for _, key := range handler.keys {
+		if key == "" || key != handler.keys[0] {
+			t.Fatalf("expected same idempotency key, got %v", handler.keys)
+		}
+	}
+}
+
+func TestClient_RetryReserve(t *testing.T) {
+	ctx := context.Background()
+	svc := newFakeService()
+	svc.balances["user"] = 10
+	c, _ := newTestClient(t, svc, 1)
+
+	err := c.ReserveFromBalance(ctx, "user", &dto.ReserveRequest{ServiceID: "shop", OrderID: "1", Currency: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if svc.balances["user"] != 5 {
+		t.Fatalf("expected reserve applied once, balance %d", svc.balances["user"])
+	}
+}
+
+func TestClient_CommitNotRetried(t *testing.T) {
+	c, handler := newTestClient(t, newFakeService(), 1)
+
+	err := c.CommitReserve(context.Background(), "user", &dto.CommitReserveRequest{
+		ServiceID: "shop",
+		OrderID:   "1",
+		Currency:  5,
+	})
+	if !errors.Is(err, client.ErrInternal) {
+		t.Fatalf("expected ErrInternal, got %v", err)
+	}
+
+	if len(handler.keys) != 1 {
+		t.Fatalf("expected 1 attempt, got %d", len(handler.keys))
+	}
+}
+
+func TestClient_Timeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := client.New(ts.URL, client.WithTimeout(10*time.Millisecond), client.WithRetries(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.GetUserBalance(context.Background(), "user")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestClient_ListOperations(t *testing.T) {
+	c, _ := newTestClient(t, newFakeService(), 0)
+
+	desc := false
+	operations, err := c.ListOperations(context.Background(), "user", &client.ListParams{
+		Limit:   5,
+		OrderBy: client.OrderByValue,
+		Desc:    &desc,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(operations) != 1 || operations[0].OrderID != "1" {
+		t.Fatalf("unexpected operations %v", operations)
+	}
+}
+
+func TestClient_Auth(t *testing.T) {
+	ctx := context.Background()
+	svc := newFakeService()
+	svc.balances["user"] = 10
+	srv := newTestServer(t, svc, 0)
+
+	anonymous, err := client.New(srv.url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = anonymous.GetUserBalance(ctx, "user"); !errors.Is(err, client.ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+
+	reader := srv.client(t, "shop", entities.PermissionRead)
+
+	if _, err = reader.GetUserBalance(ctx, "user"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = reader.CreditBalance(ctx, "user", &dto.CreditRequest{Currency: 100})
+	if !errors.Is(err, client.ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+
+	shop := srv.client(t, "shop", entities.PermissionReserve, entities.PermissionCommit)
+
+	err = shop.ReserveFromBalance(ctx, "user", &dto.ReserveRequest{ServiceID: "other", OrderID: "1", Currency: 5})
+	if !errors.Is(err, client.ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+
+	err = shop.CommitReserve(ctx, "user", &dto.CommitReserveRequest{ServiceID: "other", OrderID: "1", Currency: 5})
+	if !errors.Is(err, client.ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+
+	if svc.balances["user"] != 10 {
+		t.Fatalf("expected balance untouched, got %d", svc.balances["user"])
+	}
+}
+
+func TestClient_RateLimited(t *testing.T) {
+	ctx := context.Background()
+	srv := newTestServer(t, newFakeService(), 0, httpport.RateLimitRule{
+		Route: "POST /api/v1/balances/{user_id}/credit",
+		By:    httpport.RateLimitByClient,
+		Rate:  0.01,
+		Burst: 2,
+	})
+
+	shop := srv.client(t, "shop", entities.PermissionCredit, entities.PermissionRead)
+	other := srv.client(t, "other", entities.PermissionCredit)
+
+	for i := 0; i < 2; i++ {
+		if err := shop.CreditBalance(ctx, "user", &dto.CreditRequest{Currency: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := shop.CreditBalance(ctx, "user", &dto.CreditRequest{Currency: 1})
+	if !errors.Is(err, client.ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+
+	if err = other.CreditBalance(ctx, "user", &dto.CreditRequest{Currency: 1}); err != nil {
+		t.Fatalf("expected other service to have own bucket, got %v", err)
+	}
+
+	if _, err = shop.GetUserBalance(ctx, "user"); err != nil {
+		t.Fatalf("expected other routes not limited, got %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, srv.url+"/api/v1/balances/user/credit", strings.NewReader(`{"currency": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, secret, err := srv.auth.CreateAPIKey(ctx, "shop", []entities.Permission{entities.PermissionCredit})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("X-API-Key", secret)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+}
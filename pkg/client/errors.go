@@ -0,0 +1,91 @@
+package client
+
+import (
+	"github.com/pkg/errors"
+	"net/http"
+	"service/pkg/dto"
+)
+
+// Sentinels mirror service errors, check them with errors.Is.
+var (
+	ErrInvalidParam         = errors.New("invalid param")
+	ErrReserveAlreadyExists = errors.New("order already exists")
+	ErrReserveInvalidValue  = errors.New("reserve invalid value")
+	ErrCommitInvalidValue   = errors.New("commit invalid value")
+	ErrNotFound             = errors.New("not found")
+	ErrUnauthorized         = errors.New("unauthorized")
+	ErrForbidden            = errors.New("forbidden")
+	ErrRateLimited          = errors.New("rate limited")
+	ErrInternal             = errors.New("internal")
+)
+
+var codeErrors = map[string]error{
+	dto.ErrCodeInvalidParam:        ErrInvalidParam,
+	dto.ErrCodeReserveExists:       ErrReserveAlreadyExists,
+	dto.ErrCodeInsufficientFunds:   ErrReserveInvalidValue,
+	dto.ErrCodeInsufficientReserve: ErrCommitInvalidValue,
+	dto.ErrCodeNotFound:            ErrNotFound,
+	dto.ErrCodeUnauthorized:        ErrUnauthorized,
+	dto.ErrCodeForbidden:           ErrForbidden,
+	dto.ErrCodeRateLimited:         ErrRateLimited,
+	dto.ErrCodeInternal:            ErrInternal,
+}
+
+// ResponseError is returned for every non 2xx response.
+type ResponseError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Details    map[string]string
+	RequestID  string
+	err        error
+}
+
+func (e *ResponseError) Error() string {
+	return e.Message
+}
+
+func (e *ResponseError) Unwrap() error {
+	return e.err
+}
+
+func newResponseError(status int, resp *dto.ErrResponse) *ResponseError {
+	message := resp.Detail
+	if message == "" {
+		message = resp.Message
+	}
+
+	err, ok := codeErrors[resp.Code]
+	if !ok {
+		err = statusError(status)
+	}
+
+	return &ResponseError{
+		StatusCode: status,
+		Code:       resp.Code,
+		Message:    message,
+		Details:    resp.Details,
+		RequestID:  resp.RequestID,
+		err:        err,
+	}
+}
+
+// statusError is a fallback for responses without code, e.g. from a proxy.
+func statusError(status int) error {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrInvalidParam
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden:
+		return ErrForbidden
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusConflict:
+		return ErrReserveAlreadyExists
+	}
+
+	return ErrInternal
+}
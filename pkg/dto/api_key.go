@@ -0,0 +1,41 @@
+package dto
+
+import (
+	"service/internal/entities"
+	"time"
+)
+
+// CreateAPIKeyRequest
+//
+// swagger:model
+type CreateAPIKeyRequest struct {
+	ServiceID string `json:"service_id"`
+	// Any of credit, reserve, commit, read
+	Permissions []string `json:"permissions"`
+}
+
+// APIKey info
+//
+// swagger:model
+type APIKey struct {
+	ID          string    `json:"id"`
+	ServiceID   string    `json:"service_id"`
+	Permissions []string  `json:"permissions"`
+	CreatedAt   time.Time `json:"created_at"`
+	// Secret to send in X-API-Key header, returned only once on creation
+	Key string `json:"key,omitempty"`
+}
+
+func ToAPIKey(key *entities.APIKey) APIKey {
+	permissions := make([]string, 0, len(key.Permissions()))
+	for _, p := range key.Permissions() {
+		permissions = append(permissions, string(p))
+	}
+
+	return APIKey{
+		ID:          key.ID(),
+		ServiceID:   key.ServiceID(),
+		Permissions: permissions,
+		CreatedAt:   key.CreatedAt(),
+	}
+}
@@ -1,8 +1,32 @@
 package dto
 
-// ErrResponse
+import "service/pkg/errcodes"
+
+// Error codes of ErrResponse.
+const (
+	ErrCodeInvalidParam        = errcodes.InvalidParam
+	ErrCodeReserveExists       = errcodes.ReserveExists
+	ErrCodeInsufficientFunds   = errcodes.InsufficientFunds
+	ErrCodeInsufficientReserve = errcodes.InsufficientReserve
+	ErrCodeNotFound            = errcodes.NotFound
+	ErrCodeUnauthorized        = errcodes.Unauthorized
+	ErrCodeForbidden           = errcodes.Forbidden
+	ErrCodeRateLimited         = errcodes.RateLimited
+	ErrCodeInternal            = errcodes.Internal
+)
+
+// ErrResponse RFC 7807 problem details, served as application/problem+json
 //
 // swagger:model
 type ErrResponse struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Stable machine-readable error code, e.g. INSUFFICIENT_FUNDS
+	Code      string            `json:"code"`
+	Details   map[string]string `json:"details,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+	// Deprecated: same as Detail
 	Message string
 }
@@ -0,0 +1,15 @@
+// Package errcodes declares stable error codes of the balance API, returned in
+// code of HTTP problem responses and in reason of gRPC ErrorInfo.
+package errcodes
+
+const (
+	InvalidParam        = "INVALID_PARAM"
+	ReserveExists       = "RESERVE_EXISTS"
+	InsufficientFunds   = "INSUFFICIENT_FUNDS"
+	InsufficientReserve = "INSUFFICIENT_RESERVE"
+	NotFound            = "NOT_FOUND"
+	Unauthorized        = "UNAUTHORIZED"
+	Forbidden           = "FORBIDDEN"
+	RateLimited         = "RATE_LIMITED"
+	Internal            = "INTERNAL"
+)